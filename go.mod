@@ -4,12 +4,14 @@ go 1.25.0
 
 require (
 	github.com/askasoft/pango v1.2.16
+	github.com/klauspost/compress v1.20.1
+	github.com/mattn/go-sqlite3 v1.14.33
 	golang.org/x/crypto v0.52.0
+	golang.org/x/text v0.37.0
 )
 
 require (
 	github.com/fsnotify/fsnotify v1.10.1 // indirect
 	golang.org/x/net v0.55.0 // indirect
 	golang.org/x/sys v0.45.0 // indirect
-	golang.org/x/text v0.37.0 // indirect
 )
@@ -0,0 +1,349 @@
+package xfs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStats the statistics of the CacheFS
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	Count  int   `json:"count"`
+	Size   int64 `json:"size"`
+}
+
+// HitRatio returns the ratio of the hits to the total reads
+func (cs CacheStats) HitRatio() float64 {
+	if total := cs.Hits + cs.Misses; total > 0 {
+		return float64(cs.Hits) / float64(total)
+	}
+	return 0
+}
+
+type cacheEntry struct {
+	id    string
+	data  []byte
+	large bool // the file is too large to cache, the reads are passed through to the wrapped XFS
+}
+
+// size returns the size of the entry in the cache, the id length is counted for the too large file
+func (ce *cacheEntry) size() int64 {
+	if ce.large {
+		return int64(len(ce.id))
+	}
+	return int64(len(ce.data))
+}
+
+// CacheFS wraps a XFS to keep the recently read file data in a LRU cache bounded by MaxSize.
+// The file data is cached by ReadFile(), OpenReader() and ReadFileAt(), the file larger than MaxFileSize is not cached,
+// but the id of it is cached (without the data), so the reads of it are passed through without finding the file again.
+// The cached data of a file is invalidated by SaveFile/SaveFileReader/CopyFile/MoveFile/DeleteFile/DeleteFiles/DeletePrefix/DeletePrefixBefore,
+// and all cached data is cleared by the other bulk delete (DeleteTagged, DeleteBefore, ...).
+// The file metadata is not cached.
+// The zero value CacheFS{XFS: xfs} is ready to use with the default MaxSize and MaxFileSize.
+type CacheFS struct {
+	XFS
+
+	// MaxSize the maximum total size of the cached data, default 64MB
+	MaxSize int64
+
+	// MaxFileSize the maximum size of the file to cache, default 1MB
+	MaxFileSize int64
+
+	mu     sync.Mutex
+	lru    *list.List
+	items  map[string]*list.Element
+	size   int64
+	gen    uint64 // incremented by the invalidation to discard the data loaded before it
+	hits   int64
+	misses int64
+}
+
+// NewCacheFS create a CacheFS with the maximum total cache size
+func NewCacheFS(xfs XFS, maxSize int64) *CacheFS {
+	return &CacheFS{
+		XFS:         xfs,
+		MaxSize:     maxSize,
+		MaxFileSize: 1 << 20,
+	}
+}
+
+// Unwrap returns the wrapped XFS
+func (cfs *CacheFS) Unwrap() XFS {
+	return cfs.XFS
+}
+
+// init initialize the lru list and the items map of the zero value CacheFS, it must be called with the lock
+func (cfs *CacheFS) init() {
+	if cfs.lru == nil {
+		cfs.lru = list.New()
+		cfs.items = make(map[string]*list.Element)
+	}
+}
+
+// Stats returns the statistics of the cache
+func (cfs *CacheFS) Stats() CacheStats {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	cfs.init()
+
+	return CacheStats{
+		Hits:   cfs.hits,
+		Misses: cfs.misses,
+		Count:  cfs.lru.Len(),
+		Size:   cfs.size,
+	}
+}
+
+// Clear remove all cached data, the statistics of the hits and misses are not reset
+func (cfs *CacheFS) Clear() {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	cfs.init()
+	cfs.lru.Init()
+	clear(cfs.items)
+	cfs.size = 0
+	cfs.gen++
+}
+
+func (cfs *CacheFS) maxSize() int64 {
+	if cfs.MaxSize > 0 {
+		return cfs.MaxSize
+	}
+	return 64 << 20
+}
+
+func (cfs *CacheFS) maxFileSize() int64 {
+	if cfs.MaxFileSize > 0 {
+		return cfs.MaxFileSize
+	}
+	return 1 << 20
+}
+
+// get returns the cached data and the generation of the cache.
+// Returns nil data and true if the file is cached as too large, it is not counted as a hit or a miss.
+func (cfs *CacheFS) get(id string) ([]byte, uint64, bool) {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	cfs.init()
+	if e, ok := cfs.items[id]; ok {
+		cfs.lru.MoveToFront(e)
+
+		ce := e.Value.(*cacheEntry)
+		if !ce.large {
+			cfs.hits++
+		}
+		return ce.data, cfs.gen, true
+	}
+
+	cfs.misses++
+	return nil, cfs.gen, false
+}
+
+// put add the entry to the cache if the cache is not invalidated since the generation `gen`
+func (cfs *CacheFS) put(ce *cacheEntry, gen uint64) {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	if gen != cfs.gen {
+		return
+	}
+
+	cfs.init()
+	if e, ok := cfs.items[ce.id]; ok {
+		cfs.remove(e)
+	}
+
+	size := ce.size()
+	limit := cfs.maxSize()
+	if size > limit {
+		return
+	}
+
+	for cfs.size+size > limit {
+		cfs.remove(cfs.lru.Back())
+	}
+
+	cfs.items[ce.id] = cfs.lru.PushFront(ce)
+	cfs.size += size
+}
+
+func (cfs *CacheFS) remove(e *list.Element) {
+	ce := cfs.lru.Remove(e).(*cacheEntry)
+	delete(cfs.items, ce.id)
+	cfs.size -= ce.size()
+}
+
+// invalidate remove the cached data of the ids
+func (cfs *CacheFS) invalidate(ids ...string) {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	for _, id := range ids {
+		if e, ok := cfs.items[id]; ok {
+			cfs.remove(e)
+		}
+	}
+	cfs.gen++
+}
+
+// invalidatePrefix remove the cached data of the files which id starts with the prefix
+func (cfs *CacheFS) invalidatePrefix(prefix string) {
+	cfs.mu.Lock()
+	defer cfs.mu.Unlock()
+
+	for id, e := range cfs.items {
+		if strings.HasPrefix(id, prefix) {
+			cfs.remove(e)
+		}
+	}
+	cfs.gen++
+}
+
+// load returns the cached data of the file, or read and cache the file data.
+// Returns nil data without error if the file is too large to cache.
+func (cfs *CacheFS) load(id string) ([]byte, error) {
+	data, gen, ok := cfs.get(id)
+	if ok {
+		return data, nil
+	}
+
+	f, err := cfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if f.Size > cfs.maxFileSize() {
+		cfs.put(&cacheEntry{id: id, large: true}, gen)
+		return nil, nil
+	}
+
+	data, err = cfs.XFS.ReadFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cfs.put(&cacheEntry{id: id, data: data}, gen)
+	return data, nil
+}
+
+// Open open the file or the directory of the name, the file data is read through the cache
+func (cfs *CacheFS) Open(name string) (fs.File, error) {
+	return OpenFile(cfs, name)
+}
+
+// ReadFile read the file data, the name can be a file id or a fs.FS path name
+func (cfs *CacheFS) ReadFile(name string) ([]byte, error) {
+	id := FileID(name)
+
+	data, err := cfs.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return cfs.XFS.ReadFile(id)
+	}
+
+	// the cached data must not be modified by the caller
+	return bytes.Clone(data), nil
+}
+
+func (cfs *CacheFS) OpenReader(id string) (io.ReadCloser, error) {
+	data, err := cfs.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return cfs.XFS.OpenReader(id)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (cfs *CacheFS) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	data, err := cfs.load(id)
+	if err != nil {
+		return 0, err
+	}
+	if data == nil {
+		return cfs.XFS.ReadFileAt(id, p, off)
+	}
+	return bytes.NewReader(data).ReadAt(p, off)
+}
+
+func (cfs *CacheFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	defer cfs.invalidate(id)
+	return cfs.XFS.SaveFile(id, filename, filetime, data, tag...)
+}
+
+func (cfs *CacheFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	defer cfs.invalidate(id)
+	return cfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+}
+
+func (cfs *CacheFS) CopyFile(src, dst string, tag ...string) error {
+	defer cfs.invalidate(dst)
+	return cfs.XFS.CopyFile(src, dst, tag...)
+}
+
+func (cfs *CacheFS) MoveFile(src, dst string, tag ...string) error {
+	defer cfs.invalidate(src, dst)
+	return cfs.XFS.MoveFile(src, dst, tag...)
+}
+
+func (cfs *CacheFS) DeleteFile(id string) error {
+	defer cfs.invalidate(id)
+	return cfs.XFS.DeleteFile(id)
+}
+
+func (cfs *CacheFS) DeleteFiles(ids ...string) (int64, error) {
+	defer cfs.invalidate(ids...)
+	return cfs.XFS.DeleteFiles(ids...)
+}
+
+func (cfs *CacheFS) DeletePrefix(prefix string) (int64, error) {
+	defer cfs.invalidatePrefix(prefix)
+	return cfs.XFS.DeletePrefix(prefix)
+}
+
+func (cfs *CacheFS) DeleteTagged(tag string) (int64, error) {
+	defer cfs.Clear()
+	return cfs.XFS.DeleteTagged(tag)
+}
+
+func (cfs *CacheFS) DeleteBefore(before time.Time) (int64, error) {
+	defer cfs.Clear()
+	return cfs.XFS.DeleteBefore(before)
+}
+
+func (cfs *CacheFS) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	defer cfs.invalidatePrefix(prefix)
+	return cfs.XFS.DeletePrefixBefore(prefix, before)
+}
+
+func (cfs *CacheFS) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	defer cfs.Clear()
+	return cfs.XFS.DeleteTaggedBefore(tag, before)
+}
+
+func (cfs *CacheFS) DeleteWhere(where string, args ...any) (int64, error) {
+	defer cfs.Clear()
+	return cfs.XFS.DeleteWhere(where, args...)
+}
+
+func (cfs *CacheFS) DeleteAll() (int64, error) {
+	defer cfs.Clear()
+	return cfs.XFS.DeleteAll()
+}
+
+func (cfs *CacheFS) Truncate() error {
+	defer cfs.Clear()
+	return cfs.XFS.Truncate()
+}
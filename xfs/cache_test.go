@@ -0,0 +1,160 @@
+package xfs_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+)
+
+// findFS counts the FindFile() calls
+type findFS struct {
+	xfs.XFS
+	finds int
+}
+
+func (ffs *findFS) FindFile(id string) (*xfs.File, error) {
+	ffs.finds++
+	return ffs.XFS.FindFile(id)
+}
+
+func TestCacheFS(t *testing.T) {
+	cfs := xfs.NewCacheFS(memxfs.FS(), 10)
+	cfs.MaxFileSize = 6
+
+	mustRead := func(id, want string) {
+		t.Helper()
+
+		data, err := cfs.ReadFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("ReadFile(%q) = %q, want %q", id, data, want)
+		}
+	}
+
+	assertStats := func(hits, misses int64, count int, size int64) {
+		t.Helper()
+
+		cs := cfs.Stats()
+		if cs.Hits != hits || cs.Misses != misses || cs.Count != count || cs.Size != size {
+			t.Fatalf("Stats() = %+v, want {Hits:%d Misses:%d Count:%d Size:%d}", cs, hits, misses, count, size)
+		}
+	}
+
+	for id, data := range map[string]string{"/a": "aaaa", "/b": "bbbb", "/c": "cccc", "/big": "big data"} {
+		if _, err := cfs.SaveFile(id, id+".txt", time.Now(), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustRead("/a", "aaaa")
+	mustRead("/a", "aaaa")
+	assertStats(1, 1, 1, 4)
+
+	// too large to cache, only the id is cached
+	mustRead("/big", "big data")
+	mustRead("/big", "big data")
+	assertStats(1, 2, 2, 8)
+
+	// evict the least recently used "/a"
+	mustRead("/b", "bbbb")
+	mustRead("/c", "cccc")
+	assertStats(1, 4, 2, 8)
+
+	r, err := cfs.OpenReader("/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "cccc" {
+		t.Errorf("OpenReader(/c) = %q", data)
+	}
+	assertStats(2, 4, 2, 8)
+
+	// invalidate on save
+	if _, err := cfs.SaveFileReader("/c", "c.txt", time.Now(), strings.NewReader("CC")); err != nil {
+		t.Fatal(err)
+	}
+	assertStats(2, 4, 1, 4)
+	mustRead("/c", "CC")
+
+	// invalidate on move
+	if err := cfs.MoveFile("/c", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	assertStats(2, 5, 0, 0)
+	mustRead("/b", "CC")
+
+	p := make([]byte, 1)
+	if n, err := cfs.ReadFileAt("/b", p, 1); n != 1 || err != nil || p[0] != 'C' {
+		t.Errorf("ReadFileAt(/b) = (%d, %v, %q)", n, err, p)
+	}
+
+	if err := cfs.DeleteFile("/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfs.ReadFile("/b"); err == nil {
+		t.Error("ReadFile(deleted) should fail")
+	}
+
+	mustRead("/a", "aaaa")
+	if _, err := cfs.DeleteTagged(""); err != nil {
+		t.Fatal(err)
+	}
+	if cs := cfs.Stats(); cs.Count != 0 || cs.Size != 0 {
+		t.Errorf("Stats() after DeleteTagged() = %+v", cs)
+	}
+}
+
+func TestCacheFSZeroValue(t *testing.T) {
+	mfs := &findFS{XFS: memxfs.FS()}
+	cfs := &xfs.CacheFS{XFS: mfs, MaxFileSize: 4}
+
+	if cs := cfs.Stats(); cs.Count != 0 {
+		t.Errorf("Stats() = %+v", cs)
+	}
+
+	for id, data := range map[string]string{"/a": "aaaa", "/big": "big data"} {
+		if _, err := cfs.SaveFile(id, id+".txt", time.Now(), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := cfs.ReadFile("/a"); err != nil || string(data) != "aaaa" {
+		t.Errorf("ReadFile(/a) = %q, %v", data, err)
+	}
+
+	// the ranged reads of the too large file find the file once
+	mfs.finds = 0
+	p := make([]byte, 2)
+	for i := range 4 {
+		if n, err := cfs.ReadFileAt("/big", p, int64(i*2)); n != 2 || err != nil {
+			t.Fatalf("ReadFileAt(/big, %d) = %d, %v", i*2, n, err)
+		}
+	}
+	if mfs.finds != 1 {
+		t.Errorf("FindFile() calls = %d, want 1", mfs.finds)
+	}
+	if cs := cfs.Stats(); cs.Hits != 0 || cs.Misses != 2 || cs.Count != 2 {
+		t.Errorf("Stats() = %+v", cs)
+	}
+
+	// the too large file is read again after it is updated
+	if _, err := cfs.SaveFile("/big", "big.txt", time.Now(), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cfs.ReadFile("/big"); err != nil || string(data) != "b" {
+		t.Errorf("ReadFile(/big) = %q, %v", data, err)
+	}
+
+	cfs.Clear()
+	if cs := cfs.Stats(); cs.Count != 0 || cs.Size != 0 {
+		t.Errorf("Stats() after Clear() = %+v", cs)
+	}
+}
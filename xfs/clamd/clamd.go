@@ -0,0 +1,183 @@
+package clamd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+)
+
+// ErrResponse indicates the clamd responds a error
+var ErrResponse = errors.New("clamd: error response")
+
+// Client is a ClamAV clamd client which implements the xfs.Scanner interface by the INSTREAM command.
+// See https://docs.clamav.net/manual/Usage/Scanning.html#clamd for the clamd protocol.
+type Client struct {
+	// Network the network of the clamd address, "tcp" or "unix"
+	Network string
+
+	// Address the clamd address, for example "127.0.0.1:3310" or "/run/clamav/clamd.ctl"
+	Address string
+
+	// Timeout the connection and read/write timeout, default 60s
+	Timeout time.Duration
+
+	// ChunkSize the data chunk size of the INSTREAM command, default 64KB.
+	// It should be less than the StreamMaxLength of the clamd.conf.
+	ChunkSize int
+}
+
+// NewClient create a clamd client
+func NewClient(network, address string) *Client {
+	return &Client{
+		Network:   network,
+		Address:   address,
+		Timeout:   time.Minute,
+		ChunkSize: 64 << 10,
+	}
+}
+
+// Name returns "clamd"
+func (c *Client) Name() string {
+	return "clamd"
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	d := net.Dialer{Timeout: c.Timeout}
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	} else if c.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	return conn, nil
+}
+
+// command send the null terminated command "z{cmd}\0", call the send function to send the command data,
+// and returns the null terminated response.
+func (c *Client) command(ctx context.Context, cmd string, send func(w io.Writer) error) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	// cancel the blocking read/write when the context is done
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	bw := bufio.NewWriter(conn)
+	if _, err := bw.WriteString("z" + cmd + "\x00"); err != nil {
+		return "", err
+	}
+	if send != nil {
+		if err := send(bw); err != nil {
+			return "", err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return "", err
+	}
+
+	res, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && res != "") {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+	return strings.TrimRight(res, "\x00\n"), nil
+}
+
+// Ping send the PING command, returns nil if clamd responds "PONG"
+func (c *Client) Ping(ctx context.Context) error {
+	res, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if res != "PONG" {
+		return fmt.Errorf("%w: %s", ErrResponse, res)
+	}
+	return nil
+}
+
+// Version send the VERSION command, returns the clamd version
+func (c *Client) Version(ctx context.Context) (string, error) {
+	return c.command(ctx, "VERSION", nil)
+}
+
+// Scan send the data read from r by the INSTREAM command, and returns the scan result.
+// The response "stream: OK" is not infected, the response "stream: {signature} FOUND" is infected,
+// the other responses (for example "INSTREAM size limit exceeded. ERROR") are returned as ErrResponse.
+func (c *Client) Scan(ctx context.Context, r io.Reader) (*xfs.ScanResult, error) {
+	res, err := c.command(ctx, "INSTREAM", func(w io.Writer) error {
+		return c.stream(w, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseResult(res)
+}
+
+// stream write the data chunks "{4 bytes length in network byte order}{data}", and the terminator chunk of length 0
+func (c *Client) stream(w io.Writer, r io.Reader) error {
+	size := c.ChunkSize
+	if size <= 0 {
+		size = 64 << 10
+	}
+
+	buf := make([]byte, 4+size)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func parseResult(res string) (*xfs.ScanResult, error) {
+	_, s, ok := strings.Cut(res, ": ")
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrResponse, res)
+	}
+
+	sr := &xfs.ScanResult{Scanner: "clamd", Time: time.Now()}
+
+	switch {
+	case s == "OK":
+		return sr, nil
+	case strings.HasSuffix(s, " FOUND"):
+		sr.Infected = true
+		sr.Signature = strings.TrimSuffix(s, " FOUND")
+		return sr, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrResponse, res)
+	}
+}
@@ -0,0 +1,122 @@
+package clamd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeClamd serves the clamd PING and INSTREAM commands, the stream which contains "EICAR" is infected.
+func fakeClamd(t *testing.T, network, address string) string {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func serveClamd(conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReader(conn)
+	cmd, err := br.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if size > 1024 {
+				_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+			if _, err := io.CopyN(&data, br, int64(size)); err != nil {
+				return
+			}
+		}
+
+		if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+			_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			_, _ = conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func testClient(t *testing.T, c *Client) {
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping() = %v", err)
+	}
+
+	sr, err := c.Scan(ctx, strings.NewReader(strings.Repeat("clean data ", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Infected {
+		t.Errorf("Scan(clean) = %+v", sr)
+	}
+
+	sr, err = c.Scan(ctx, strings.NewReader(strings.Repeat("x", 200)+"EICAR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sr.Infected || sr.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Scan(infected) = %+v", sr)
+	}
+}
+
+func TestClientTCP(t *testing.T) {
+	addr := fakeClamd(t, "tcp", "127.0.0.1:0")
+
+	c := NewClient("tcp", addr)
+	c.ChunkSize = 64
+	testClient(t, c)
+
+	c.ChunkSize = 2048
+	_, err := c.Scan(context.Background(), strings.NewReader(strings.Repeat("x", 2000)))
+	if !errors.Is(err, ErrResponse) {
+		t.Errorf("Scan(large) = %v, want %v", err, ErrResponse)
+	}
+}
+
+func TestClientUnix(t *testing.T) {
+	addr := fakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.sock"))
+
+	c := NewClient("unix", addr)
+	c.ChunkSize = 512
+	testClient(t, c)
+}
@@ -0,0 +1,602 @@
+package xfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/str"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressReaders the maximum count of the idle decompressing readers kept by a CompressFS for ReadFileAt()
+var CompressReaders = 8
+
+// CompressMaxPeek the maximum size of the leading data to peek by SaveFileReader() to check the CompressRule.MinSize,
+// the data not less than CompressMaxPeek bytes matches the rules which MinSize is larger than it.
+var CompressMaxPeek = 1 << 20
+
+// Codec a compression codec, the Name() is the HTTP content coding name (for example "gzip")
+type Codec interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	// GzipCodec the "gzip" codec
+	GzipCodec Codec = gzipCodec{}
+
+	// DeflateCodec the "deflate" (zlib format) codec
+	DeflateCodec Codec = deflateCodec{}
+
+	// ZstdCodec the "zstd" codec
+	ZstdCodec Codec = zstdCodec{}
+)
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return "gzip"
+}
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string {
+	return "deflate"
+}
+
+func (deflateCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+func (deflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string {
+	return "zstd"
+}
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+// CompressRule selects the codec to compress the file which extension is in Exts and size is not less than MinSize
+type CompressRule struct {
+	Codec   Codec
+	Exts    []string // the lower case file extensions (for example ".csv"), empty matches all files
+	MinSize int64    // the minimum file size to compress, see CompressMaxPeek
+}
+
+func (cr *CompressRule) matchExt(ext string) bool {
+	return len(cr.Exts) == 0 || slices.Contains(cr.Exts, ext)
+}
+
+// CompressFS wraps a XFS to compress the file data on save by the first matched CompressRule,
+// and decompress it on read. The compressed file is marked by the codec name content coding of the File.Codec,
+// so the wrapped XFS must be a EncodedSaver. The file without the mark is treated as a plain file.
+//
+// FindFile(), ListPrefix() and FindFiles() return the compressed file with the original size (File.RawSize),
+// and the File.Codec is kept, so ServeFile() can serve the compressed data directly if the client accepts the encoding.
+// The CompressFS can not be the source or destination of the Migrator, migrate the files of the wrapped XFS instead.
+//
+// ReadFileAt() keeps at most CompressReaders idle decompressing readers,
+// so the sequential reads of a compressed file (for example the Range requests of FSFile) do not decompress
+// the data from the beginning of the file every time.
+//
+// Note:
+// The File.Hash is the hash of the compressed data, and SumSize() sums the size of the compressed data.
+type CompressFS struct {
+	XFS
+
+	rules  []*CompressRule
+	codecs map[string]Codec
+	peek   int
+
+	mu  sync.Mutex
+	zrs []*zreader // idle decompressing readers
+}
+
+// NewCompressFS create a CompressFS with the compression rules.
+// The gzip, deflate and zstd codecs are always available to decompress the file data.
+func NewCompressFS(xfs XFS, rules ...*CompressRule) *CompressFS {
+	zfs := &CompressFS{
+		XFS:   xfs,
+		rules: rules,
+		codecs: map[string]Codec{
+			GzipCodec.Name():    GzipCodec,
+			DeflateCodec.Name(): DeflateCodec,
+			ZstdCodec.Name():    ZstdCodec,
+		},
+	}
+
+	for _, cr := range rules {
+		zfs.codecs[cr.Codec.Name()] = cr.Codec
+		zfs.peek = max(zfs.peek, int(cr.MinSize))
+	}
+	return zfs
+}
+
+// Unwrap returns the wrapped XFS
+func (zfs *CompressFS) Unwrap() XFS {
+	return zfs.XFS
+}
+
+// selectCodec returns the codec of the first matched rule, or nil
+func (zfs *CompressFS) selectCodec(filename string, size int64) Codec {
+	ext := str.ToLower(filepath.Ext(filename))
+	for _, cr := range zfs.rules {
+		if cr.matchExt(ext) && size >= cr.MinSize {
+			return cr.Codec
+		}
+	}
+	return nil
+}
+
+// codec returns the codec of the last content coding of the file, or nil if the file is not compressed
+func (zfs *CompressFS) codec(f *File) Codec {
+	_, last := cutCoding(f.Codec)
+	return zfs.codecs[last]
+}
+
+// decode set the original size of the compressed file
+func (zfs *CompressFS) decode(f *File) {
+	if zfs.codec(f) != nil {
+		f.Size = f.RawSize
+	}
+}
+
+func (zfs *CompressFS) decodes(files []*File, err error) ([]*File, error) {
+	for _, f := range files {
+		zfs.decode(f)
+	}
+	return files, err
+}
+
+// save save the data read from r compressed by the codec with the encoding metadata
+func (zfs *CompressFS) save(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, tag ...string) (*File, error) {
+	es, err := encodedSaver(zfs.XFS)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := es.SaveEncodedFile(id, filename, filetime, r, enc, tag...)
+	if err != nil {
+		return nil, err
+	}
+
+	zfs.decode(f)
+	return f, nil
+}
+
+// compress write the data read from r compressed by the codec to w
+func compress(w io.Writer, codec Codec, r io.Reader) error {
+	cw, err := codec.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(cw, r); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+//----------------------------------------------------
+
+// FindFile find a file, the file size is the original data size
+func (zfs *CompressFS) FindFile(id string) (*File, error) {
+	f, err := zfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	zfs.decode(f)
+	return f, nil
+}
+
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (zfs *CompressFS) ListPrefix(prefix string) ([]*File, error) {
+	return zfs.decodes(zfs.XFS.ListPrefix(prefix))
+}
+
+// FindFiles find the files by the query
+func (zfs *CompressFS) FindFiles(fq *FileQuery) ([]*File, error) {
+	return zfs.decodes(zfs.XFS.FindFiles(fq))
+}
+
+func (zfs *CompressFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	codec := zfs.selectCodec(filename, int64(len(data)))
+	if codec == nil {
+		return zfs.XFS.SaveFile(id, filename, filetime, data, tag...)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := compress(buf, codec, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	enc := &Encoding{
+		Codec:   codec.Name(),
+		RawSize: int64(len(data)),
+		MIME:    DetectMIME(data, str.ToLower(filepath.Ext(filename))),
+	}
+
+	f, err := zfs.save(id, filename, filetime, buf, enc, tag...)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Data = data
+	return f, nil
+}
+
+func (zfs *CompressFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	// peek the leading data to check the minimum size of the rules
+	n := min(zfs.peek, CompressMaxPeek)
+	bs, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(len(bs))
+	if len(bs) == n {
+		// the data is not less than the peeked size
+		size = math.MaxInt64
+	}
+
+	br := io.MultiReader(bytes.NewReader(bs), r)
+
+	codec := zfs.selectCodec(filename, size)
+	if codec == nil {
+		return zfs.XFS.SaveFileReader(id, filename, filetime, br, tag...)
+	}
+
+	// the original size and MIME are set before the compressed data is read to EOF
+	enc := &Encoding{Codec: codec.Name()}
+
+	pr, pw := io.Pipe()
+	go func() {
+		hr := NewHashReader(br)
+		err := compress(pw, codec, hr)
+		enc.RawSize, enc.MIME = hr.Size(), hr.MIME(str.ToLower(filepath.Ext(filename)))
+		pw.CloseWithError(err)
+	}()
+
+	f, err := zfs.save(id, filename, filetime, pr, enc, tag...)
+
+	// stop the compress goroutine if the data is not read completely
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	return f, err
+}
+
+// SaveEncodedFile save the encoded data read from the reader to the wrapped XFS without compression
+// (for example the data encrypted by CryptFS).
+func (zfs *CompressFS) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, tag ...string) (*File, error) {
+	return zfs.save(id, filename, filetime, r, enc, tag...)
+}
+
+// ReadFile read the original data of the file, the name can be a file id or a fs.FS path name.
+func (zfs *CompressFS) ReadFile(name string) ([]byte, error) {
+	r, err := zfs.OpenReader(FileID(name))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// openReader open a reader to decompress the data of the compressed file
+func (zfs *CompressFS) openReader(f *File, codec Codec) (io.ReadCloser, error) {
+	rc, err := zfs.XFS.OpenReader(f.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := codec.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &zreadCloser{zr, rc}, nil
+}
+
+// OpenReader open a reader to read the original data of the file, the caller should close the reader
+func (zfs *CompressFS) OpenReader(id string) (io.ReadCloser, error) {
+	f, err := zfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	codec := zfs.codec(f)
+	if codec == nil {
+		return zfs.XFS.OpenReader(id)
+	}
+	return zfs.openReader(f, codec)
+}
+
+// takeReader take out the idle decompressing reader of the file which offset is not greater than off,
+// or open a new one
+func (zfs *CompressFS) takeReader(f *File, codec Codec, off int64) (*zreader, error) {
+	zfs.mu.Lock()
+	for i, zr := range zfs.zrs {
+		if zr.id == f.ID && zr.hash == f.Hash && zr.off <= off {
+			zfs.zrs = slices.Delete(zfs.zrs, i, i+1)
+			zfs.mu.Unlock()
+			return zr, nil
+		}
+	}
+	zfs.mu.Unlock()
+
+	r, err := zfs.openReader(f, codec)
+	if err != nil {
+		return nil, err
+	}
+	return &zreader{id: f.ID, hash: f.Hash, r: r}, nil
+}
+
+// putReader put back the idle decompressing reader, the oldest idle reader is closed if there are too many
+func (zfs *CompressFS) putReader(zr *zreader) {
+	var old *zreader
+
+	zfs.mu.Lock()
+	zfs.zrs = append(zfs.zrs, zr)
+	if len(zfs.zrs) > CompressReaders {
+		old, zfs.zrs = zfs.zrs[0], slices.Delete(zfs.zrs, 0, 1)
+	}
+	zfs.mu.Unlock()
+
+	if old != nil {
+		old.r.Close()
+	}
+}
+
+// ReadFileAt read len(p) bytes of the original data of the file starting at the offset `off`.
+// The compressed data is decompressed by the idle reader of the previous read if possible.
+func (zfs *CompressFS) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	f, err := zfs.XFS.FindFile(id)
+	if err != nil {
+		return 0, err
+	}
+
+	codec := zfs.codec(f)
+	if codec == nil {
+		return zfs.XFS.ReadFileAt(id, p, off)
+	}
+
+	zr, err := zfs.takeReader(f, codec, off)
+	if err != nil {
+		return 0, err
+	}
+
+	if zr.off < off {
+		n, err := io.CopyN(io.Discard, zr.r, off-zr.off)
+		zr.off += n
+		if err != nil {
+			zr.r.Close()
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(zr.r, p)
+	zr.off += int64(n)
+	if err != nil {
+		// the reader is not reusable after EOF or error
+		zr.r.Close()
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	zfs.putReader(zr)
+	return n, nil
+}
+
+// Open open the file or the directory of the name, see OpenFile() for details.
+// The returned compressed file decompresses the data sequentially.
+func (zfs *CompressFS) Open(name string) (fs.File, error) {
+	file, err := OpenFile(zfs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ff, ok := file.(*FSFile); ok && zfs.codec(ff.File) != nil {
+		return &zfile{zfs: zfs, f: ff.File}, nil
+	}
+	return file, nil
+}
+
+// Stat returns a fs.FileInfo describing the file or the directory of the name
+func (zfs *CompressFS) Stat(name string) (fs.FileInfo, error) {
+	return Stat(zfs, name)
+}
+
+// ReadDir reads the directory of the name and returns a list of directory entries sorted by filename.
+func (zfs *CompressFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return ReadDir(zfs, name)
+}
+
+// EncodedContent returns a io.ReadSeeker of the stored compressed data of the file which File.Codec is not empty.
+func (zfs *CompressFS) EncodedContent(f *File) (io.ReadSeeker, error) {
+	sf, err := zfs.XFS.FindFile(f.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(&fileReaderAt{zfs.XFS, f.ID}, 0, sf.Size), nil
+}
+
+// DecodedContent returns a io.ReadSeeker of the decompressed data of the file which File.Codec is not empty.
+func (zfs *CompressFS) DecodedContent(f *File) (io.ReadSeeker, error) {
+	if zfs.codec(f) == nil {
+		return &FSFile{XFS: zfs, File: f}, nil
+	}
+	return &zfile{zfs: zfs, f: f}, nil
+}
+
+//----------------------------------------------------
+
+// fileReaderAt implements io.ReaderAt by XFS.ReadFileAt()
+type fileReaderAt struct {
+	xfs XFS
+	id  string
+}
+
+func (fra *fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return fra.xfs.ReadFileAt(fra.id, p, off)
+}
+
+// zreader a decompressing reader of the file at the offset off
+type zreader struct {
+	id   string
+	hash string
+	r    io.ReadCloser
+	off  int64
+}
+
+// zreadCloser closes the decompressing reader and the underlying reader
+type zreadCloser struct {
+	zr io.ReadCloser
+	rc io.ReadCloser
+}
+
+func (zrc *zreadCloser) Read(p []byte) (int, error) {
+	return zrc.zr.Read(p)
+}
+
+func (zrc *zreadCloser) Close() error {
+	zrc.zr.Close()
+	return zrc.rc.Close()
+}
+
+// zfile a compressed file which decompresses the data sequentially,
+// the decompressing reader is reopened only when seeking backward.
+type zfile struct {
+	zfs  *CompressFS
+	f    *File
+	off  int64         // seek offset
+	r    io.ReadCloser // decompressing reader
+	roff int64         // read offset of r
+}
+
+func (zf *zfile) Close() error {
+	if zf.r != nil {
+		err := zf.r.Close()
+		zf.r = nil
+		return err
+	}
+	return nil
+}
+
+func (zf *zfile) Read(p []byte) (int, error) {
+	if zf.off >= zf.f.Size {
+		return 0, io.EOF
+	}
+
+	if zf.r != nil && zf.roff > zf.off {
+		zf.Close()
+	}
+
+	if zf.r == nil {
+		r, err := zf.zfs.openReader(zf.f, zf.zfs.codec(zf.f))
+		if err != nil {
+			return 0, err
+		}
+		zf.r, zf.roff = r, 0
+	}
+
+	if zf.roff < zf.off {
+		n, err := io.CopyN(io.Discard, zf.r, zf.off-zf.roff)
+		zf.roff += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := zf.r.Read(p)
+	zf.roff += int64(n)
+	zf.off = zf.roff
+	return n, err
+}
+
+func (zf *zfile) ReadAt(p []byte, off int64) (int, error) {
+	return zf.zfs.ReadFileAt(zf.f.ID, p, off)
+}
+
+func (zf *zfile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += zf.off
+	case io.SeekEnd:
+		offset += zf.f.Size
+	default:
+		return 0, fs.ErrInvalid
+	}
+
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	zf.off = offset
+	return offset, nil
+}
+
+func (zf *zfile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+func (zf *zfile) Stat() (fs.FileInfo, error) {
+	return &FSFileInfo{zf.f}, nil
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header accepts the content coding
+func acceptsEncoding(ae, coding string) bool {
+	for _, s := range strings.Split(ae, ",") {
+		c, q, _ := strings.Cut(s, ";")
+		c = strings.TrimSpace(c)
+		if !strings.EqualFold(c, coding) && c != "*" {
+			continue
+		}
+
+		q = strings.ReplaceAll(q, " ", "")
+		return !strings.HasPrefix(q, "q=0") || strings.Trim(q[3:], ".0") != ""
+	}
+	return false
+}
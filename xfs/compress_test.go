@@ -0,0 +1,226 @@
+package xfs_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+func TestCompressFS(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+	zfs := xfs.NewCompressFS(dfs,
+		&xfs.CompressRule{Codec: xfs.DeflateCodec, Exts: []string{".json"}},
+		&xfs.CompressRule{Codec: xfs.GzipCodec, Exts: []string{".csv", ".log"}, MinSize: 100},
+		&xfs.CompressRule{Codec: xfs.ZstdCodec, Exts: []string{".tsv"}},
+	)
+
+	csv := strings.Repeat("a,b,c,d,e,f\n", 10000)
+	tm := time.Now()
+
+	cs := []struct {
+		id    string
+		data  string
+		codec string
+	}{
+		{"/a.csv", csv, "gzip"},
+		{"/s.csv", "a,b", ""},
+		{"/b.json", `{"a":1}`, "deflate"},
+		{"/c.txt", csv, ""},
+		{"/d.tsv", csv, "zstd"},
+		{"/x.log", "XFZ1gzip" + strings.Repeat("\x00", 100), "gzip"},
+	}
+
+	for i, c := range cs {
+		var f *xfs.File
+		var err error
+		if i%2 == 0 {
+			f, err = zfs.SaveFileReader(c.id, c.id[1:], tm, strings.NewReader(c.data))
+		} else {
+			f, err = zfs.SaveFile(c.id, c.id[1:], tm, []byte(c.data))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Size != int64(len(c.data)) || f.Codec != c.codec {
+			t.Errorf("#%d Save(%q) = %d %q", i, c.id, f.Size, f.Codec)
+		}
+
+		if f, err = zfs.FindFile(c.id); err != nil || f.Size != int64(len(c.data)) || f.Codec != c.codec {
+			t.Errorf("#%d FindFile(%q) = %v, %v", i, c.id, f, err)
+		}
+
+		if bs, err := zfs.ReadFile(c.id); err != nil || string(bs) != c.data {
+			t.Errorf("#%d ReadFile(%q) = %d, %v", i, c.id, len(bs), err)
+		}
+
+		p := make([]byte, 5)
+		if n, _ := zfs.ReadFileAt(c.id, p, 2); string(p[:n]) != c.data[2:min(7, len(c.data))] {
+			t.Errorf("#%d ReadFileAt(%q) = %q", i, c.id, p[:n])
+		}
+	}
+
+	if sf, _ := dfs.FindFile("/a.csv"); sf.Size >= int64(len(csv))/10 || sf.Codec != "gzip" || sf.RawSize != int64(len(csv)) || sf.MIME != "text/csv; charset=utf-8" {
+		t.Errorf("stored a.csv = %v", sf)
+	}
+
+	files, err := zfs.ListPrefix("/")
+	if err != nil || len(files) != len(cs) || files[0].Size != int64(len(csv)) || files[0].Codec != "gzip" {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+
+	// plain file which starts with the old header magic
+	fake := "XFZ1gzip" + strings.Repeat("\x00", 100)
+	if _, err := dfs.SaveFile("/p.log", "p.log", tm, []byte(fake)); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := zfs.FindFile("/p.log"); err != nil || f.Size != int64(len(fake)) || f.Codec != "" {
+		t.Errorf("FindFile(p.log) = %v, %v", f, err)
+	}
+	if bs, err := zfs.ReadFile("/p.log"); err != nil || string(bs) != fake {
+		t.Errorf("ReadFile(p.log) = %q, %v", bs, err)
+	}
+
+	// sequential, backward and out of range reads
+	for _, off := range []int{0, 1000, 5000, 100, 200, len(csv) - 3, len(csv) + 10} {
+		p := make([]byte, 10)
+		n, err := zfs.ReadFileAt("/d.tsv", p, int64(off))
+
+		want := csv[min(off, len(csv)):min(off+10, len(csv))]
+		if string(p[:n]) != want {
+			t.Errorf("ReadFileAt(d.tsv, %d) = %q, want %q", off, p[:n], want)
+		}
+		if n < 10 && !errors.Is(err, io.EOF) {
+			t.Errorf("ReadFileAt(d.tsv, %d) = %d, %v, want EOF", off, n, err)
+		}
+	}
+
+	// fs.File
+	fr, err := zfs.Open("a.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.(io.Seeker).Seek(12, io.SeekStart)
+	bs, _ := io.ReadAll(fr)
+	fr.Close()
+	if string(bs) != csv[12:] {
+		t.Errorf("Open(a.csv) read %d bytes", len(bs))
+	}
+
+	h := xfs.FileServer(zfs)
+
+	// identity
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a.csv", nil))
+	if w.Code != http.StatusOK || w.Body.String() != csv || w.Header().Get("Content-Encoding") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("GET identity = %d %d %v", w.Code, w.Body.Len(), w.Header())
+	}
+
+	// gzip
+	req := httptest.NewRequest(http.MethodGet, "/a.csv", nil)
+	req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("GET gzip = %d %v", w.Code, w.Header())
+	}
+
+	zr, err := xfs.GzipCodec.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := io.ReadAll(zr); err != nil || string(bs) != csv {
+		t.Errorf("GET gzip body = %d, %v", len(bs), err)
+	}
+
+	// gzip not acceptable
+	req = httptest.NewRequest(http.MethodGet, "/a.csv", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, br")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || w.Body.String() != csv {
+		t.Errorf("GET gzip;q=0 = %d %v", w.Code, w.Header())
+	}
+}
+
+func TestCompressFSCrypt(t *testing.T) {
+	csv := strings.Repeat("a,b,c,d,e,f\n", 10000)
+	tm := time.Now()
+
+	key := xfs.SecretKey("k1", "secret1")
+
+	// compress then encrypt
+	dfs := dirxfs.FS(t.TempDir())
+	cfs, err := xfs.NewCryptFS(dfs, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zfs := xfs.NewCompressFS(cfs, &xfs.CompressRule{Codec: xfs.GzipCodec})
+
+	if f, err := zfs.SaveFileReader("/a.csv", "a.csv", tm, strings.NewReader(csv)); err != nil || f.Size != int64(len(csv)) || f.Codec != "gzip" {
+		t.Fatalf("SaveFileReader() = %v, %v", f, err)
+	}
+	if sf, _ := dfs.FindFile("/a.csv"); sf.Codec != "gzip,aesgcm" || sf.RawSize != int64(len(csv)) || sf.Size >= int64(len(csv))/10 {
+		t.Errorf("stored a.csv = %v", sf)
+	}
+	if bs, err := zfs.ReadFile("/a.csv"); err != nil || string(bs) != csv {
+		t.Errorf("ReadFile() = %d, %v", len(bs), err)
+	}
+
+	// encrypt, the encrypted data is not compressed
+	dfs = dirxfs.FS(t.TempDir())
+	zfs = xfs.NewCompressFS(dfs, &xfs.CompressRule{Codec: xfs.GzipCodec})
+	if cfs, err = xfs.NewCryptFS(zfs, key); err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := cfs.SaveFile("/a.csv", "a.csv", tm, []byte(csv)); err != nil || f.Size != int64(len(csv)) || f.Codec != "" {
+		t.Fatalf("SaveFile() = %v, %v", f, err)
+	}
+	if sf, _ := dfs.FindFile("/a.csv"); sf.Codec != "aesgcm" || sf.RawSize != int64(len(csv)) {
+		t.Errorf("stored a.csv = %v", sf)
+	}
+	if bs, err := cfs.ReadFile("/a.csv"); err != nil || string(bs) != csv {
+		t.Errorf("ReadFile() = %d, %v", len(bs), err)
+	}
+}
+
+func TestCompressFSMaxPeek(t *testing.T) {
+	mp := xfs.CompressMaxPeek
+	xfs.CompressMaxPeek = 10
+	defer func() { xfs.CompressMaxPeek = mp }()
+
+	zfs := xfs.NewCompressFS(dirxfs.FS(t.TempDir()), &xfs.CompressRule{Codec: xfs.GzipCodec, MinSize: 100})
+
+	cs := []struct {
+		data  string
+		codec string
+	}{
+		{"123456789", ""},
+		{strings.Repeat("1234567890", 5), "gzip"},
+		{strings.Repeat("1234567890", 20), "gzip"},
+	}
+
+	for i, c := range cs {
+		f, err := zfs.SaveFileReader("/a.txt", "a.txt", time.Now(), strings.NewReader(c.data))
+		if err != nil || f.Size != int64(len(c.data)) || f.Codec != c.codec {
+			t.Errorf("#%d SaveFileReader() = %v, %v, want codec %q", i, f, err, c.codec)
+		}
+		if bs, err := zfs.ReadFile("/a.txt"); err != nil || string(bs) != c.data {
+			t.Errorf("#%d ReadFile() = %q, %v", i, bs, err)
+		}
+	}
+
+	rerr := errors.New("read error")
+	if _, err := zfs.SaveFileReader("/b.txt", "b.txt", time.Now(), io.MultiReader(strings.NewReader("12"), iotest.ErrReader(rerr))); !errors.Is(err, rerr) {
+		t.Errorf("SaveFileReader() = %v, want %v", err, rerr)
+	}
+}
@@ -0,0 +1,684 @@
+package xfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/askasoft/pango/str"
+)
+
+const (
+	// CryptSegmentSize the plain data size of an encrypted segment
+	CryptSegmentSize = 64 << 10
+
+	cryptCoding   = "aesgcm"
+	cryptMagic    = "XFE1"
+	cryptKeyIDMax = 31
+	cryptNonceLen = 12
+	cryptTagLen   = 16
+
+	// header: magic(4) + key id length(1) + key id(31) + nonce(12)
+	cryptHeaderLen = len(cryptMagic) + 1 + cryptKeyIDMax + cryptNonceLen
+
+	cryptSegmentLen = CryptSegmentSize + cryptTagLen
+)
+
+var (
+	// ErrCryptKeyUnknown indicates the key of the encrypted file is not in the key ring
+	ErrCryptKeyUnknown = errors.New("xfs: unknown encryption key")
+
+	// ErrCryptCorrupted indicates the encrypted file data is corrupted or tampered
+	ErrCryptCorrupted = errors.New("xfs: corrupted encrypted data")
+)
+
+// CryptKey a AES key (16, 24 or 32 bytes) identified by the ID
+type CryptKey struct {
+	ID  string
+	Key []byte
+}
+
+// SecretKey returns a AES-256 CryptKey derived from the secret string (for example xwa.Secret)
+func SecretKey(id, secret string) *CryptKey {
+	sum := sha256.Sum256([]byte(secret))
+	return &CryptKey{ID: id, Key: sum[:]}
+}
+
+// CryptFS wraps a XFS to encrypt the file data on save and decrypt it on read with AES-GCM.
+// The data is encrypted by segments of CryptSegmentSize, and the key id is stored in the header of the encrypted data,
+// so the keys can be rotated by adding a new current key and calling ReencryptFiles() to re-encrypt the files with the old keys.
+// The encrypted file is marked by the "aesgcm" content coding of the File.Codec, so the wrapped XFS must be a EncodedSaver.
+// The file without the mark is treated as a plain file.
+//
+// The nonce of a file is generated randomly on each save, so the same plain data is encrypted to the different data,
+// and the data is encrypted while it is streamed to the wrapped XFS (the plain data is not written to a temporary file).
+//
+// Note:
+// The File.Hash is the hash of the encrypted data, it changes on each save even if the plain data is same,
+// so the deduplication of the wrapped XFS does not work for the encrypted files.
+// SumSize() sums the plain size of the files found by FindFiles().
+type CryptFS struct {
+	XFS
+
+	key  *CryptKey
+	keys map[string]cipher.AEAD
+}
+
+// NewCryptFS create a CryptFS which encrypts the file data with the current key.
+// The old keys are used to decrypt the files encrypted before the key rotation.
+func NewCryptFS(xfs XFS, current *CryptKey, olds ...*CryptKey) (*CryptFS, error) {
+	cfs := &CryptFS{XFS: xfs, key: current, keys: make(map[string]cipher.AEAD)}
+
+	for _, ck := range append([]*CryptKey{current}, olds...) {
+		if ck.ID == "" || len(ck.ID) > cryptKeyIDMax {
+			return nil, fmt.Errorf("xfs: invalid encryption key id %q", ck.ID)
+		}
+
+		block, err := aes.NewCipher(ck.Key)
+		if err != nil {
+			return nil, fmt.Errorf("xfs: invalid encryption key %q: %w", ck.ID, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		cfs.keys[ck.ID] = aead
+	}
+
+	return cfs, nil
+}
+
+// Unwrap returns the wrapped XFS
+func (cfs *CryptFS) Unwrap() XFS {
+	return cfs.XFS
+}
+
+// cryptHeader the parsed encryption header
+type cryptHeader struct {
+	raw   []byte
+	kid   string
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+// newHeader create a encryption header of the current key with a random nonce
+func (cfs *CryptFS) newHeader() *cryptHeader {
+	raw := make([]byte, cryptHeaderLen)
+	copy(raw, cryptMagic)
+	raw[len(cryptMagic)] = byte(len(cfs.key.ID))
+	copy(raw[len(cryptMagic)+1:], cfs.key.ID)
+
+	nonce := raw[cryptHeaderLen-cryptNonceLen:]
+	_, _ = rand.Read(nonce)
+
+	return &cryptHeader{raw: raw, kid: cfs.key.ID, aead: cfs.keys[cfs.key.ID], nonce: nonce}
+}
+
+// parseHeader parse the encryption header of the encrypted data
+func (cfs *CryptFS) parseHeader(raw []byte) (*cryptHeader, error) {
+	if len(raw) < cryptHeaderLen || string(raw[:len(cryptMagic)]) != cryptMagic {
+		return nil, ErrCryptCorrupted
+	}
+
+	n := int(raw[len(cryptMagic)])
+	if n == 0 || n > cryptKeyIDMax {
+		return nil, ErrCryptCorrupted
+	}
+
+	kid := string(raw[len(cryptMagic)+1 : len(cryptMagic)+1+n])
+	aead, ok := cfs.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrCryptKeyUnknown, kid)
+	}
+
+	raw = raw[:cryptHeaderLen]
+	return &cryptHeader{raw: raw, kid: kid, aead: aead, nonce: raw[cryptHeaderLen-cryptNonceLen:]}, nil
+}
+
+// readHeader read the encryption header of the encrypted file
+func (cfs *CryptFS) readHeader(id string) (*cryptHeader, error) {
+	raw := make([]byte, cryptHeaderLen)
+	n, err := cfs.XFS.ReadFileAt(id, raw, 0)
+	if n < cryptHeaderLen {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, ErrCryptCorrupted
+	}
+	return cfs.parseHeader(raw)
+}
+
+func (ch *cryptHeader) segmentNonce(seq int64) []byte {
+	nonce := make([]byte, cryptNonceLen)
+	copy(nonce, ch.nonce)
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^uint64(seq)) //nolint: gosec
+	return nonce
+}
+
+func (ch *cryptHeader) segmentAAD(final bool) []byte {
+	aad := make([]byte, len(ch.raw)+1)
+	copy(aad, ch.raw)
+	if final {
+		aad[len(ch.raw)] = 1
+	}
+	return aad
+}
+
+func (ch *cryptHeader) seal(dst, plain []byte, seq int64, final bool) []byte {
+	return ch.aead.Seal(dst, ch.segmentNonce(seq), plain, ch.segmentAAD(final))
+}
+
+func (ch *cryptHeader) open(dst, sealed []byte, seq int64, final bool) ([]byte, error) {
+	p, err := ch.aead.Open(dst, ch.segmentNonce(seq), sealed, ch.segmentAAD(final))
+	if err != nil {
+		return nil, ErrCryptCorrupted
+	}
+	return p, nil
+}
+
+// plainSize compute the plain data size from the encrypted data size
+func plainSize(size int64) int64 {
+	if size < int64(cryptHeaderLen+cryptTagLen) {
+		return 0
+	}
+
+	size -= int64(cryptHeaderLen)
+	n := (size / cryptSegmentLen) * CryptSegmentSize
+	if r := size % cryptSegmentLen; r > cryptTagLen {
+		n += r - cryptTagLen
+	}
+	return n
+}
+
+// isEncrypted returns true if the last content coding of the file is "aesgcm"
+func isEncrypted(f *File) bool {
+	_, last := cutCoding(f.Codec)
+	return last == cryptCoding
+}
+
+// cryptDecode remove the "aesgcm" content coding from the File.Codec and set the plain size of the encrypted file
+func cryptDecode(f *File) {
+	if rest, last := cutCoding(f.Codec); last == cryptCoding {
+		f.Codec, f.Size = rest, plainSize(f.Size)
+		if rest == "" {
+			f.RawSize = 0
+		}
+	}
+}
+
+func cryptDecodes(files []*File, err error) ([]*File, error) {
+	for _, f := range files {
+		cryptDecode(f)
+	}
+	return files, err
+}
+
+// spool write the data read from r to a temporary file,
+// the caller should close and remove the temporary file.
+func spool(r io.Reader) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "xfs-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// save encrypt the data read from r while it is saved with the encoding metadata.
+// The eof is called after r is read to EOF to set the RawSize and MIME of the enc (can be nil),
+// the EncodedSaver reads them after the encrypted data is read to EOF.
+func (cfs *CryptFS) save(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, eof func(), tag ...string) (*File, error) {
+	es, err := encodedSaver(cfs.XFS)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := es.SaveEncodedFile(id, filename, filetime, &cryptReader{ch: cfs.newHeader(), r: r, eof: eof}, enc, tag...)
+	if err != nil {
+		return nil, err
+	}
+
+	cryptDecode(f)
+	return f, nil
+}
+
+//----------------------------------------------------
+
+// FindFile find a file, the file size is the plain data size
+func (cfs *CryptFS) FindFile(id string) (*File, error) {
+	f, err := cfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	cryptDecode(f)
+	return f, nil
+}
+
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (cfs *CryptFS) ListPrefix(prefix string) ([]*File, error) {
+	return cryptDecodes(cfs.XFS.ListPrefix(prefix))
+}
+
+// FindFiles find the files by the query
+func (cfs *CryptFS) FindFiles(fq *FileQuery) ([]*File, error) {
+	return cryptDecodes(cfs.XFS.FindFiles(fq))
+}
+
+// SumSize sum the plain size of the files found by the query
+func (cfs *CryptFS) SumSize(fq *FileQuery) (int64, error) {
+	return sumFiles(cfs, fq)
+}
+
+func (cfs *CryptFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	enc := &Encoding{
+		Codec:   cryptCoding,
+		RawSize: int64(len(data)),
+		MIME:    DetectMIME(data, str.ToLower(filepath.Ext(filename))),
+	}
+
+	f, err := cfs.save(id, filename, filetime, bytes.NewReader(data), enc, nil, tag...)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Data = data
+	return f, nil
+}
+
+// SaveFileReader save a file with the data read from the reader, the data is encrypted while it is read.
+func (cfs *CryptFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	hr := NewHashReader(r)
+
+	enc := &Encoding{Codec: cryptCoding}
+	return cfs.save(id, filename, filetime, hr, enc, func() {
+		enc.RawSize, enc.MIME = hr.Size(), hr.MIME(str.ToLower(filepath.Ext(filename)))
+	}, tag...)
+}
+
+// SaveEncodedFile encrypt and save the encoded data read from the reader (for example the data compressed by CompressFS),
+// the "aesgcm" content coding is appended to the enc.Codec.
+// The enc.RawSize and enc.MIME are read after the encoded data is read to EOF.
+func (cfs *CryptFS) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, tag ...string) (*File, error) {
+	ce := &Encoding{Codec: appendCoding(enc.Codec, cryptCoding)}
+	return cfs.save(id, filename, filetime, r, ce, func() {
+		ce.RawSize, ce.MIME = enc.RawSize, enc.MIME
+	}, tag...)
+}
+
+// ReadFile read the plain data of the file, the name can be a file id or a fs.FS path name.
+func (cfs *CryptFS) ReadFile(name string) ([]byte, error) {
+	r, err := cfs.OpenReader(FileID(name))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// OpenReader open a reader to read the plain data of the file, the caller should close the reader
+func (cfs *CryptFS) OpenReader(id string) (io.ReadCloser, error) {
+	f, err := cfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := cfs.XFS.OpenReader(id)
+	if err != nil || !isEncrypted(f) {
+		return rc, err
+	}
+
+	raw := make([]byte, cryptHeaderLen)
+	n, err := io.ReadFull(rc, raw)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		rc.Close()
+		return nil, err
+	}
+
+	ch, err := cfs.parseHeader(raw[:n])
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &readCloser{&decryptReader{ch: ch, r: rc}, rc}, nil
+}
+
+// ReadFileAt read len(p) bytes of the plain data of the file starting at the offset `off`.
+func (cfs *CryptFS) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	f, err := cfs.XFS.FindFile(id)
+	if err != nil {
+		return 0, err
+	}
+	if !isEncrypted(f) {
+		return cfs.XFS.ReadFileAt(id, p, off)
+	}
+
+	ch, err := cfs.readHeader(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// read the segments which cover [off, off+len(p)) and one more byte to detect the final segment
+	seq := off / CryptSegmentSize
+	end := (off + int64(len(p)) - 1) / CryptSegmentSize
+	buf := make([]byte, (end-seq+1)*cryptSegmentLen+1)
+
+	n, err := cfs.XFS.ReadFileAt(id, buf, int64(cryptHeaderLen)+seq*cryptSegmentLen)
+	if n < len(buf) && err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	skip := off - seq*CryptSegmentSize
+	pn := 0
+	for len(buf) > 0 && pn < len(p) {
+		sn := min(len(buf), cryptSegmentLen)
+		final := len(buf) <= cryptSegmentLen
+
+		plain, err := ch.open(nil, buf[:sn], seq, final)
+		if err != nil {
+			return pn, err
+		}
+
+		if skip < int64(len(plain)) {
+			pn += copy(p[pn:], plain[skip:])
+		}
+		skip = 0
+
+		if final {
+			break
+		}
+		buf = buf[sn:]
+		seq++
+	}
+
+	if pn < len(p) {
+		return pn, io.EOF
+	}
+	return pn, nil
+}
+
+// Open open the file or the directory of the name, see OpenFile() for details.
+func (cfs *CryptFS) Open(name string) (fs.File, error) {
+	return OpenFile(cfs, name)
+}
+
+// Stat returns a fs.FileInfo describing the file or the directory of the name
+func (cfs *CryptFS) Stat(name string) (fs.FileInfo, error) {
+	return Stat(cfs, name)
+}
+
+// ReadDir reads the directory of the name and returns a list of directory entries sorted by filename.
+func (cfs *CryptFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return ReadDir(cfs, name)
+}
+
+//----------------------------------------------------
+
+// Reencrypt re-encrypt the file with the current key if the file is a plain file or is encrypted with a old key.
+// Returns true if the file is re-encrypted.
+func (cfs *CryptFS) Reencrypt(id string) (bool, error) {
+	f, err := cfs.XFS.FindFile(id)
+	if err != nil {
+		return false, err
+	}
+
+	codec, raw := f.Codec, f.RawSize
+	if isEncrypted(f) {
+		ch, err := cfs.readHeader(id)
+		if err != nil {
+			return false, err
+		}
+		if ch.kid == cfs.key.ID {
+			return false, nil
+		}
+		codec, _ = cutCoding(f.Codec)
+	}
+
+	es, err := encodedSaver(cfs.XFS)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := cfs.OpenReader(id)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	// spool the encrypted data to a temporary file, because the underlying XFS may not support to overwrite the file being read.
+	hr := NewHashReader(r)
+	tmp, err := spool(&cryptReader{ch: cfs.newHeader(), r: hr})
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if codec == "" {
+		raw = hr.Size()
+	}
+
+	enc := &Encoding{Codec: appendCoding(codec, cryptCoding), RawSize: raw, MIME: f.MIME}
+	_, err = es.SaveEncodedFile(f.ID, f.Name, f.Time, tmp, enc, f.Tag)
+	return err == nil, err
+}
+
+// ReencryptFiles re-encrypt the files found by the query with the current key, see Reencrypt() for details.
+// The files are processed by pages of fq.Limit (all if 0) until no more files are found or the context is done.
+// The callback is called after each file is processed (can be nil).
+// Returns the count of the re-encrypted files.
+func (cfs *CryptFS) ReencryptFiles(ctx context.Context, fq *FileQuery, callback func(f *File, reencrypted bool, err error)) (int, error) {
+	q := *fq
+	if q.Order == "" {
+		q.Order = "id"
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+
+	cnt := 0
+	for {
+		files, err := cfs.FindFiles(&q)
+		if err != nil {
+			return cnt, err
+		}
+
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return cnt, err
+			}
+
+			ok, err := cfs.Reencrypt(f.ID)
+			if ok {
+				cnt++
+			}
+			if callback != nil {
+				callback(f, ok, err)
+			}
+		}
+
+		if q.Limit <= 0 || len(files) < q.Limit {
+			return cnt, nil
+		}
+		q.Page++
+	}
+}
+
+//----------------------------------------------------
+
+// cryptReader encrypts the data read from the underlying reader
+type cryptReader struct {
+	ch   *cryptHeader
+	r    io.Reader
+	eof  func() // called after the underlying reader is read to EOF (can be nil)
+	seq  int64
+	buf  []byte // pending plain data
+	enc  []byte // encrypted segment buffer
+	out  []byte // encrypted data to output
+	head bool   // header output
+	done bool
+}
+
+func (cr *cryptReader) Read(p []byte) (int, error) {
+	for len(cr.out) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+
+		if !cr.head {
+			cr.head, cr.out = true, cr.ch.raw
+			break
+		}
+
+		if err := cr.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.out)
+	cr.out = cr.out[n:]
+	return n, nil
+}
+
+func (cr *cryptReader) fill() error {
+	if cr.buf == nil {
+		cr.buf = make([]byte, 0, CryptSegmentSize+1)
+	}
+
+	// read one more byte to detect the final segment
+	n, err := io.ReadFull(cr.r, cr.buf[len(cr.buf):CryptSegmentSize+1])
+	cr.buf = cr.buf[:len(cr.buf)+n]
+
+	final := false
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		final = true
+		if cr.eof != nil {
+			cr.eof()
+		}
+	}
+
+	seg := cr.buf[:min(len(cr.buf), CryptSegmentSize)]
+	cr.enc = cr.ch.seal(cr.enc[:0], seg, cr.seq, final)
+	cr.out = cr.enc
+	cr.seq++
+
+	if final {
+		cr.done = true
+		cr.buf = cr.buf[:0]
+	} else {
+		cr.buf = append(cr.buf[:0], cr.buf[CryptSegmentSize:]...)
+	}
+	return nil
+}
+
+// decryptReader decrypts the segments read from the underlying reader (after the header)
+type decryptReader struct {
+	ch   *cryptHeader
+	r    io.Reader
+	seq  int64
+	buf  []byte // pending encrypted data
+	dec  []byte // decrypted segment buffer
+	out  []byte // decrypted data to output
+	done bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.out) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.out)
+	dr.out = dr.out[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) fill() error {
+	if dr.buf == nil {
+		dr.buf = make([]byte, 0, cryptSegmentLen+1)
+	}
+
+	// read one more byte to detect the final segment
+	n, err := io.ReadFull(dr.r, dr.buf[len(dr.buf):cryptSegmentLen+1])
+	dr.buf = dr.buf[:len(dr.buf)+n]
+
+	final := false
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		final = true
+	}
+
+	seg := dr.buf[:min(len(dr.buf), cryptSegmentLen)]
+	dec, err := dr.ch.open(dr.dec[:0], seg, dr.seq, final)
+	if err != nil {
+		return err
+	}
+	dr.dec, dr.out = dec, dec
+	dr.seq++
+
+	if final {
+		dr.done = true
+		dr.buf = dr.buf[:0]
+	} else {
+		dr.buf = append(dr.buf[:0], dr.buf[cryptSegmentLen:]...)
+	}
+	return nil
+}
+
+// readCloser a io.ReadCloser which reads from r and closes c
+type readCloser struct {
+	r io.Reader
+	c io.Closer
+}
+
+func (rc *readCloser) Read(p []byte) (int, error) {
+	return rc.r.Read(p)
+}
+
+func (rc *readCloser) Close() error {
+	return rc.c.Close()
+}
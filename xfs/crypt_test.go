@@ -0,0 +1,223 @@
+package xfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+)
+
+func TestCryptFS(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+
+	k1 := xfs.SecretKey("k1", "secret1")
+	cfs, err := xfs.NewCryptFS(dfs, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := time.Now()
+	for _, size := range []int{0, 1, xfs.CryptSegmentSize - 1, xfs.CryptSegmentSize, xfs.CryptSegmentSize*2 + 100} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+
+		f, err := cfs.SaveFileReader("/a.bin", "a.bin", tm, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Size != int64(size) {
+			t.Errorf("[%d] SaveFileReader().Size = %d", size, f.Size)
+		}
+
+		if f, err = cfs.FindFile("/a.bin"); err != nil || f.Size != int64(size) {
+			t.Errorf("[%d] FindFile() = %v, %v", size, f, err)
+		}
+
+		if fs, err := cfs.ListPrefix("/"); err != nil || len(fs) != 1 || fs[0].Size != int64(size) {
+			t.Errorf("[%d] ListPrefix() = %v, %v", size, fs, err)
+		}
+
+		raw, _ := dfs.ReadFile("/a.bin")
+		if size > 16 && bytes.Contains(raw, data[:16]) {
+			t.Errorf("[%d] raw data is not encrypted", size)
+		}
+
+		if bs, err := cfs.ReadFile("/a.bin"); err != nil || !bytes.Equal(bs, data) {
+			t.Errorf("[%d] ReadFile() = %d, %v", size, len(bs), err)
+		}
+
+		r, err := cfs.OpenReader("/a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(bs, data) {
+			t.Errorf("[%d] OpenReader() = %d, %v", size, len(bs), err)
+		}
+
+		for _, off := range []int{0, 1, xfs.CryptSegmentSize - 2, xfs.CryptSegmentSize + 3} {
+			p := make([]byte, 10)
+			n, err := cfs.ReadFileAt("/a.bin", p, int64(off))
+
+			want := data[min(off, size):min(off+10, size)]
+			if n != len(want) || !bytes.Equal(p[:n], want) {
+				t.Errorf("[%d] ReadFileAt(%d) = %d, %v", size, off, n, err)
+			}
+			if n < 10 && !errors.Is(err, io.EOF) {
+				t.Errorf("[%d] ReadFileAt(%d) = %d, %v, want EOF", size, off, n, err)
+			}
+		}
+	}
+
+	// plain file
+	if _, err := dfs.SaveFile("/p.txt", "p.txt", tm, []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := cfs.ReadFile("/p.txt"); err != nil || string(bs) != "plain" {
+		t.Errorf("ReadFile(plain) = %q, %v", bs, err)
+	}
+
+	// plain file which starts with the header magic
+	fake := []byte("XFE1" + strings.Repeat("x", 100))
+	if _, err := dfs.SaveFile("/x.txt", "x.txt", tm, fake); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := cfs.FindFile("/x.txt"); err != nil || f.Size != int64(len(fake)) {
+		t.Errorf("FindFile(magic) = %v, %v", f, err)
+	}
+	if bs, err := cfs.ReadFile("/x.txt"); err != nil || !bytes.Equal(bs, fake) {
+		t.Errorf("ReadFile(magic) = %q, %v", bs, err)
+	}
+	if err := dfs.DeleteFile("/x.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered
+	raw, _ := dfs.ReadFile("/a.bin")
+	raw[len(raw)-1] ^= 1
+	enc := &xfs.Encoding{Codec: "aesgcm", RawSize: xfs.CryptSegmentSize*2 + 100}
+	if _, err := dfs.(xfs.EncodedSaver).SaveEncodedFile("/t.bin", "t.bin", tm, bytes.NewReader(raw), enc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfs.ReadFile("/t.bin"); !errors.Is(err, xfs.ErrCryptCorrupted) {
+		t.Errorf("ReadFile(tampered) = %v, want ErrCryptCorrupted", err)
+	}
+	if err := cfs.DeleteFile("/t.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotation
+	b, err := cfs.SaveFile("/b.txt", "b.txt", tm, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MIME != "text/plain; charset=utf-8" || b.Codec != "" {
+		t.Errorf("SaveFile() = %q %q", b.MIME, b.Codec)
+	}
+
+	// the same data is encrypted to the different data by the random nonce
+	if b2, err := cfs.SaveFileReader("/b2.txt", "b2.txt", tm, strings.NewReader("hello")); err != nil || b2.Hash == b.Hash || b2.Size != 5 || b2.MIME != b.MIME {
+		t.Errorf("SaveFileReader() = %v, %v, want hash != %s", b2, err, b.Hash)
+	}
+	if err := cfs.DeleteFile("/b2.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// plain sizes
+	if size, err := cfs.SumSize(&xfs.FileQuery{}); err != nil || size != int64(xfs.CryptSegmentSize*2+100+5+5) {
+		t.Errorf("SumSize() = %d, %v", size, err)
+	}
+
+	k2 := xfs.SecretKey("k2", "secret2")
+	if c2, _ := xfs.NewCryptFS(dfs, k2); c2 != nil {
+		if _, err := c2.ReadFile("/b.txt"); !errors.Is(err, xfs.ErrCryptKeyUnknown) {
+			t.Errorf("ReadFile(unknown key) = %v, want ErrCryptKeyUnknown", err)
+		}
+	}
+
+	cfs, err = xfs.NewCryptFS(dfs, k2, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cnt, err := cfs.ReencryptFiles(context.Background(), &xfs.FileQuery{}, nil)
+	if err != nil || cnt != 3 {
+		t.Errorf("ReencryptFiles() = %d, %v", cnt, err)
+	}
+
+	cfs, _ = xfs.NewCryptFS(dfs, k2)
+	for id, want := range map[string]string{"/b.txt": "hello", "/p.txt": "plain"} {
+		if bs, err := cfs.ReadFile(id); err != nil || string(bs) != want {
+			t.Errorf("ReadFile(%q) = %q, %v", id, bs, err)
+		}
+	}
+	if f, err := cfs.FindFile("/p.txt"); err != nil || f.Size != 5 || f.Name != "p.txt" || f.Hash == b.Hash {
+		t.Errorf("FindFile(p.txt) = %v, %v", f, err)
+	}
+	if f, err := dfs.FindFile("/p.txt"); err != nil || f.Codec != "aesgcm" || f.RawSize != 5 || f.MIME != "text/plain; charset=utf-8" {
+		t.Errorf("FindFile(encrypted p.txt) = %v, %v", f, err)
+	}
+}
+
+type plainFS struct {
+	xfs.XFS
+}
+
+func TestCryptFSUnsupported(t *testing.T) {
+	cfs, err := xfs.NewCryptFS(plainFS{dirxfs.FS(t.TempDir())}, xfs.SecretKey("k1", "secret1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte("a")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SaveFile() = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+// tmpCheckReader checks the temporary directory is empty when the data is read to EOF
+type tmpCheckReader struct {
+	t   *testing.T
+	dir string
+	r   io.Reader
+}
+
+func (tr *tmpCheckReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		if des, _ := os.ReadDir(tr.dir); len(des) > 0 {
+			tr.t.Errorf("temporary files: %v", des)
+		}
+	}
+	return n, err
+}
+
+func TestCryptFSNoSpool(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	cfs, err := xfs.NewCryptFS(memxfs.FS(), xfs.SecretKey("k1", "secret1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), xfs.CryptSegmentSize/5)
+	f, err := cfs.SaveFileReader("/a.txt", "a.txt", time.Now(), &tmpCheckReader{t: t, dir: tmp, r: bytes.NewReader(data)})
+	if err != nil || f.Size != int64(len(data)) || f.MIME != "text/plain; charset=utf-8" {
+		t.Fatalf("SaveFileReader() = %v, %v", f, err)
+	}
+
+	if bs, err := cfs.ReadFile("/a.txt"); err != nil || !bytes.Equal(bs, data) {
+		t.Errorf("ReadFile() = %d, %v", len(bs), err)
+	}
+}
@@ -0,0 +1,272 @@
+package xfs
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// DerivedFS is embedded by the XFS wrappers which keep the derived files of the original files
+// (for example ThumbFS, VersionFS and ScanFS).
+// The derived files of the original file id are stored under the directory Prefix + id + "/" of the Store.
+// The Store should be a XFS separated from the wrapped XFS (it can be shared by the wrappers with the different Prefix),
+// so the derived files are not listed, queried, counted or summed with the original files, and are not limited by the quota.
+// The derived files are deleted with the original files by the Delete* methods.
+type DerivedFS struct {
+	XFS
+
+	// Store the XFS to store the derived files
+	Store XFS
+
+	// Prefix the file id prefix of the derived files in the Store
+	Prefix string
+}
+
+// DerivedDir returns the directory of the derived files of the original file id
+func (drv *DerivedFS) DerivedDir(id string) string {
+	return drv.Prefix + id + "/"
+}
+
+// Unwrap returns the wrapped XFS
+func (drv *DerivedFS) Unwrap() XFS {
+	return drv.XFS
+}
+
+// originalID returns the original file id of the derived file id
+func (drv *DerivedFS) originalID(did string) string {
+	return path.Dir(strings.TrimPrefix(did, drv.Prefix))
+}
+
+// ListDerived list the derived files of the original file id ordered by id,
+// the derived files of the descendant ids (for example "/a/b" of "/a") are not listed.
+func (drv *DerivedFS) ListDerived(id string) ([]*File, error) {
+	dir := drv.DerivedDir(id)
+
+	if dl, ok := drv.Store.(DirLister); ok {
+		files, _, err := dl.ListDir(dir)
+		return files, err
+	}
+
+	files, err := drv.Store.ListPrefix(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := files[:0]
+	for _, f := range files {
+		if !strings.Contains(f.ID[len(dir):], "/") {
+			rs = append(rs, f)
+		}
+	}
+	return rs, nil
+}
+
+// deleteDerived delete the derived files of the original file ids
+func (drv *DerivedFS) deleteDerived(ids ...string) error {
+	var dids []string
+	for _, id := range ids {
+		files, err := drv.ListDerived(id)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			dids = append(dids, f.ID)
+		}
+	}
+
+	if len(dids) == 0 {
+		return nil
+	}
+
+	_, err := drv.Store.DeleteFiles(dids...)
+	return err
+}
+
+// copyDerived copy the derived files of the original file src to the original file dst
+func (drv *DerivedFS) copyDerived(src, dst string) error {
+	files, err := drv.ListDerived(src)
+	if err != nil {
+		return err
+	}
+
+	ddir := drv.DerivedDir(dst)
+	for _, f := range files {
+		if err := drv.Store.CopyFile(f.ID, ddir+path.Base(f.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveDerived move the derived files of the original file src to the original file dst
+func (drv *DerivedFS) moveDerived(src, dst string) error {
+	files, err := drv.ListDerived(src)
+	if err != nil {
+		return err
+	}
+
+	ddir := drv.DerivedDir(dst)
+	for _, f := range files {
+		if err := drv.Store.MoveFile(f.ID, ddir+path.Base(f.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMatched find the ids of the original files which match the query and the match function (can be nil),
+// delete the original files by the del function, then delete the derived files of the found ids.
+func (drv *DerivedFS) deleteMatched(fq *FileQuery, match func(f *File) bool, del func() (int64, error)) (int64, error) {
+	var ids []string
+
+	fq.Order, fq.Limit = "id", 1000
+	for {
+		files, err := drv.XFS.FindFiles(fq)
+		if err != nil {
+			return 0, err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, f := range files {
+			if match == nil || match(f) {
+				ids = append(ids, f.ID)
+			}
+		}
+		fq.LastID = files[len(files)-1].ID
+	}
+
+	cnt, err := del()
+	if err != nil || cnt == 0 {
+		return cnt, err
+	}
+	return cnt, drv.deleteDerived(ids...)
+}
+
+// CleanOrphans delete the derived files which original file does not exist.
+// Returns the count of the deleted derived files.
+func (drv *DerivedFS) CleanOrphans() (int64, error) {
+	files, err := drv.Store.ListPrefix(drv.Prefix + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+
+	exists := map[string]bool{}
+	for _, f := range files {
+		oid := drv.originalID(f.ID)
+
+		ok, checked := exists[oid]
+		if !checked {
+			if _, err := drv.XFS.FindFile(oid); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					return 0, err
+				}
+			} else {
+				ok = true
+			}
+			exists[oid] = ok
+		}
+
+		if !ok {
+			ids = append(ids, f.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return drv.Store.DeleteFiles(ids...)
+}
+
+func (drv *DerivedFS) DeleteFile(id string) error {
+	if err := drv.XFS.DeleteFile(id); err != nil {
+		return err
+	}
+	return drv.deleteDerived(id)
+}
+
+func (drv *DerivedFS) DeleteFiles(ids ...string) (int64, error) {
+	cnt, err := drv.XFS.DeleteFiles(ids...)
+	if err != nil {
+		return cnt, err
+	}
+	return cnt, drv.deleteDerived(ids...)
+}
+
+func (drv *DerivedFS) DeletePrefix(prefix string) (int64, error) {
+	cnt, err := drv.XFS.DeletePrefix(prefix)
+	if err != nil {
+		return cnt, err
+	}
+
+	_, err = drv.Store.DeletePrefix(drv.Prefix + prefix)
+	return cnt, err
+}
+
+func (drv *DerivedFS) DeleteTagged(tag string) (int64, error) {
+	return drv.deleteMatched(&FileQuery{Tag: tag}, nil, func() (int64, error) {
+		return drv.XFS.DeleteTagged(tag)
+	})
+}
+
+func (drv *DerivedFS) DeleteBefore(before time.Time) (int64, error) {
+	return drv.deleteMatched(&FileQuery{TimeMax: before}, beforeMatch(before), func() (int64, error) {
+		return drv.XFS.DeleteBefore(before)
+	})
+}
+
+func (drv *DerivedFS) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	return drv.deleteMatched(&FileQuery{Prefix: prefix, TimeMax: before}, beforeMatch(before), func() (int64, error) {
+		return drv.XFS.DeletePrefixBefore(prefix, before)
+	})
+}
+
+func (drv *DerivedFS) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	return drv.deleteMatched(&FileQuery{Tag: tag, TimeMax: before}, beforeMatch(before), func() (int64, error) {
+		return drv.XFS.DeleteTaggedBefore(tag, before)
+	})
+}
+
+// DeleteWhere delete files by customized where filter,
+// the derived files which original file does not exist are deleted by CleanOrphans().
+func (drv *DerivedFS) DeleteWhere(where string, args ...any) (int64, error) {
+	cnt, err := drv.XFS.DeleteWhere(where, args...)
+	if err != nil || cnt == 0 {
+		return cnt, err
+	}
+
+	_, err = drv.CleanOrphans()
+	return cnt, err
+}
+
+func (drv *DerivedFS) DeleteAll() (int64, error) {
+	cnt, err := drv.XFS.DeleteAll()
+	if err != nil {
+		return cnt, err
+	}
+
+	_, err = drv.Store.DeletePrefix(drv.Prefix + "/")
+	return cnt, err
+}
+
+func (drv *DerivedFS) Truncate() error {
+	if err := drv.XFS.Truncate(); err != nil {
+		return err
+	}
+
+	_, err := drv.Store.DeletePrefix(drv.Prefix + "/")
+	return err
+}
+
+// beforeMatch returns a match function of the files which time is before the time `before`
+// (the FileQuery.TimeMax is inclusive)
+func beforeMatch(before time.Time) func(f *File) bool {
+	return func(f *File) bool {
+		return f.Time.Before(before)
+	}
+}
@@ -0,0 +1,330 @@
+package xfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PathID convert the fs.FS path name to the file id, returns false if the name is not a valid path.
+// The file ids are treated as the hierarchical paths which start with "/",
+// so "." is the root directory "/", and "a/b.txt" is the file id "/a/b.txt".
+func PathID(name string) (string, bool) {
+	if name == "." {
+		return "/", true
+	}
+	if !fs.ValidPath(name) {
+		return "", false
+	}
+	return "/" + name, true
+}
+
+// FileID returns the file id of the name, the name can be a file id or a fs.FS path name.
+func FileID(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	return "/" + name
+}
+
+// DirLister is implemented by the XFS which can list the direct children of a directory
+// without listing all the files of the subtree.
+type DirLister interface {
+	// ListDir list the files directly under the directory prefix (ends with "/") ordered by id,
+	// and the names of the sub directories with the latest time of the files in them.
+	ListDir(prefix string) (files []*File, dirs map[string]time.Time, err error)
+}
+
+// nameID returns the file id of the name, the name can be a file id "/a/b.txt" or a fs.FS path name "a/b.txt".
+func nameID(name string) (string, bool) {
+	if strings.HasPrefix(name, "/") {
+		return name, true
+	}
+	return PathID(name)
+}
+
+// dirPrefix returns the file id prefix of the files in the directory
+func dirPrefix(dir string) string {
+	if strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// OpenFile open the file or the directory of the name, the name can be a file id or a fs.FS path name.
+// The entries of the directory are listed on the first FSDir.ReadDir() call.
+func OpenFile(xfs XFS, name string) (fs.File, error) {
+	id, ok := nameID(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if id != "/" {
+		f, err := xfs.FindFile(id)
+		if err == nil {
+			return &FSFile{XFS: xfs, File: f}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	di, err := statDir(xfs, id)
+	if err != nil {
+		return nil, err
+	}
+	if di == nil {
+		return nil, fs.ErrNotExist
+	}
+
+	return &FSDir{xfs: xfs, id: id, info: di}, nil
+}
+
+// Stat returns a fs.FileInfo describing the file or the directory of the name,
+// the name can be a file id or a fs.FS path name.
+func Stat(xfs XFS, name string) (fs.FileInfo, error) {
+	id, ok := nameID(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if id != "/" {
+		f, err := xfs.FindFile(id)
+		if err == nil {
+			return &pathFileInfo{FSFileInfo{f}, path.Base(id)}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	di, err := statDir(xfs, id)
+	if err != nil {
+		return nil, err
+	}
+	if di == nil {
+		return nil, fs.ErrNotExist
+	}
+	return di, nil
+}
+
+// ReadDir reads the directory of the name and returns a list of directory entries sorted by filename.
+// The name can be a directory id or a fs.FS path name.
+func ReadDir(xfs XFS, name string) ([]fs.DirEntry, error) {
+	id, ok := nameID(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	des, err := readDir(xfs, id)
+	if err != nil {
+		return nil, err
+	}
+	if des == nil {
+		return nil, fs.ErrNotExist
+	}
+	return des, nil
+}
+
+// Glob returns the names of all files and directories matching the pattern (path.Match syntax).
+// The names are the fs.FS path names without the leading "/".
+func Glob(xfs XFS, pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	// static directory prefix of the pattern
+	prefix := "/"
+	if i := strings.IndexAny(pattern, `*?[\`); i < 0 {
+		prefix += pattern
+	} else if i = strings.LastIndexByte(pattern[:i], '/'); i >= 0 {
+		prefix += pattern[:i+1]
+	}
+
+	files, err := xfs.ListPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms []string
+	for _, f := range files {
+		// match the file and its parent directories
+		for p := strings.TrimPrefix(f.ID, "/"); p != "." && p != ""; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				ms = append(ms, p)
+			}
+		}
+	}
+
+	slices.Sort(ms)
+	return slices.Compact(ms), nil
+}
+
+// statDir returns the directory info, returns nil if the directory does not exist.
+// The directory time is the latest time of the files in it, so only 1 file is queried.
+func statDir(xfs XFS, dir string) (*dirInfo, error) {
+	fq := &FileQuery{Prefix: dirPrefix(dir)}
+	fq.Order = "-time"
+	fq.Limit = 1
+
+	files, err := xfs.FindFiles(fq)
+	if err != nil {
+		return nil, err
+	}
+
+	if dir == "/" {
+		di := &dirInfo{name: "."}
+		if len(files) > 0 {
+			di.time = files[0].Time
+		}
+		return di, nil
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return &dirInfo{name: path.Base(dir), time: files[0].Time}, nil
+}
+
+// readDir returns nil if the directory does not exist.
+// If the xfs is a DirLister, only the direct children of the directory are listed.
+func readDir(xfs XFS, dir string) ([]fs.DirEntry, error) {
+	prefix := dirPrefix(dir)
+
+	var des []fs.DirEntry
+	if dl, ok := xfs.(DirLister); ok {
+		files, dirs, err := dl.ListDir(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			des = append(des, fs.FileInfoToDirEntry(&pathFileInfo{FSFileInfo{f}, f.ID[len(prefix):]}))
+		}
+		for name, tm := range dirs {
+			des = append(des, fs.FileInfoToDirEntry(&dirInfo{name: name, time: tm}))
+		}
+	} else {
+		files, err := xfs.ListPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		dis := map[string]*dirInfo{}
+		for _, f := range files {
+			rest := f.ID[len(prefix):]
+			if rest == "" {
+				continue
+			}
+
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				name := rest[:i]
+				if di, ok := dis[name]; ok {
+					if f.Time.After(di.time) {
+						di.time = f.Time
+					}
+					continue
+				}
+
+				di := &dirInfo{name: name, time: f.Time}
+				dis[name] = di
+				des = append(des, fs.FileInfoToDirEntry(di))
+				continue
+			}
+
+			des = append(des, fs.FileInfoToDirEntry(&pathFileInfo{FSFileInfo{f}, rest}))
+		}
+	}
+
+	if len(des) == 0 {
+		if dir == "/" {
+			return []fs.DirEntry{}, nil
+		}
+		return nil, nil
+	}
+
+	slices.SortFunc(des, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return des, nil
+}
+
+//----------------------------------------------------
+
+// FSDir implements fs.ReadDirFile interface
+type FSDir struct {
+	xfs     XFS
+	id      string
+	info    *dirInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *FSDir) Close() error {
+	return nil
+}
+
+func (d *FSDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *FSDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// ReadDir reads the contents of the directory, see fs.ReadDirFile for details.
+func (d *FSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		des, err := readDir(d.xfs, d.id)
+		if err != nil {
+			return nil, err
+		}
+		if des == nil {
+			des = []fs.DirEntry{}
+		}
+		d.entries = des
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+//----------------------------------------------------
+
+// pathFileInfo a FSFileInfo which name is the base name of the file id
+type pathFileInfo struct {
+	FSFileInfo
+	name string
+}
+
+func (pfi *pathFileInfo) Name() string {
+	return pfi.name
+}
+
+// dirInfo implements fs.FileInfo interface for the directory
+type dirInfo struct {
+	name string
+	time time.Time
+}
+
+func (di *dirInfo) Name() string       { return di.name }
+func (di *dirInfo) Size() int64        { return 0 }
+func (di *dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0500 }
+func (di *dirInfo) ModTime() time.Time { return di.time }
+func (di *dirInfo) IsDir() bool        { return true }
+func (di *dirInfo) Sys() any           { return nil }
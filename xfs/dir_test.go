@@ -0,0 +1,93 @@
+package xfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+)
+
+// countFS counts the ListPrefix() calls and records the FindFiles() limits
+type countFS struct {
+	xfs.XFS
+	lists  int
+	limits []int
+}
+
+func (cfs *countFS) Open(name string) (fs.File, error) {
+	return xfs.OpenFile(cfs, name)
+}
+
+func (cfs *countFS) Stat(name string) (fs.FileInfo, error) {
+	return xfs.Stat(cfs, name)
+}
+
+func (cfs *countFS) ListPrefix(prefix string) ([]*xfs.File, error) {
+	cfs.lists++
+	return cfs.XFS.ListPrefix(prefix)
+}
+
+func (cfs *countFS) FindFiles(fq *xfs.FileQuery) ([]*xfs.File, error) {
+	cfs.limits = append(cfs.limits, fq.Limit)
+	return cfs.XFS.FindFiles(fq)
+}
+
+func TestOpenFile(t *testing.T) {
+	tm := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	cfs := &countFS{XFS: memxfs.FS()}
+	if _, err := cfs.SaveFile("/a/b.txt", "b.txt", tm, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfs.SaveFile("/a/c/d.txt", "d.txt", tm.Add(time.Hour), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file id and the fs.FS path name
+	for _, name := range []string{"/a/b.txt", "a/b.txt"} {
+		f, err := cfs.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%q) = %v", name, err)
+		}
+		fi, _ := f.Stat()
+		if fi.Name() != "b.txt" || fi.IsDir() {
+			t.Errorf("Open(%q).Stat() = %v", name, fi)
+		}
+		f.Close()
+	}
+
+	if _, err := cfs.Open("../a"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open() = %v, want %v", err, fs.ErrInvalid)
+	}
+
+	// the directory is probed by 1 file, and the entries are listed on ReadDir()
+	cfs.limits = nil
+	f, err := cfs.Open("/a")
+	if err != nil {
+		t.Fatalf("Open(/a) = %v", err)
+	}
+	fi, _ := f.Stat()
+	if !fi.IsDir() || fi.Name() != "a" || !fi.ModTime().Equal(tm.Add(time.Hour)) {
+		t.Errorf("Open(/a).Stat() = %v", fi)
+	}
+	if cfs.lists != 0 || len(cfs.limits) != 1 || cfs.limits[0] != 1 {
+		t.Errorf("Open(/a) lists = %d, limits = %v", cfs.lists, cfs.limits)
+	}
+
+	des, err := f.(fs.ReadDirFile).ReadDir(-1)
+	if err != nil || len(des) != 2 || des[0].Name() != "b.txt" || des[1].Name() != "c" || !des[1].IsDir() {
+		t.Errorf("ReadDir() = %v, %v", des, err)
+	}
+
+	// the missing file is probed by 1 file
+	cfs.lists, cfs.limits = 0, nil
+	if _, err := cfs.Stat("a/none"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() = %v, want %v", err, fs.ErrNotExist)
+	}
+	if cfs.lists != 0 || len(cfs.limits) != 1 || cfs.limits[0] != 1 {
+		t.Errorf("Stat(a/none) lists = %d, limits = %v", cfs.lists, cfs.limits)
+	}
+}
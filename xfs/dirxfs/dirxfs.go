@@ -0,0 +1,583 @@
+package dirxfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pangox/xfs"
+)
+
+const (
+	dataExt = ".dat"  // data file extension
+	metaExt = ".json" // metadata sidecar file extension
+)
+
+// dfs implements xfs.XFS interface
+type dfs struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+// FS create a xfs.XFS which stores the files in the directory `dir`.
+// The data of a file is stored as "{dir}/{hh}/{sha256(id)}.dat",
+// and the metadata is stored in a JSON sidecar file "{dir}/{hh}/{sha256(id)}.json".
+// The data and the sidecar are written to the temporary files first, and renamed together under the lock.
+// DeleteWhere only supports the conditions "{column} {operator} ?" joined by AND (see parseWhere()).
+//
+// Note:
+// The file ids are hashed, so every query (ListPrefix, FindFiles, CountFiles, SumSize and Delete* except DeleteFile(s))
+// walks all sidecar files of the directory, and so does a Open/Stat of a not existing file id (which is probed as a directory).
+// It is suitable for the small file sets only.
+func FS(dir string) xfs.XFS {
+	return &dfs{dir: dir}
+}
+
+func (dfs *dfs) path(id string) string {
+	sum := sha256.Sum256(str.UnsafeBytes(id))
+	key := hex.EncodeToString(sum[:])
+	return filepath.Join(dfs.dir, key[:2], key)
+}
+
+func (dfs *dfs) readMeta(path string) (*xfs.File, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+
+	f := &xfs.File{}
+	if err := json.Unmarshal(bs, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (dfs *dfs) writeMeta(path string, f *xfs.File) error {
+	tmp, err := writeMetaTemp(path, f)
+	if err != nil {
+		return err
+	}
+	return rename(tmp, path+metaExt)
+}
+
+// writeMetaTemp write the metadata to a temporary sidecar file, returns the temporary file name.
+func writeMetaTemp(path string, f *xfs.File) (string, error) {
+	bs, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, _, err := writeTemp(path+metaExt, bytes.NewReader(bs))
+	return tmp, err
+}
+
+// rename rename the temporary file to the path, the temporary file is removed if failed
+func rename(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// commit rename the temporary data file and the temporary sidecar file to the path
+func commit(path, dtmp, mtmp string) error {
+	if err := rename(dtmp, path+dataExt); err != nil {
+		os.Remove(mtmp)
+		return err
+	}
+	return rename(mtmp, path+metaExt)
+}
+
+// writeTemp write the data read from r to a temporary file in the directory of the path,
+// returns the temporary file name.
+func writeTemp(path string, r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return "", 0, err
+	}
+
+	fd, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", 0, err
+	}
+	tmp := fd.Name()
+
+	if err := fd.Chmod(0660); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return "", 0, err
+	}
+
+	n, err := io.Copy(fd, r)
+	if err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return "", n, err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return "", n, err
+	}
+	return tmp, n, nil
+}
+
+// copyTemp copy file src to a temporary file of dst, returns the temporary file name.
+func copyTemp(src, dst string) (string, error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fs.ErrNotExist
+		}
+		return "", err
+	}
+	defer sf.Close()
+
+	tmp, _, err := writeTemp(dst, sf)
+	return tmp, err
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (dfs *dfs) Open(name string) (fs.File, error) {
+	return xfs.OpenFile(dfs, name)
+}
+
+func (dfs *dfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	return xfs.ReadDir(dfs, name)
+}
+
+func (dfs *dfs) Stat(name string) (fs.FileInfo, error) {
+	return xfs.Stat(dfs, name)
+}
+
+func (dfs *dfs) Glob(pattern string) ([]string, error) {
+	return xfs.Glob(dfs, pattern)
+}
+
+// FindFile find a file
+func (dfs *dfs) FindFile(id string) (*xfs.File, error) {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	return dfs.readMeta(dfs.path(id) + metaExt)
+}
+
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (dfs *dfs) ListPrefix(prefix string) ([]*xfs.File, error) {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	var files []*xfs.File
+	err := dfs.walk(func(path string, f *xfs.File) error {
+		if strings.HasPrefix(f.ID, prefix) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(files, func(a, b *xfs.File) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return files, nil
+}
+
+func (dfs *dfs) queryFiles(fq *xfs.FileQuery) ([]*xfs.File, error) {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	var files []*xfs.File
+	err := dfs.walk(func(path string, f *xfs.File) error {
+		if fq.Match(f) {
+			files = append(files, f)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (dfs *dfs) CountFiles(fq *xfs.FileQuery) (int, error) {
+	files, err := dfs.queryFiles(fq)
+	return len(files), err
+}
+
+func (dfs *dfs) FindFiles(fq *xfs.FileQuery) ([]*xfs.File, error) {
+	files, err := dfs.queryFiles(fq)
+	if err != nil {
+		return nil, err
+	}
+	return xfs.QueryFiles(files, fq), nil
+}
+
+func (dfs *dfs) SumSize(fq *xfs.FileQuery) (size int64, err error) {
+	files, err := dfs.queryFiles(fq)
+	for _, f := range files {
+		size += f.Size
+	}
+	return
+}
+
+func (dfs *dfs) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*xfs.File, error) {
+	fi, err := dfs.SaveFileReader(id, filename, filetime, bytes.NewReader(data), tag...)
+	if fi != nil {
+		fi.Data = data
+	}
+	return fi, err
+}
+
+func (dfs *dfs) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*xfs.File, error) {
+	return dfs.saveFile(id, filename, filetime, r, nil, tag...)
+}
+
+// SaveEncodedFile save a file with the encoded data read from the reader and the encoding metadata
+func (dfs *dfs) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	return dfs.saveFile(id, filename, filetime, r, enc, tag...)
+}
+
+func (dfs *dfs) saveFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	name := filepath.Base(filename)
+	fext := str.ToLower(filepath.Ext(filename))
+
+	fi := &xfs.File{
+		ID:   id,
+		Name: name,
+		Ext:  fext,
+		Tag:  asg.First(tag),
+		Time: filetime,
+	}
+
+	path := dfs.path(id)
+
+	// write the data and the sidecar without lock, so the reader can read the other files of the dfs
+	hr := xfs.NewHashReader(r)
+	dtmp, _, err := writeTemp(path+dataExt, hr)
+	if err != nil {
+		return fi, err
+	}
+
+	fi.Size, fi.Hash, fi.MIME = hr.Size(), hr.Hash(), hr.MIME(fi.Ext)
+	if enc != nil {
+		fi.Codec, fi.RawSize, fi.MIME = enc.Codec, enc.RawSize, enc.MIME
+	}
+
+	mtmp, err := writeMetaTemp(path, fi)
+	if err != nil {
+		os.Remove(dtmp)
+		return fi, err
+	}
+
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	return fi, commit(path, dtmp, mtmp)
+}
+
+func (dfs *dfs) openData(id string) (*os.File, error) {
+	path := dfs.path(id)
+	if _, err := os.Stat(path + metaExt); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+
+	fd, err := os.Open(path + dataExt)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+	return fd, nil
+}
+
+func (dfs *dfs) OpenReader(id string) (io.ReadCloser, error) {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	return dfs.openData(id)
+}
+
+func (dfs *dfs) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	fd, err := dfs.openData(id)
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	return fd.ReadAt(p, off)
+}
+
+// ReadFile read the file data, the name can be a file id or a fs.FS path name
+func (dfs *dfs) ReadFile(name string) ([]byte, error) {
+	id := xfs.FileID(name)
+
+	dfs.mu.RLock()
+	defer dfs.mu.RUnlock()
+
+	fd, err := dfs.openData(id)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return io.ReadAll(fd)
+}
+
+func (dfs *dfs) CopyFile(src, dst string, tag ...string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	sp, dp := dfs.path(src), dfs.path(dst)
+
+	f, err := dfs.readMeta(sp + metaExt)
+	if err != nil {
+		return err
+	}
+
+	if src == dst {
+		return dfs.retag(dp, f, tag...)
+	}
+
+	dtmp, err := copyTemp(sp+dataExt, dp+dataExt)
+	if err != nil {
+		return err
+	}
+
+	f.ID = dst
+	if len(tag) > 0 {
+		f.Tag = tag[0]
+	}
+
+	mtmp, err := writeMetaTemp(dp, f)
+	if err != nil {
+		os.Remove(dtmp)
+		return err
+	}
+	return commit(dp, dtmp, mtmp)
+}
+
+func (dfs *dfs) MoveFile(src, dst string, tag ...string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	sp, dp := dfs.path(src), dfs.path(dst)
+
+	f, err := dfs.readMeta(sp + metaExt)
+	if err != nil {
+		return err
+	}
+
+	if src == dst {
+		return dfs.retag(dp, f, tag...)
+	}
+
+	f.ID = dst
+	if len(tag) > 0 {
+		f.Tag = tag[0]
+	}
+
+	// write the sidecar of dst before moving the data, so the src is kept if it fails
+	mtmp, err := writeMetaTemp(dp, f)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(sp+dataExt, dp+dataExt); err != nil {
+		os.Remove(mtmp)
+		return err
+	}
+	if err := rename(mtmp, dp+metaExt); err != nil {
+		return err
+	}
+	return removeFile(sp + metaExt)
+}
+
+// retag update the tag of the file for CopyFile/MoveFile to the same id
+func (dfs *dfs) retag(path string, f *xfs.File, tag ...string) error {
+	if len(tag) == 0 {
+		return nil
+	}
+
+	f.Tag = tag[0]
+	return dfs.writeMeta(path, f)
+}
+
+func (dfs *dfs) deleteFile(id string) (bool, error) {
+	path := dfs.path(id)
+
+	if err := os.Remove(path + metaExt); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, removeFile(path + dataExt)
+}
+
+func (dfs *dfs) DeleteFile(id string) error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	_, err := dfs.deleteFile(id)
+	return err
+}
+
+func (dfs *dfs) DeleteFiles(ids ...string) (cnt int64, err error) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	for _, id := range ids {
+		var ok bool
+		if ok, err = dfs.deleteFile(id); err != nil {
+			return
+		}
+		if ok {
+			cnt++
+		}
+	}
+	return
+}
+
+func (dfs *dfs) DeletePrefix(prefix string) (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return strings.HasPrefix(f.ID, prefix)
+	})
+}
+
+func (dfs *dfs) DeleteTagged(tag string) (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Tag == tag
+	})
+}
+
+func (dfs *dfs) DeleteBefore(before time.Time) (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Time.Before(before)
+	})
+}
+
+func (dfs *dfs) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return strings.HasPrefix(f.ID, prefix) && f.Time.Before(before)
+	})
+}
+
+func (dfs *dfs) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Tag == tag && f.Time.Before(before)
+	})
+}
+
+// DeleteWhere delete files by the where filter of the conditions "{column} {operator} ?" joined by AND,
+// for example "tag = ? AND time < ?". The other filters return errors.ErrUnsupported.
+func (dfs *dfs) DeleteWhere(where string, args ...any) (int64, error) {
+	conds, err := parseWhere(where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return matchWhere(f, conds)
+	})
+}
+
+// DeleteAll delete all files
+func (dfs *dfs) DeleteAll() (int64, error) {
+	return dfs.deleteFunc(func(f *xfs.File) bool {
+		return true
+	})
+}
+
+// Truncate remove all contents of the directory
+func (dfs *dfs) Truncate() error {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	des, err := os.ReadDir(dfs.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, de := range des {
+		if err := os.RemoveAll(filepath.Join(dfs.dir, de.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk call fn for each file metadata
+func (dfs *dfs) walk(fn func(path string, f *xfs.File) error) error {
+	err := filepath.WalkDir(dfs.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != metaExt {
+			return nil
+		}
+
+		f, err := dfs.readMeta(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		return fn(str.TrimSuffix(path, metaExt), f)
+	})
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (dfs *dfs) deleteFunc(match func(f *xfs.File) bool) (cnt int64, err error) {
+	dfs.mu.Lock()
+	defer dfs.mu.Unlock()
+
+	err = dfs.walk(func(path string, f *xfs.File) error {
+		if !match(f) {
+			return nil
+		}
+
+		if err := removeFile(path + metaExt); err != nil {
+			return err
+		}
+		if err := removeFile(path + dataExt); err != nil {
+			return err
+		}
+		cnt++
+		return nil
+	})
+	return
+}
@@ -0,0 +1,113 @@
+package dirxfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pango/sqx"
+	"github.com/askasoft/pangox/xfs/xfstest"
+)
+
+func TestDirXFS(t *testing.T) {
+	xfstest.TestXFS(t, FS(t.TempDir()))
+}
+
+func TestDirXFSNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	dfs := FS(dir)
+
+	tm := time.Now()
+	if _, err := dfs.SaveFile("/a.txt", "a.txt", tm, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dfs.CopyFile("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dfs.MoveFile("/b.txt", "/c.txt", "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && filepath.Ext(path) == ".tmp" {
+			t.Errorf("temporary file %q is left", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"/a.txt", "/c.txt"} {
+		if data, err := dfs.ReadFile(id); err != nil || string(data) != "a" {
+			t.Errorf("ReadFile(%q) = %q, %v", id, data, err)
+		}
+	}
+	if _, err := dfs.FindFile("/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FindFile(/b.txt) = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestDirXFSDeleteWhere(t *testing.T) {
+	dfs := FS(t.TempDir())
+
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.AddDate(0, 1, 0)
+	for _, c := range []struct {
+		id  string
+		tm  time.Time
+		tag string
+	}{
+		{"/a/1.txt", t1, "x"},
+		{"/a/2.txt", t2, "x"},
+		{"/a_b/3.txt", t1, "y"},
+		{"/b/4.csv", t1, "x"},
+	} {
+		if _, err := dfs.SaveFile(c.id, filepath.Base(c.id), c.tm, []byte(c.id), c.tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the invalid or unsupported filters
+	for i, c := range []struct {
+		where string
+		args  []any
+	}{
+		{"time < ? AND name = ?", []any{t1}},
+		{"id = ?", []any{1}},
+		{"size = ?", []any{"1"}},
+		{"time LIKE ?", []any{t1}},
+		{"data = ?", []any{"x"}},
+		{"tag = ? OR tag = ?", []any{"x", "y"}},
+		{"tag IN (?)", []any{"x"}},
+	} {
+		if cnt, err := dfs.DeleteWhere(c.where, c.args...); err == nil {
+			t.Errorf("[%d] DeleteWhere(%q) = %d, want error", i, c.where, cnt)
+		}
+	}
+	if _, err := dfs.DeleteWhere("data = ?", "x"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("DeleteWhere(data) = %v, want %v", err, errors.ErrUnsupported)
+	}
+
+	for i, c := range []struct {
+		where string
+		args  []any
+		want  int64
+	}{
+		{"id LIKE ? AND time < ?", []any{sqx.StartsLike("/a_"), t2}, 1},
+		{"tag = ? and time >= ?", []any{"x", t2}, 1},
+		{"ext NOT LIKE ? AND size <= ?", []any{".csv", 8}, 1},
+		{"tag = ?", []any{"y"}, 0},
+	} {
+		if cnt, err := dfs.DeleteWhere(c.where, c.args...); err != nil || cnt != c.want {
+			t.Errorf("[%d] DeleteWhere(%q) = %d, %v, want %d", i, c.where, cnt, err, c.want)
+		}
+	}
+
+	files, err := dfs.ListPrefix("/")
+	if err != nil || len(files) != 1 || files[0].ID != "/b/4.csv" {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+}
@@ -0,0 +1,184 @@
+package dirxfs
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pango/sqx"
+	"github.com/askasoft/pangox/xfs"
+)
+
+// whereCond a condition "{col} {op} ?" of the where filter
+type whereCond struct {
+	col string
+	op  string
+	arg any
+}
+
+var (
+	whereAnd  = regexp.MustCompile(`(?i)\s+AND\s+`)
+	whereExpr = regexp.MustCompile(`(?i)^\s*(\w+)\s*(=|!=|<>|<=|>=|<|>|NOT\s+LIKE|LIKE)\s*\?\s*$`)
+)
+
+// likeEscape the escape character of the sqx.EscapeLike()
+var likeEscape = sqx.EscapeLike("%")[0]
+
+// parseWhere parse the where filter of the conditions "{col} {op} ?" joined by AND.
+// The col is one of the id, name, ext, tag, size, hash, mime, time, and the op is one of the =, !=, <>, <, <=, >, >=, LIKE, NOT LIKE.
+func parseWhere(where string, args ...any) ([]*whereCond, error) {
+	if strings.TrimSpace(where) == "" {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("dirxfs: invalid where %q, %d args", where, len(args))
+		}
+		return nil, nil
+	}
+
+	exprs := whereAnd.Split(strings.TrimSpace(where), -1)
+
+	ms := make([][]string, len(exprs))
+	for i, expr := range exprs {
+		if ms[i] = whereExpr.FindStringSubmatch(expr); ms[i] == nil {
+			return nil, fmt.Errorf("dirxfs: unsupported where %q: %w", where, errors.ErrUnsupported)
+		}
+	}
+	if len(exprs) != len(args) {
+		return nil, fmt.Errorf("dirxfs: invalid where %q, %d args", where, len(args))
+	}
+
+	conds := make([]*whereCond, len(exprs))
+	for i, m := range ms {
+
+		col, op := strings.ToLower(m[1]), strings.ToUpper(strings.Join(strings.Fields(m[2]), " "))
+		like := op == "LIKE" || op == "NOT LIKE"
+
+		arg := args[i]
+		switch col {
+		case "id", "name", "ext", "tag", "hash", "mime":
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("dirxfs: invalid where %q, %T arg of %s", where, arg, col)
+			}
+			if like {
+				arg = likeRegexp(s)
+			}
+		case "size":
+			n, ok := toInt64(arg)
+			if !ok || like {
+				return nil, fmt.Errorf("dirxfs: invalid where %q, %T arg of %s %s", where, arg, col, op)
+			}
+			arg = n
+		case "time":
+			if _, ok := arg.(time.Time); !ok || like {
+				return nil, fmt.Errorf("dirxfs: invalid where %q, %T arg of %s %s", where, arg, col, op)
+			}
+		default:
+			return nil, fmt.Errorf("dirxfs: unsupported where %q, column %q: %w", where, col, errors.ErrUnsupported)
+		}
+
+		conds[i] = &whereCond{col: col, op: op, arg: arg}
+	}
+	return conds, nil
+}
+
+func toInt64(a any) (int64, bool) {
+	switch n := a.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint32:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// likeRegexp convert the LIKE pattern to a regexp
+func likeRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+
+	sb.WriteString("(?s)^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == likeEscape && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '%':
+			sb.WriteString(".*")
+		case c == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteByte('$')
+
+	return regexp.MustCompile(sb.String())
+}
+
+// matchWhere returns true if the file matches all conditions
+func matchWhere(f *xfs.File, conds []*whereCond) bool {
+	for _, wc := range conds {
+		if !wc.match(f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (wc *whereCond) match(f *xfs.File) bool {
+	var c int
+
+	switch wc.col {
+	case "size":
+		c = cmp.Compare(f.Size, wc.arg.(int64))
+	case "time":
+		c = f.Time.Compare(wc.arg.(time.Time))
+	default:
+		var s string
+		switch wc.col {
+		case "id":
+			s = f.ID
+		case "name":
+			s = f.Name
+		case "ext":
+			s = f.Ext
+		case "tag":
+			s = f.Tag
+		case "hash":
+			s = f.Hash
+		case "mime":
+			s = f.MIME
+		}
+
+		switch wc.op {
+		case "LIKE":
+			return wc.arg.(*regexp.Regexp).MatchString(s)
+		case "NOT LIKE":
+			return !wc.arg.(*regexp.Regexp).MatchString(s)
+		}
+		c = strings.Compare(s, wc.arg.(string))
+	}
+
+	switch wc.op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // ">="
+		return c >= 0
+	}
+}
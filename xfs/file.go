@@ -2,6 +2,8 @@ package xfs
 
 import (
 	"time"
+
+	"github.com/askasoft/pango/xin/taglib/args"
 )
 
 type File struct {
@@ -11,7 +13,43 @@ type File struct {
 	Tag  string    `gorm:"not null;default:'';index:idx_files_tag" json:"tag"`
 	Time time.Time `gorm:"not null" json:"time"`
 	Size int64     `gorm:"not null;" json:"size"`
+	Hash string    `gorm:"size:64;not null;default:''" json:"hash"`
+	MIME string    `gorm:"column:mime;size:255;not null;default:''" json:"mime"`
 	Data []byte    `gorm:"not null" json:"-"`
+
+	// DeletedAt the deleted time of the file in the trash bin, see TrashFS.
+	// It is not indexed, create a index of the column for the file table of the TrashFS if necessary.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Codec the content codings applied to the stored data in the applied order (for example "gzip,aesgcm"),
+	// empty for the plain data. It is stored by the EncodedSaver, see CompressFS and CryptFS.
+	Codec string `gorm:"size:64;not null;default:''" json:"codec,omitempty"`
+
+	// RawSize the size of the original data before the Codec encodings
+	RawSize int64 `gorm:"not null;default:0" json:"raw_size,omitempty"`
+}
+
+// ETag returns the strong entity tag of the file hash, returns "" if the hash is empty
+func (f *File) ETag() string {
+	if f.Hash == "" {
+		return ""
+	}
+	return `"` + f.Hash + `"`
+}
+
+// FileChunk a chunk of the file data (for the chunked storage layout).
+// FID is the file id, or the blob hash for the deduplicated storage layout.
+type FileChunk struct {
+	FID  string `gorm:"column:fid;size:255;not null;primaryKey" json:"fid"`
+	Seq  int    `gorm:"not null;primaryKey" json:"seq"`
+	Data []byte `gorm:"not null" json:"-"`
+}
+
+// FileBlob a content addressable blob referenced by the files with the same hash (for the deduplicated storage layout)
+type FileBlob struct {
+	Hash string `gorm:"size:64;not null;primaryKey" json:"hash"`
+	Size int64  `gorm:"not null" json:"size"`
+	Refs int64  `gorm:"not null" json:"refs"`
 }
 
 type FileResult struct {
@@ -21,3 +59,15 @@ type FileResult struct {
 type FilesResult struct {
 	Files []*File `json:"files"`
 }
+
+// FileQuery the query arguments of the files
+type FileQuery struct {
+	args.Pager
+	args.Orders
+	LastID  string    `json:"last_id,omitempty" form:"last_id,strip"` // the files which id is greater than LastID (for the keyset pagination)
+	Prefix  string    `json:"prefix,omitempty" form:"prefix,strip"`
+	Tag     string    `json:"tag,omitempty" form:"tag,strip"`
+	Exts    []string  `json:"exts,omitempty" form:"exts,strip,lower"`
+	TimeMin time.Time `json:"time_min,omitempty" form:"time_min,strip"`
+	TimeMax time.Time `json:"time_max,omitempty" form:"time_max,strip" validate:"omitempty,gtefield=TimeMin"`
+}
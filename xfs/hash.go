@@ -0,0 +1,55 @@
+package xfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// HashData returns the hex encoded SHA-256 hash of the data
+func HashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashReader computes the SHA-256 hash and size of the data read from the underlying reader,
+// and keeps the leading data (at most MIMESniffLen bytes) to detect the MIME type.
+type HashReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+	b []byte // leading data
+}
+
+// NewHashReader create a HashReader
+func NewHashReader(r io.Reader) *HashReader {
+	return &HashReader{r: r, h: sha256.New()}
+}
+
+func (hr *HashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if n > 0 {
+		hr.h.Write(p[:n])
+		hr.n += int64(n)
+		if len(hr.b) < MIMESniffLen {
+			hr.b = append(hr.b, p[:min(n, MIMESniffLen-len(hr.b))]...)
+		}
+	}
+	return n, err
+}
+
+// Size returns the size of the read data
+func (hr *HashReader) Size() int64 {
+	return hr.n
+}
+
+// Hash returns the hex encoded SHA-256 hash of the read data
+func (hr *HashReader) Hash() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
+
+// MIME returns the MIME type detected from the leading read data and the file extension, see DetectMIME()
+func (hr *HashReader) MIME(ext string) string {
+	return DetectMIME(hr.b, ext)
+}
@@ -4,8 +4,14 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/askasoft/pango/log"
 )
 
 // HFS converts xfs to a http.FileSystem implementation.
@@ -19,10 +25,22 @@ type hfs struct {
 }
 
 func (hfs *hfs) Open(name string) (http.File, error) {
+	// convert the http path "/a/b.txt" to the fs.FS path "a/b.txt"
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		name = "."
+	}
+
 	f, err := hfs.xfs.Open(name)
 	if err != nil {
 		return nil, err
 	}
+
+	// this disables directory access
+	if _, ok := f.(*FSDir); ok {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
 	return hfile{f}, nil
 }
 
@@ -49,3 +67,145 @@ func (hf hfile) Readdir(count int) ([]os.FileInfo, error) {
 	// this disables directory listing
 	return nil, nil
 }
+
+// EncodedFS is implemented by the XFS which stores the encoded file data (for example CompressFS),
+// so that ServeFile() can serve the encoded data directly if the client accepts the File.Codec encoding.
+type EncodedFS interface {
+	XFS
+
+	// EncodedContent returns a io.ReadSeeker of the stored encoded data of the file which File.Codec is not empty.
+	EncodedContent(f *File) (io.ReadSeeker, error)
+
+	// DecodedContent returns a io.ReadSeeker of the decoded data of the file which File.Codec is not empty.
+	DecodedContent(f *File) (io.ReadSeeker, error)
+}
+
+// FileServer returns a handler that serves the xfs file which id is the request url path.
+// The ETag header is set from the file hash, so the If-None-Match and If-Range
+// conditional requests are handled by http.ServeContent.
+func FileServer(xfs XFS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeFile(w, r, xfs, path.Clean("/"+r.URL.Path))
+	})
+}
+
+// ServeFile replies to the request with the contents of the xfs file.
+// The Content-Type header is set from the detected File.MIME, see setContentType(),
+// the file of the active or unknown type is served as "attachment".
+// If the xfs is a EncodedFS and the client accepts the File.Codec encoding,
+// the encoded data is served directly with the Content-Encoding header.
+func ServeFile(w http.ResponseWriter, r *http.Request, xfs XFS, id string) {
+	f, err := xfs.FindFile(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		serveError(w, id, err)
+		return
+	}
+
+	ServeFileContent(w, r, xfs, f)
+}
+
+// ServeFileContent replies to the request with the contents of the file f found by xfs.FindFile(),
+// so the file is not found again. See ServeFile() for details.
+func ServeFileContent(w http.ResponseWriter, r *http.Request, xfs XFS, f *File) {
+	setContentType(w, f)
+
+	var err error
+	var content io.ReadSeeker = &FSFile{XFS: xfs, File: f}
+
+	if efs, ok := xfs.(EncodedFS); ok && f.Codec != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), f.Codec) {
+			content, err = efs.EncodedContent(f)
+			if err != nil {
+				serveError(w, f.ID, err)
+				return
+			}
+
+			w.Header().Set("Content-Encoding", f.Codec)
+			if f.Hash != "" {
+				w.Header().Set("Etag", `"`+f.Hash+"-"+f.Codec+`"`)
+			}
+			http.ServeContent(w, r, f.Name, f.Time, content)
+			return
+		}
+
+		if content, err = efs.DecodedContent(f); err != nil {
+			serveError(w, f.ID, err)
+			return
+		}
+	}
+
+	if etag := f.ETag(); etag != "" {
+		w.Header().Set("Etag", etag)
+	}
+
+	http.ServeContent(w, r, f.Name, f.Time, content)
+}
+
+// serveError logs the error and replies the "500 Internal Server Error" without the error detail
+func serveError(w http.ResponseWriter, id string, err error) {
+	log.Errorf("xfs: failed to serve file %q: %v", id, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// ActiveMIMETypes the MIME types which can run scripts in the browser,
+// the files of them are always served as "attachment" by ServeFile() to prevent the stored XSS.
+var ActiveMIMETypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+	"text/xml",
+	"application/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/x-javascript",
+}
+
+// ContentType returns the Content-Type of the file to serve, the detected File.MIME or the MIME type of the file extension.
+// The generic "application/octet-stream" MIME type (or the MIME type of the encrypted/compressed data) is ignored.
+// Returns "" if the type is unknown.
+func ContentType(f *File) string {
+	if f.MIME == "" || f.MIME == "application/octet-stream" {
+		return mime.TypeByExtension(f.Ext)
+	}
+	return f.MIME
+}
+
+// Inlineable returns true if the file can be served as "inline".
+// The file of the unknown type or the active type (see ActiveMIMETypes) is not inlineable.
+func Inlineable(f *File) bool {
+	mt, _, err := mime.ParseMediaType(ContentType(f))
+	if err != nil {
+		return false
+	}
+	return !slices.Contains(ActiveMIMETypes, mt)
+}
+
+// setContentType set the Content-Type header to the ContentType() with the "X-Content-Type-Options: nosniff" header,
+// the unknown type is set as "application/octet-stream", so http.ServeContent does not sniff the Content-Type.
+// The file of the active or unknown type (see Inlineable) is served as "attachment" with the Content-Disposition header,
+// unless the "attachment" Content-Disposition header is already set by the caller.
+func setContentType(w http.ResponseWriter, f *File) {
+	h := w.Header()
+
+	ct := ContentType(f)
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+
+	h.Set("Content-Type", ct)
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	if !Inlineable(f) && !strings.HasPrefix(h.Get("Content-Disposition"), "attachment") {
+		cd := mime.FormatMediaType("attachment", map[string]string{"filename": f.Name})
+		if cd == "" {
+			cd = "attachment"
+		}
+		h.Set("Content-Disposition", cd)
+	}
+}
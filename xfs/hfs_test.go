@@ -0,0 +1,103 @@
+package xfs_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+func TestFileServerETag(t *testing.T) {
+	fs := dirxfs.FS(t.TempDir())
+
+	f, err := fs.SaveFile("/a/b.txt", "b.txt", time.Now(), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ETag() != `"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"` {
+		t.Fatalf("ETag() = %s", f.ETag())
+	}
+
+	h := xfs.FileServer(fs)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/b.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello" || w.Header().Get("Etag") != f.ETag() {
+		t.Errorf("GET = %d %q %q", w.Code, w.Body.String(), w.Header().Get("Etag"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/a/b.txt", nil)
+	r.Header.Set("If-None-Match", f.ETag())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match = %d, want %d", w.Code, http.StatusNotModified)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/none.txt", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET none = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+type errorFS struct {
+	xfs.XFS
+}
+
+func (efs errorFS) FindFile(id string) (*xfs.File, error) {
+	return nil, errors.New("secret error")
+}
+
+func TestFileServerError(t *testing.T) {
+	h := xfs.FileServer(errorFS{dirxfs.FS(t.TempDir())})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/b.txt", nil))
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("GET = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestFileServerActiveMIME(t *testing.T) {
+	fs := dirxfs.FS(t.TempDir())
+
+	cs := []struct {
+		id   string
+		data string
+		ct   string
+		cd   string
+	}{
+		{"/a.txt", "hello", "text/plain; charset=utf-8", ""},
+		{"/a.html", "<script>alert(1)</script>", "text/html; charset=utf-8", `attachment; filename=a.html`},
+		{"/a.svg", `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`, "image/svg+xml", `attachment; filename=a.svg`},
+		{"/a.unknown", "<html><script>alert(1)</script></html>", "text/html", `attachment; filename=a.unknown`},
+		{"/b.unknown", "\x00\x01\x02", "application/octet-stream", `attachment; filename=b.unknown`},
+	}
+
+	h := xfs.FileServer(fs)
+	for i, c := range cs {
+		if _, err := fs.SaveFile(c.id, c.id[1:], time.Now(), []byte(c.data)); err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.id, nil))
+
+		hd := w.Header()
+		if w.Code != http.StatusOK || hd.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("[%d] GET %s = %d, nosniff %q", i, c.id, w.Code, hd.Get("X-Content-Type-Options"))
+		}
+		if ct := hd.Get("Content-Type"); !strings.HasPrefix(ct, strings.Split(c.ct, ";")[0]) {
+			t.Errorf("[%d] GET %s Content-Type = %q, want %q", i, c.id, ct, c.ct)
+		}
+		if cd := hd.Get("Content-Disposition"); cd != c.cd {
+			t.Errorf("[%d] GET %s Content-Disposition = %q, want %q", i, c.id, cd, c.cd)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package memxfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pangox/xfs"
+)
+
+// mfile a file metadata and data stored in memory
+type mfile struct {
+	file xfs.File
+	data []byte
+}
+
+// info returns a copy of the file metadata, so the caller can not modify the stored metadata
+func (mf *mfile) info() *xfs.File {
+	f := mf.file
+	return &f
+}
+
+// mfs implements xfs.XFS interface
+type mfs struct {
+	files map[string]*mfile
+	mu    sync.RWMutex
+}
+
+// FS create a xfs.XFS which stores the files in memory.
+// It is useful for tests and the small temporary files.
+// DeleteWhere is not supported and returns errors.ErrUnsupported.
+func FS() xfs.XFS {
+	return &mfs{files: make(map[string]*mfile)}
+}
+
+func (mfs *mfs) Open(name string) (fs.File, error) {
+	return xfs.OpenFile(mfs, name)
+}
+
+func (mfs *mfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	return xfs.ReadDir(mfs, name)
+}
+
+func (mfs *mfs) Stat(name string) (fs.FileInfo, error) {
+	return xfs.Stat(mfs, name)
+}
+
+func (mfs *mfs) Glob(pattern string) ([]string, error) {
+	return xfs.Glob(mfs, pattern)
+}
+
+// FindFile find a file
+func (mfs *mfs) FindFile(id string) (*xfs.File, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	mf, ok := mfs.files[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return mf.info(), nil
+}
+
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (mfs *mfs) ListPrefix(prefix string) ([]*xfs.File, error) {
+	files := mfs.filter(func(f *xfs.File) bool {
+		return strings.HasPrefix(f.ID, prefix)
+	})
+
+	slices.SortFunc(files, func(a, b *xfs.File) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return files, nil
+}
+
+func (mfs *mfs) filter(match func(f *xfs.File) bool) []*xfs.File {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	var files []*xfs.File
+	for _, mf := range mfs.files {
+		if match(&mf.file) {
+			files = append(files, mf.info())
+		}
+	}
+	return files
+}
+
+func (mfs *mfs) CountFiles(fq *xfs.FileQuery) (int, error) {
+	files := mfs.filter(fq.Match)
+	return len(files), nil
+}
+
+func (mfs *mfs) FindFiles(fq *xfs.FileQuery) ([]*xfs.File, error) {
+	files := mfs.filter(fq.Match)
+	return xfs.QueryFiles(files, fq), nil
+}
+
+func (mfs *mfs) SumSize(fq *xfs.FileQuery) (size int64, err error) {
+	files := mfs.filter(fq.Match)
+	for _, f := range files {
+		size += f.Size
+	}
+	return
+}
+
+func (mfs *mfs) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*xfs.File, error) {
+	fi, err := mfs.SaveFileReader(id, filename, filetime, bytes.NewReader(data), tag...)
+	if fi != nil {
+		fi.Data = data
+	}
+	return fi, err
+}
+
+func (mfs *mfs) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*xfs.File, error) {
+	return mfs.saveFile(id, filename, filetime, r, nil, tag...)
+}
+
+// SaveEncodedFile save a file with the encoded data read from the reader and the encoding metadata
+func (mfs *mfs) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	return mfs.saveFile(id, filename, filetime, r, enc, tag...)
+}
+
+func (mfs *mfs) saveFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	name := filepath.Base(filename)
+	fext := str.ToLower(filepath.Ext(filename))
+
+	fi := &xfs.File{
+		ID:   id,
+		Name: name,
+		Ext:  fext,
+		Tag:  asg.First(tag),
+		Time: filetime,
+	}
+
+	// read the data without lock, so the reader can read the other files of the mfs
+	hr := xfs.NewHashReader(r)
+	data, err := io.ReadAll(hr)
+	if err != nil {
+		return fi, err
+	}
+
+	fi.Size, fi.Hash, fi.MIME = hr.Size(), hr.Hash(), hr.MIME(fi.Ext)
+	if enc != nil {
+		fi.Codec, fi.RawSize, fi.MIME = enc.Codec, enc.RawSize, enc.MIME
+	}
+
+	mfs.mu.Lock()
+	mfs.files[id] = &mfile{file: *fi, data: data}
+	mfs.mu.Unlock()
+
+	return fi, nil
+}
+
+func (mfs *mfs) readData(id string) ([]byte, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	mf, ok := mfs.files[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+
+	// the stored data is never modified (SaveFile replaces the entry), so it can be shared
+	return mf.data, nil
+}
+
+func (mfs *mfs) OpenReader(id string) (io.ReadCloser, error) {
+	data, err := mfs.readData(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (mfs *mfs) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	data, err := mfs.readData(id)
+	if err != nil {
+		return 0, err
+	}
+	return bytes.NewReader(data).ReadAt(p, off)
+}
+
+// ReadFile read the file data, the name can be a file id or a fs.FS path name
+func (mfs *mfs) ReadFile(name string) ([]byte, error) {
+	data, err := mfs.readData(xfs.FileID(name))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(data), nil
+}
+
+func (mfs *mfs) CopyFile(src, dst string, tag ...string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	mf, ok := mfs.files[src]
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	nf := &mfile{file: mf.file, data: mf.data}
+	nf.file.ID = dst
+	if len(tag) > 0 {
+		nf.file.Tag = tag[0]
+	}
+	mfs.files[dst] = nf
+	return nil
+}
+
+func (mfs *mfs) MoveFile(src, dst string, tag ...string) error {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	mf, ok := mfs.files[src]
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	delete(mfs.files, src)
+
+	mf.file.ID = dst
+	if len(tag) > 0 {
+		mf.file.Tag = tag[0]
+	}
+	mfs.files[dst] = mf
+	return nil
+}
+
+func (mfs *mfs) DeleteFile(id string) error {
+	mfs.mu.Lock()
+	delete(mfs.files, id)
+	mfs.mu.Unlock()
+	return nil
+}
+
+func (mfs *mfs) DeleteFiles(ids ...string) (cnt int64, err error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := mfs.files[id]; ok {
+			delete(mfs.files, id)
+			cnt++
+		}
+	}
+	return
+}
+
+func (mfs *mfs) DeletePrefix(prefix string) (int64, error) {
+	return mfs.deleteFunc(func(f *xfs.File) bool {
+		return strings.HasPrefix(f.ID, prefix)
+	})
+}
+
+func (mfs *mfs) DeleteTagged(tag string) (int64, error) {
+	return mfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Tag == tag
+	})
+}
+
+func (mfs *mfs) DeleteBefore(before time.Time) (int64, error) {
+	return mfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Time.Before(before)
+	})
+}
+
+func (mfs *mfs) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	return mfs.deleteFunc(func(f *xfs.File) bool {
+		return strings.HasPrefix(f.ID, prefix) && f.Time.Before(before)
+	})
+}
+
+func (mfs *mfs) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	return mfs.deleteFunc(func(f *xfs.File) bool {
+		return f.Tag == tag && f.Time.Before(before)
+	})
+}
+
+// DeleteWhere is not supported by the memory file system
+func (mfs *mfs) DeleteWhere(where string, args ...any) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// DeleteAll delete all files
+func (mfs *mfs) DeleteAll() (int64, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	cnt := int64(len(mfs.files))
+	clear(mfs.files)
+	return cnt, nil
+}
+
+// Truncate delete all files
+func (mfs *mfs) Truncate() error {
+	_, err := mfs.DeleteAll()
+	return err
+}
+
+func (mfs *mfs) deleteFunc(match func(f *xfs.File) bool) (cnt int64, err error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	for id, mf := range mfs.files {
+		if match(&mf.file) {
+			delete(mfs.files, id)
+			cnt++
+		}
+	}
+	return
+}
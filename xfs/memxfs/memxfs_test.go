@@ -0,0 +1,11 @@
+package memxfs
+
+import (
+	"testing"
+
+	"github.com/askasoft/pangox/xfs/xfstest"
+)
+
+func TestMemXFS(t *testing.T) {
+	xfstest.TestXFS(t, FS())
+}
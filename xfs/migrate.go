@@ -0,0 +1,184 @@
+package xfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrMigrateVerify indicates the migrated file does not match the source file
+	ErrMigrateVerify = errors.New("xfs: migrated file verification failed")
+
+	// ErrMigrateChanged indicates the source file is changed during the migration, so it is not deleted
+	ErrMigrateChanged = errors.New("xfs: source file changed during migration")
+)
+
+// Migrator copies the files from the Src XFS to the Dst XFS in batches ordered by the file id.
+// The migration is resumable by the last migrated file id (see Migrate()).
+//
+// The stored (encoded) data of the files is copied with the encoding metadata, and the size and hash of
+// the stored data are verified, so the Src and Dst must be the raw XFS which are not wrapped by the encoding
+// wrappers (CompressFS, CryptFS). Migrate the files of the wrapped XFS, and wrap the Dst the same way after the migration.
+type Migrator struct {
+	Src XFS
+	Dst XFS
+
+	// Prefix migrate the files which id starts with the prefix
+	Prefix string
+
+	// Tag migrate the files with the tag
+	Tag string
+
+	// Batch the count of the files to find in a batch, default 1000
+	Batch int
+
+	// Delete delete the source file after the migrated file is verified,
+	// and the source file is not changed during the migration
+	Delete bool
+}
+
+// NewMigrator create a Migrator
+func NewMigrator(src, dst XFS) *Migrator {
+	return &Migrator{Src: src, Dst: dst, Batch: 1000}
+}
+
+func (m *Migrator) query(lastID string) *FileQuery {
+	fq := &FileQuery{LastID: lastID, Prefix: m.Prefix, Tag: m.Tag}
+	fq.Order = "id"
+	return fq
+}
+
+// CountFiles count the source files which id is greater than lastID
+func (m *Migrator) CountFiles(lastID string) (int, error) {
+	return m.Src.CountFiles(m.query(lastID))
+}
+
+// FindFiles find the next batch of the source files which id is greater than lastID, ordered by id
+func (m *Migrator) FindFiles(lastID string) ([]*File, error) {
+	fq := m.query(lastID)
+	fq.Limit = m.Batch
+	if fq.Limit <= 0 {
+		fq.Limit = 1000
+	}
+	return m.Src.FindFiles(fq)
+}
+
+// save save the data of the source file to the destination, the encoding metadata of the encoded file is kept
+func (m *Migrator) save(f *File, r io.Reader) (*File, error) {
+	if f.Codec == "" {
+		return m.Dst.SaveFileReader(f.ID, f.Name, f.Time, r, f.Tag)
+	}
+
+	es, err := encodedSaver(m.Dst)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := &Encoding{Codec: f.Codec, RawSize: f.RawSize, MIME: f.MIME}
+	return es.SaveEncodedFile(f.ID, f.Name, f.Time, r, enc, f.Tag)
+}
+
+// encoder returns the encoding wrapper (CompressFS, CryptFS) found by unwrapping the xfs (see Unwrapper), or nil
+func encoder(xfs XFS) XFS {
+	for x := xfs; ; {
+		switch x.(type) {
+		case *CompressFS, *CryptFS:
+			return x
+		}
+
+		u, ok := x.(Unwrapper)
+		if !ok {
+			return nil
+		}
+		x = u.Unwrap()
+	}
+}
+
+// check returns a errors.ErrUnsupported error if the Src or Dst is wrapped by a encoding wrapper
+func (m *Migrator) check() error {
+	for _, x := range []XFS{m.Src, m.Dst} {
+		if e := encoder(x); e != nil {
+			return fmt.Errorf("xfs: %T can not be migrated by the encoding wrapper %T: %w", x, e, errors.ErrUnsupported)
+		}
+	}
+	return nil
+}
+
+// MigrateFile copy the stored data of the source file to the destination, verify the size and hash of the stored data,
+// and delete the source file if Delete is true.
+// The hash is not verified if either hash is empty.
+// Before the source file is deleted, the size, hash and time of the source file are checked again,
+// and ErrMigrateChanged is returned if the source file is changed during the migration.
+// Returns a errors.ErrUnsupported error if the Src or Dst is wrapped by a encoding wrapper.
+func (m *Migrator) MigrateFile(f *File) error {
+	if err := m.check(); err != nil {
+		return err
+	}
+
+	r, err := m.Src.OpenReader(f.ID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	df, err := m.save(f, r)
+	if err != nil {
+		return err
+	}
+
+	if df.Size != f.Size {
+		return fmt.Errorf("%w: %q size %d != %d", ErrMigrateVerify, f.ID, df.Size, f.Size)
+	}
+	if f.Hash != "" && df.Hash != "" && df.Hash != f.Hash {
+		return fmt.Errorf("%w: %q hash %s != %s", ErrMigrateVerify, f.ID, df.Hash, f.Hash)
+	}
+
+	if m.Delete {
+		sf, err := m.Src.FindFile(f.ID)
+		if err != nil {
+			return err
+		}
+		if sf.Size != f.Size || sf.Hash != f.Hash || !sf.Time.Equal(f.Time) {
+			return fmt.Errorf("%w: %q", ErrMigrateChanged, f.ID)
+		}
+		return m.Src.DeleteFile(f.ID)
+	}
+	return nil
+}
+
+// Migrate migrate the source files which id is greater than lastID until all files are migrated or the context is done.
+// The callback is called after each file is migrated with the migration error (can be nil),
+// the migration is stopped if the callback returns a error.
+// Returns the last processed file id, which can be used to resume the migration.
+func (m *Migrator) Migrate(ctx context.Context, lastID string, callback func(f *File, err error) error) (string, error) {
+	if err := m.check(); err != nil {
+		return lastID, err
+	}
+
+	for {
+		files, err := m.FindFiles(lastID)
+		if err != nil {
+			return lastID, err
+		}
+		if len(files) == 0 {
+			return lastID, nil
+		}
+
+		for _, f := range files {
+			if err := ctx.Err(); err != nil {
+				return lastID, err
+			}
+
+			err := m.MigrateFile(f)
+			lastID = f.ID
+
+			if callback != nil {
+				if err := callback(f, err); err != nil {
+					return lastID, err
+				}
+			}
+		}
+	}
+}
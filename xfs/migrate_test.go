@@ -0,0 +1,157 @@
+package xfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+func TestMigrator(t *testing.T) {
+	src := dirxfs.FS(t.TempDir())
+	dst := dirxfs.FS(t.TempDir())
+
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("/m/%d.txt", i)
+		if _, err := src.SaveFile(id, fmt.Sprintf("%d.txt", i), time.Now(), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := src.SaveFile("/x/skip.txt", "skip.txt", time.Now(), []byte("skip")); err != nil {
+		t.Fatal(err)
+	}
+
+	m := xfs.NewMigrator(src, dst)
+	m.Prefix = "/m/"
+	m.Batch = 2
+
+	cnt, err := m.CountFiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 5 {
+		t.Fatalf("CountFiles() = %d, want 5", cnt)
+	}
+
+	// stop after 3 files, then resume from the last id
+	n := 0
+	stop := errors.New("stop")
+	last, err := m.Migrate(context.Background(), "", func(f *xfs.File, err error) error {
+		if err != nil {
+			t.Errorf("migrate %q: %v", f.ID, err)
+		}
+		n++
+		if n == 3 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || last != "/m/2.txt" {
+		t.Fatalf("Migrate() = (%q, %v), want (%q, %v)", last, err, "/m/2.txt", stop)
+	}
+
+	m.Delete = true
+	last, err = m.Migrate(context.Background(), last, nil)
+	if err != nil || last != "/m/4.txt" {
+		t.Fatalf("Migrate() = (%q, %v), want (%q, nil)", last, err, "/m/4.txt")
+	}
+
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("/m/%d.txt", i)
+		data, err := dst.ReadFile(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != id {
+			t.Errorf("ReadFile(%q) = %q", id, data)
+		}
+
+		_, err = src.FindFile(id)
+		if (i >= 3) != (err != nil) {
+			t.Errorf("src.FindFile(%q) = %v", id, err)
+		}
+	}
+
+	if _, err := dst.FindFile("/x/skip.txt"); err == nil {
+		t.Error("/x/skip.txt should not be migrated")
+	}
+}
+
+// changeFS changes the file after the file data is read
+type changeFS struct {
+	xfs.XFS
+}
+
+func (cfs changeFS) OpenReader(id string) (io.ReadCloser, error) {
+	data, err := cfs.XFS.ReadFile(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cfs.XFS.SaveFile(id, "a.txt", time.Now(), []byte("changed")); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestMigratorChanged(t *testing.T) {
+	src := dirxfs.FS(t.TempDir())
+	dst := dirxfs.FS(t.TempDir())
+
+	f, err := src.SaveFile("/a.txt", "a.txt", time.Now().Add(-time.Hour), []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := xfs.NewMigrator(changeFS{src}, dst)
+	m.Delete = true
+
+	if err := m.MigrateFile(f); !errors.Is(err, xfs.ErrMigrateChanged) {
+		t.Errorf("MigrateFile() = %v, want %v", err, xfs.ErrMigrateChanged)
+	}
+	if bs, err := src.ReadFile("/a.txt"); err != nil || string(bs) != "changed" {
+		t.Errorf("src.ReadFile() = %q, %v", bs, err)
+	}
+}
+
+func TestMigratorEncoded(t *testing.T) {
+	src := dirxfs.FS(t.TempDir())
+	dst := dirxfs.FS(t.TempDir())
+
+	key := xfs.SecretKey("k1", "secret")
+	scfs, err := xfs.NewCryptFS(xfs.NewCompressFS(src, &xfs.CompressRule{Codec: xfs.GzipCodec}), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("encoded "), 100)
+	if _, err := scfs.SaveFile("/a.txt", "a.txt", time.Now(), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the encoding wrappers can not be migrated
+	for _, m := range []*xfs.Migrator{xfs.NewMigrator(scfs, dst), xfs.NewMigrator(src, xfs.NewQuotaFS(scfs))} {
+		if _, err := m.Migrate(context.Background(), "", nil); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("Migrate(%T, %T) = %v, want %v", m.Src, m.Dst, err, errors.ErrUnsupported)
+		}
+	}
+
+	m := xfs.NewMigrator(src, dst)
+	m.Delete = true
+	if _, err := m.Migrate(context.Background(), "", func(f *xfs.File, err error) error { return err }); err != nil {
+		t.Fatal(err)
+	}
+
+	dcfs, err := xfs.NewCryptFS(xfs.NewCompressFS(dst), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := dcfs.ReadFile("/a.txt"); err != nil || !bytes.Equal(bs, data) {
+		t.Errorf("ReadFile() = %q, %v", bs, err)
+	}
+}
@@ -0,0 +1,183 @@
+package xfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pango/str"
+)
+
+// MIMESniffLen the maximum data length to detect the MIME type
+const MIMESniffLen = 512
+
+// ErrMIMENotAllowed indicates the MIME type of the file is not allowed
+var ErrMIMENotAllowed = errors.New("xfs: mime type not allowed")
+
+// MIMEError the error of the not allowed MIME type, it unwraps to ErrMIMENotAllowed
+type MIMEError struct {
+	MIME string
+}
+
+func (me *MIMEError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrMIMENotAllowed, me.MIME)
+}
+
+func (me *MIMEError) Unwrap() error {
+	return ErrMIMENotAllowed
+}
+
+// the magic numbers which http.DetectContentType() does not detect
+var (
+	magicPDF   = []byte("%PDF-")
+	magicZIP   = []byte("PK\x03\x04")
+	magicOLE   = []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")
+	magicMZ    = []byte("MZ")
+	magicELF   = []byte("\x7FELF")
+	magicMachO = [][]byte{
+		[]byte("\xFE\xED\xFA\xCE"), []byte("\xFE\xED\xFA\xCF"),
+		[]byte("\xCE\xFA\xED\xFE"), []byte("\xCF\xFA\xED\xFE"),
+	}
+)
+
+// the MIME types of the zip based document formats
+var zipMIMETypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".epub": "application/epub+zip",
+	".jar":  "application/java-archive",
+}
+
+// the MIME types of the OLE2 compound file based document formats
+var oleMIMETypes = map[string]string{
+	".doc": "application/msword",
+	".xls": "application/vnd.ms-excel",
+	".ppt": "application/vnd.ms-powerpoint",
+	".msg": "application/vnd.ms-outlook",
+}
+
+// DetectMIME detects the MIME type of the file from the leading data (at most MIMESniffLen bytes) and the file extension.
+// The data is detected by the magic numbers of pdf, zip, office (OLE2) and executables, then http.DetectContentType().
+// The file extension is only used to refine the type of the zip/OLE2 container (for example ".docx")
+// and the generic "text/plain" type (for example ".csv"), so a executable named ".pdf" is detected as a executable.
+func DetectMIME(data []byte, ext string) string {
+	if len(data) > MIMESniffLen {
+		data = data[:MIMESniffLen]
+	}
+
+	ext = str.ToLower(ext)
+
+	switch {
+	case bytes.HasPrefix(data, magicPDF):
+		return "application/pdf"
+	case bytes.HasPrefix(data, magicZIP):
+		if mt, ok := zipMIMETypes[ext]; ok {
+			return mt
+		}
+		return "application/zip"
+	case bytes.HasPrefix(data, magicOLE):
+		if mt, ok := oleMIMETypes[ext]; ok {
+			return mt
+		}
+		return "application/x-ole-storage"
+	case bytes.HasPrefix(data, magicMZ):
+		return "application/vnd.microsoft.portable-executable"
+	case bytes.HasPrefix(data, magicELF):
+		return "application/x-elf"
+	}
+
+	for _, m := range magicMachO {
+		if bytes.HasPrefix(data, m) {
+			return "application/x-mach-binary"
+		}
+	}
+
+	mt := http.DetectContentType(data)
+	if strings.HasPrefix(mt, "text/plain") {
+		if et := mime.TypeByExtension(ext); et != "" && isTextMIME(et) {
+			return et
+		}
+	}
+	return mt
+}
+
+// isTextMIME returns true if the MIME type is a text based type
+func isTextMIME(mt string) bool {
+	mt, _, _ = strings.Cut(mt, ";")
+	return strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "+xml") || strings.HasSuffix(mt, "+json") ||
+		mt == "application/json" || mt == "application/javascript" || mt == "application/xml"
+}
+
+// MIMEAllowlist the allowed MIME types of the file.
+// A item can be a exact MIME type "application/pdf" or a wildcard type "image/*".
+// The parameters of the MIME type (for example "; charset=utf-8") are ignored.
+type MIMEAllowlist []string
+
+// Allowed returns true if the MIME type is allowed
+func (ma MIMEAllowlist) Allowed(mt string) bool {
+	mt, _, _ = strings.Cut(mt, ";")
+	mt = str.ToLower(strings.TrimSpace(mt))
+
+	for _, a := range ma {
+		if a == mt || a == "*/*" {
+			return true
+		}
+		if p, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mt, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns a *MIMEError if the MIME type is not allowed
+func (ma MIMEAllowlist) Validate(mt string) error {
+	if ma.Allowed(mt) {
+		return nil
+	}
+	return &MIMEError{MIME: mt}
+}
+
+// SaveUploadedFile detects the MIME type of the uploaded file, save the file if the MIME type is allowed,
+// otherwise returns a *MIMEError. See SaveFileReadSeeker() for details.
+func (ma MIMEAllowlist) SaveUploadedFile(xfs XFS, id string, file *multipart.FileHeader, tag ...string) (*File, error) {
+	fr, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	filename := str.ToValidUTF8(file.Filename, " ")
+	return ma.SaveFileReadSeeker(xfs, id, filename, time.Now(), fr, tag...)
+}
+
+// SaveFileReadSeeker detects the MIME type of the data read from r, save the data if the MIME type is allowed,
+// otherwise returns a *MIMEError.
+// If xfs is a ScannedSaver (see ScanFS), the data is scanned by SaveScanned().
+func (ma MIMEAllowlist) SaveFileReadSeeker(xfs XFS, id string, filename string, filetime time.Time, r io.ReadSeeker, tag ...string) (*File, error) {
+	head := make([]byte, MIMESniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	if err := ma.Validate(DetectMIME(head[:n], filepath.Ext(filename))); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return saveScanned(xfs, id, filename, filetime, r, tag...)
+}
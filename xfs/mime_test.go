@@ -0,0 +1,77 @@
+package xfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDetectMIME(t *testing.T) {
+	cs := []struct {
+		data string
+		ext  string
+		want string
+	}{
+		{"%PDF-1.7\n", ".pdf", "application/pdf"},
+		{"MZ\x90\x00\x03\x00", ".pdf", "application/vnd.microsoft.portable-executable"},
+		{"\x7FELF\x02\x01\x01", ".png", "application/x-elf"},
+		{"PK\x03\x04\x14\x00", ".DOCX", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"PK\x03\x04\x14\x00", ".bin", "application/zip"},
+		{"\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1\x00", ".xls", "application/vnd.ms-excel"},
+		{"\x89PNG\x0D\x0A\x1A\x0A", ".jpg", "image/png"},
+		{"a,b,c\n1,2,3\n", ".csv", "text/csv; charset=utf-8"},
+		{"hello", ".txt", "text/plain; charset=utf-8"},
+		{"hello", ".exe", "text/plain; charset=utf-8"},
+	}
+
+	for i, c := range cs {
+		a := DetectMIME([]byte(c.data), c.ext)
+		if a != c.want {
+			t.Errorf("#%d DetectMIME(%q, %q) = %q, want %q", i, c.data, c.ext, a, c.want)
+		}
+	}
+}
+
+func TestHashReaderMIME(t *testing.T) {
+	hr := NewHashReader(strings.NewReader("%PDF-" + strings.Repeat("x", 1000)))
+
+	buf := make([]byte, 3)
+	for {
+		if _, err := hr.Read(buf); err != nil {
+			break
+		}
+	}
+
+	if len(hr.b) != MIMESniffLen {
+		t.Errorf("len(b) = %d, want %d", len(hr.b), MIMESniffLen)
+	}
+	if a := hr.MIME(".txt"); a != "application/pdf" {
+		t.Errorf("MIME() = %q, want %q", a, "application/pdf")
+	}
+}
+
+func TestMIMEAllowlist(t *testing.T) {
+	ma := MIMEAllowlist{"image/*", "application/pdf"}
+
+	cs := []struct {
+		mime string
+		want bool
+	}{
+		{"image/png", true},
+		{"application/pdf", true},
+		{"Application/PDF; x=y", true},
+		{"text/plain; charset=utf-8", false},
+		{"application/vnd.microsoft.portable-executable", false},
+	}
+
+	for i, c := range cs {
+		if a := ma.Allowed(c.mime); a != c.want {
+			t.Errorf("#%d Allowed(%q) = %v, want %v", i, c.mime, a, c.want)
+		}
+	}
+
+	err := ma.Validate("application/zip")
+	if !errors.Is(err, ErrMIMENotAllowed) {
+		t.Errorf("Validate() = %v, want %v", err, ErrMIMENotAllowed)
+	}
+}
@@ -0,0 +1,127 @@
+package xfs
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/str"
+)
+
+// Match returns true if the file matches the query conditions (the pager and orders are ignored)
+func (fq *FileQuery) Match(f *File) bool {
+	if fq.LastID != "" && f.ID <= fq.LastID {
+		return false
+	}
+	if fq.Prefix != "" && !strings.HasPrefix(f.ID, fq.Prefix) {
+		return false
+	}
+	if fq.Tag != "" && f.Tag != fq.Tag {
+		return false
+	}
+	if len(fq.Exts) > 0 && !asg.Contains(fq.Exts, f.Ext) {
+		return false
+	}
+	if !fq.TimeMin.IsZero() && f.Time.Before(fq.TimeMin) {
+		return false
+	}
+	if !fq.TimeMax.IsZero() && f.Time.After(fq.TimeMax) {
+		return false
+	}
+	return true
+}
+
+// QueryFiles filter, sort and paginate the files by the query.
+// It is used by the XFS implementations which can not query the files by SQL.
+// The supported order columns are "id", "name", "ext", "tag", "time", "size" ("-" prefix for descending order).
+func QueryFiles(files []*File, fq *FileQuery) []*File {
+	var rs []*File
+	for _, f := range files {
+		if fq.Match(f) {
+			rs = append(rs, f)
+		}
+	}
+
+	ods := str.FieldsByte(fq.Order, ',')
+	ods = append(ods, "id")
+
+	slices.SortStableFunc(rs, func(a, b *File) int {
+		for _, od := range ods {
+			desc := strings.HasPrefix(od, "-")
+			if desc {
+				od = od[1:]
+			}
+
+			c := compareFile(a, b, od)
+			if c != 0 {
+				if desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+
+	start := fq.Start()
+	if start >= len(rs) {
+		return nil
+	}
+	rs = rs[start:]
+
+	if fq.Limit > 0 && fq.Limit < len(rs) {
+		rs = rs[:fq.Limit]
+	}
+	return rs
+}
+
+func compareFile(a, b *File, col string) int {
+	switch col {
+	case "id":
+		return strings.Compare(a.ID, b.ID)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "ext":
+		return strings.Compare(a.Ext, b.Ext)
+	case "tag":
+		return strings.Compare(a.Tag, b.Tag)
+	case "time":
+		return a.Time.Compare(b.Time)
+	case "size":
+		return cmp.Compare(a.Size, b.Size)
+	default:
+		return 0
+	}
+}
+
+// sumFiles sum the size of the files found by xfs.FindFiles() in batches of 1000 files (the pager and orders are ignored).
+// It is used by the XFS wrappers which File.Size is not the stored size.
+func sumFiles(xfs XFS, fq *FileQuery) (size int64, err error) {
+	q := &FileQuery{
+		LastID:  fq.LastID,
+		Prefix:  fq.Prefix,
+		Tag:     fq.Tag,
+		Exts:    fq.Exts,
+		TimeMin: fq.TimeMin,
+		TimeMax: fq.TimeMax,
+	}
+	q.Order = "id"
+	q.Limit = 1000
+
+	for {
+		files, err := xfs.FindFiles(q)
+		if err != nil {
+			return size, err
+		}
+
+		for _, f := range files {
+			size += f.Size
+		}
+
+		if len(files) < q.Limit {
+			return size, nil
+		}
+		q.LastID = files[len(files)-1].ID
+	}
+}
@@ -0,0 +1,437 @@
+package xfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/asg"
+)
+
+// ErrQuotaExceeded indicates the storage quota is exceeded
+var ErrQuotaExceeded = errors.New("xfs: quota exceeded")
+
+// QuotaExceededError the error returned when saving a file would exceed the storage quota
+type QuotaExceededError struct {
+	Quota *Quota
+	Usage int64 // the current usage
+}
+
+func (qe *QuotaExceededError) Error() string {
+	return fmt.Sprintf("xfs: quota %s exceeded (usage: %d, limit: %d)", qe.Quota, qe.Usage, qe.Quota.Limit)
+}
+
+func (qe *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Quota the storage limit of the files which id starts with Prefix and tag is Tag.
+// An empty Prefix or Tag matches all files.
+type Quota struct {
+	Prefix string `json:"prefix,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Limit  int64  `json:"limit"`
+}
+
+func (q *Quota) String() string {
+	return fmt.Sprintf("[prefix: %q, tag: %q]", q.Prefix, q.Tag)
+}
+
+// Match returns true if the file of id and tag is restricted by the quota
+func (q *Quota) Match(id, tag string) bool {
+	return strings.HasPrefix(id, q.Prefix) && (q.Tag == "" || q.Tag == tag)
+}
+
+// QuotaUsage the usage of the quota
+type QuotaUsage struct {
+	Quota
+	Usage int64 `json:"usage"`
+}
+
+// quota the usage counter and the in-flight reserved size of a quota
+type quota struct {
+	*Quota
+	usage   int64     // the stored usage
+	loaded  time.Time // the time when the usage is loaded, zero if the usage is not loaded
+	pending int64
+}
+
+// QuotaFS wraps a XFS to reject the file saving that would push the usage over the quota limit.
+// The usage of a quota is loaded by XFS.SumSize() on the first use, then it is counted by the files
+// saved/copied/moved by this QuotaFS, so the XFS.SumSize() is not called on each save.
+// The usage is reloaded after the files are deleted by this QuotaFS, or after the UsageTTL.
+// The sizes of the in-flight saves of this QuotaFS are reserved until the saves are finished (committed or rolled back).
+// The files saved or deleted by the other processes are not counted until the usage is reloaded,
+// so the limit may be exceeded by them.
+type QuotaFS struct {
+	XFS
+
+	// UsageTTL the duration to reload the usage by XFS.SumSize(), 0 means the usage is not reloaded by time
+	UsageTTL time.Duration
+
+	mu sync.Mutex
+	qs []*quota
+}
+
+// NewQuotaFS create a QuotaFS
+func NewQuotaFS(xfs XFS, quotas ...*Quota) *QuotaFS {
+	qfs := &QuotaFS{XFS: xfs}
+	qfs.SetQuotas(quotas...)
+	return qfs
+}
+
+// Unwrap returns the wrapped XFS
+func (qfs *QuotaFS) Unwrap() XFS {
+	return qfs.XFS
+}
+
+// SetQuotas set the quotas, the usages of the quotas are loaded on the next use
+func (qfs *QuotaFS) SetQuotas(quotas ...*Quota) {
+	qs := make([]*quota, len(quotas))
+	for i, q := range quotas {
+		qs[i] = &quota{Quota: q}
+	}
+
+	qfs.mu.Lock()
+	qfs.qs = qs
+	qfs.mu.Unlock()
+}
+
+// Usages returns the usages of the quotas
+func (qfs *QuotaFS) Usages() ([]*QuotaUsage, error) {
+	qfs.mu.Lock()
+	defer qfs.mu.Unlock()
+
+	qus := make([]*QuotaUsage, 0, len(qfs.qs))
+	for _, q := range qfs.qs {
+		if err := qfs.load(q); err != nil {
+			return nil, err
+		}
+		qus = append(qus, &QuotaUsage{Quota: *q.Quota, Usage: q.usage})
+	}
+	return qus, nil
+}
+
+// load load the usage of the quota by XFS.SumSize() if it is not loaded or expired, qfs.mu must be locked
+func (qfs *QuotaFS) load(q *quota) error {
+	if !q.loaded.IsZero() && (qfs.UsageTTL <= 0 || time.Since(q.loaded) < qfs.UsageTTL) {
+		return nil
+	}
+
+	usage, err := qfs.XFS.SumSize(&FileQuery{Prefix: q.Prefix, Tag: q.Tag})
+	if err != nil {
+		return err
+	}
+
+	q.usage, q.loaded = usage, time.Now()
+	return nil
+}
+
+// reload mark the usages of the quotas to be reloaded on the next use
+func (qfs *QuotaFS) reload() {
+	qfs.mu.Lock()
+	defer qfs.mu.Unlock()
+
+	for _, q := range qfs.qs {
+		q.loaded = time.Time{}
+	}
+}
+
+// quotaReservation the reserved size of a quota for a file saving
+type quotaReservation struct {
+	q        *quota
+	credit   int64 // the size of the stored files in the quota which are replaced by the saving file
+	reserved int64
+}
+
+// reservation the reserved sizes of the quotas for a file saving
+type reservation struct {
+	qfs *QuotaFS
+	qs  []*quota // all quotas to count the usages of the committed file
+	qrs []*quotaReservation
+}
+
+// begin returns a reservation of the quotas which match the file of id and tag.
+// credit returns the size of the stored files in the quota which are replaced by the saving file.
+// The reservation must be finished by reservation.end().
+func (qfs *QuotaFS) begin(id, tag string, credit func(q *Quota) int64) (*reservation, error) {
+	qfs.mu.Lock()
+	defer qfs.mu.Unlock()
+
+	rs := &reservation{qfs: qfs, qs: qfs.qs}
+	for _, q := range qfs.qs {
+		if q.Match(id, tag) {
+			if err := qfs.load(q); err != nil {
+				return nil, err
+			}
+			rs.qrs = append(rs.qrs, &quotaReservation{q: q, credit: credit(q.Quota)})
+		}
+	}
+	return rs, nil
+}
+
+// reserve reserve the quotas for the saving file of size,
+// returns QuotaExceededError if the usage of any quota would exceed the limit.
+func (rs *reservation) reserve(size int64) error {
+	rs.qfs.mu.Lock()
+	defer rs.qfs.mu.Unlock()
+
+	for _, qr := range rs.qrs {
+		need := max(size-qr.credit, 0)
+		if need > qr.reserved {
+			// the usage with the reserved sizes of the other in-flight saves
+			usage := qr.q.usage + qr.q.pending - qr.reserved
+			if usage+need > qr.q.Limit {
+				return &QuotaExceededError{Quota: qr.q.Quota, Usage: usage}
+			}
+		}
+	}
+
+	for _, qr := range rs.qrs {
+		need := max(size-qr.credit, 0)
+		if need > qr.reserved {
+			qr.q.pending += need - qr.reserved
+			qr.reserved = need
+		}
+	}
+	return nil
+}
+
+// end release the reserved sizes, and count the usages of the saved file and the replaced files if err is nil.
+// The usages are reloaded if err is not a QuotaExceededError, because the files may be changed partially.
+func (rs *reservation) end(err error, saved *File, replaced ...*File) {
+	rs.qfs.mu.Lock()
+	defer rs.qfs.mu.Unlock()
+
+	for _, qr := range rs.qrs {
+		qr.q.pending -= qr.reserved
+		qr.reserved = 0
+	}
+
+	if err != nil {
+		if !errors.Is(err, ErrQuotaExceeded) {
+			for _, q := range rs.qs {
+				q.loaded = time.Time{}
+			}
+		}
+		return
+	}
+
+	for _, q := range rs.qs {
+		if q.loaded.IsZero() {
+			continue
+		}
+		if saved != nil && q.Match(saved.ID, saved.Tag) {
+			q.usage += saved.Size
+		}
+		for _, f := range replaced {
+			if f != nil && q.Match(f.ID, f.Tag) {
+				q.usage -= f.Size
+			}
+		}
+	}
+}
+
+// findFile returns nil if the file does not exist
+func (qfs *QuotaFS) findFile(id string) (*File, error) {
+	f, err := qfs.XFS.FindFile(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// creditOf returns the credit function of the files which are replaced by the saving file
+func creditOf(files ...*File) func(q *Quota) int64 {
+	return func(q *Quota) (size int64) {
+		for _, f := range files {
+			if f != nil && q.Match(f.ID, f.Tag) {
+				size += f.Size
+			}
+		}
+		return
+	}
+}
+
+func (qfs *QuotaFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	fi, err := qfs.SaveFileReader(id, filename, filetime, bytes.NewReader(data), tag...)
+	if fi != nil {
+		fi.Data = data
+	}
+	return fi, err
+}
+
+func (qfs *QuotaFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	return qfs.saveReader(id, tag, r, func(r io.Reader) (*File, error) {
+		return qfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+	})
+}
+
+// SaveEncodedFile save the encoded data read from the reader to the wrapped XFS,
+// the wrapped XFS must be a EncodedSaver.
+func (qfs *QuotaFS) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, tag ...string) (*File, error) {
+	es, err := encodedSaver(qfs.XFS)
+	if err != nil {
+		return nil, err
+	}
+
+	return qfs.saveReader(id, tag, r, func(r io.Reader) (*File, error) {
+		return es.SaveEncodedFile(id, filename, filetime, r, enc, tag...)
+	})
+}
+
+// saveReader save the file by the save function with the reader which reserves the quotas for the read data
+func (qfs *QuotaFS) saveReader(id string, tag []string, r io.Reader, save func(r io.Reader) (*File, error)) (fi *File, err error) {
+	old, err := qfs.findFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := qfs.begin(id, asg.First(tag), creditOf(old))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { rs.end(err, fi, old) }()
+
+	qr := &quotaReader{rs: rs, r: r}
+
+	fi, err = save(qr)
+	if qr.err != nil {
+		err = qr.err
+	}
+	return
+}
+
+func (qfs *QuotaFS) CopyFile(src, dst string, tag ...string) (err error) {
+	f, err := qfs.XFS.FindFile(src)
+	if err != nil {
+		return err
+	}
+
+	old, err := qfs.findFile(dst)
+	if err != nil {
+		return err
+	}
+
+	saved := &File{ID: dst, Tag: asg.First(tag, f.Tag), Size: f.Size}
+
+	rs, err := qfs.begin(saved.ID, saved.Tag, creditOf(old))
+	if err != nil {
+		return err
+	}
+	defer func() { rs.end(err, saved, old) }()
+
+	if err = rs.reserve(f.Size); err != nil {
+		return
+	}
+	return qfs.XFS.CopyFile(src, dst, tag...)
+}
+
+func (qfs *QuotaFS) MoveFile(src, dst string, tag ...string) (err error) {
+	f, err := qfs.XFS.FindFile(src)
+	if err != nil {
+		return err
+	}
+
+	var old *File
+	if dst != src {
+		if old, err = qfs.findFile(dst); err != nil {
+			return err
+		}
+	}
+
+	saved := &File{ID: dst, Tag: asg.First(tag, f.Tag), Size: f.Size}
+
+	rs, err := qfs.begin(saved.ID, saved.Tag, creditOf(f, old))
+	if err != nil {
+		return err
+	}
+	defer func() { rs.end(err, saved, f, old) }()
+
+	if err = rs.reserve(f.Size); err != nil {
+		return
+	}
+	return qfs.XFS.MoveFile(src, dst, tag...)
+}
+
+func (qfs *QuotaFS) DeleteFile(id string) error {
+	defer qfs.reload()
+	return qfs.XFS.DeleteFile(id)
+}
+
+func (qfs *QuotaFS) DeleteFiles(ids ...string) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteFiles(ids...)
+}
+
+func (qfs *QuotaFS) DeletePrefix(prefix string) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeletePrefix(prefix)
+}
+
+func (qfs *QuotaFS) DeleteTagged(tag string) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteTagged(tag)
+}
+
+func (qfs *QuotaFS) DeleteBefore(before time.Time) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteBefore(before)
+}
+
+func (qfs *QuotaFS) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeletePrefixBefore(prefix, before)
+}
+
+func (qfs *QuotaFS) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteTaggedBefore(tag, before)
+}
+
+func (qfs *QuotaFS) DeleteWhere(where string, args ...any) (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteWhere(where, args...)
+}
+
+func (qfs *QuotaFS) DeleteAll() (int64, error) {
+	defer qfs.reload()
+	return qfs.XFS.DeleteAll()
+}
+
+func (qfs *QuotaFS) Truncate() error {
+	defer qfs.reload()
+	return qfs.XFS.Truncate()
+}
+
+// quotaReader reserves the quotas for the read data
+type quotaReader struct {
+	rs   *reservation
+	r    io.Reader
+	read int64 // read size
+	err  error // quota error
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	if qr.err != nil {
+		return 0, qr.err
+	}
+
+	n, err := qr.r.Read(p)
+	if n > 0 {
+		qr.read += int64(n)
+		if qerr := qr.rs.reserve(qr.read); qerr != nil {
+			qr.err = qerr
+			return 0, qerr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,223 @@
+package xfs_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+func TestQuotaFS(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+	if _, err := dfs.SaveFile("/t1/a.txt", "a.txt", time.Now(), []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+
+	qfs := xfs.NewQuotaFS(dfs, &xfs.Quota{Prefix: "/t1/", Limit: 10}, &xfs.Quota{Tag: "img", Limit: 8})
+
+	usage := func() []int64 {
+		qus, err := qfs.Usages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		us := make([]int64, len(qus))
+		for i, qu := range qus {
+			us[i] = qu.Usage
+		}
+		return us
+	}
+	check := func(name string, want ...int64) {
+		t.Helper()
+		us := usage()
+		for i, w := range want {
+			if us[i] != w {
+				t.Fatalf("%s: Usages() = %v, want %v", name, us, want)
+			}
+		}
+	}
+
+	check("init", 5, 0)
+
+	if _, err := qfs.SaveFile("/t1/b.txt", "b.txt", time.Now(), []byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+	check("save", 9, 0)
+
+	_, err := qfs.SaveFileReader("/t1/c.txt", "c.txt", time.Now(), bytes.NewReader([]byte("12")))
+	if !errors.Is(err, xfs.ErrQuotaExceeded) {
+		t.Fatalf("SaveFileReader() = %v, want ErrQuotaExceeded", err)
+	}
+	check("exceed", 9, 0)
+
+	// overwrite with the same size
+	if _, err := qfs.SaveFile("/t1/a.txt", "a.txt", time.Now(), []byte("abcde")); err != nil {
+		t.Fatal(err)
+	}
+	check("overwrite", 9, 0)
+
+	if err := qfs.CopyFile("/t1/b.txt", "/t2/b.png", "img"); err != nil {
+		t.Fatal(err)
+	}
+	check("copy", 9, 4)
+
+	err = qfs.CopyFile("/t1/a.txt", "/t2/a.png", "img")
+	var qee *xfs.QuotaExceededError
+	if !errors.As(err, &qee) || qee.Quota.Limit != 8 || qee.Usage != 4 {
+		t.Fatalf("CopyFile() = %v, want QuotaExceededError", err)
+	}
+
+	if err := qfs.MoveFile("/t1/a.txt", "/t2/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	check("move", 4, 4)
+
+	if err := qfs.DeleteFile("/t2/b.png"); err != nil {
+		t.Fatal(err)
+	}
+	check("delete", 4, 0)
+
+	if _, err := qfs.DeletePrefix("/t1/"); err != nil {
+		t.Fatal(err)
+	}
+	check("delete prefix", 0, 0)
+}
+
+func TestQuotaFSShared(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+
+	q := &xfs.Quota{Prefix: "/t/", Limit: 10}
+	qfs1 := xfs.NewQuotaFS(dfs, q)
+	qfs2 := xfs.NewQuotaFS(dfs, q)
+
+	// the usage saved by the other QuotaFS is counted
+	if _, err := qfs1.SaveFile("/t/a.txt", "a.txt", time.Now(), []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := qfs2.SaveFile("/t/b.txt", "b.txt", time.Now(), []byte("123")); !errors.Is(err, xfs.ErrQuotaExceeded) {
+		t.Fatalf("SaveFile() = %v, want ErrQuotaExceeded", err)
+	}
+
+	// the failed overwrite with the other tag keeps the usage
+	tq := &xfs.Quota{Tag: "img", Limit: 4}
+	qfs1.SetQuotas(q, tq)
+	if _, err := qfs1.SaveFile("/t/a.txt", "a.png", time.Now(), []byte("12345"), "img"); !errors.Is(err, xfs.ErrQuotaExceeded) {
+		t.Fatalf("SaveFile() = %v, want ErrQuotaExceeded", err)
+	}
+	qus, err := qfs1.Usages()
+	if err != nil || qus[0].Usage != 8 || qus[1].Usage != 0 {
+		t.Fatalf("Usages() = %v, %v, want 8, 0", qus, err)
+	}
+}
+
+func TestQuotaFSInflight(t *testing.T) {
+	qfs := xfs.NewQuotaFS(dirxfs.FS(t.TempDir()), &xfs.Quota{Limit: 10})
+
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := qfs.SaveFileReader("/a.txt", "a.txt", time.Now(), pr)
+		done <- err
+	}()
+
+	// the in-flight 6 bytes are reserved after the next write is read
+	for _, s := range []string{"123456", "7"} {
+		if _, err := pw.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := qfs.SaveFile("/b.txt", "b.txt", time.Now(), []byte("12345")); !errors.Is(err, xfs.ErrQuotaExceeded) {
+		t.Fatalf("SaveFile() = %v, want ErrQuotaExceeded", err)
+	}
+
+	// the reservation is released on failure
+	pw.CloseWithError(errors.New("abort"))
+	if err := <-done; err == nil {
+		t.Fatal("SaveFileReader() = nil, want error")
+	}
+	if _, err := qfs.SaveFile("/b.txt", "b.txt", time.Now(), []byte("1234567890")); err != nil {
+		t.Fatalf("SaveFile() = %v", err)
+	}
+}
+
+// sumFS counts the SumSize calls
+type sumFS struct {
+	xfs.XFS
+	sums atomic.Int32
+}
+
+func (sfs *sumFS) SumSize(fq *xfs.FileQuery) (int64, error) {
+	sfs.sums.Add(1)
+	return sfs.XFS.SumSize(fq)
+}
+
+func (sfs *sumFS) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	return sfs.XFS.(xfs.EncodedSaver).SaveEncodedFile(id, filename, filetime, r, enc, tag...)
+}
+
+func TestQuotaFSUsageCounter(t *testing.T) {
+	sfs := &sumFS{XFS: dirxfs.FS(t.TempDir())}
+	qfs := xfs.NewQuotaFS(sfs, &xfs.Quota{Limit: 10})
+
+	for _, id := range []string{"/a.txt", "/b.txt", "/a.txt"} {
+		if _, err := qfs.SaveFile(id, "x.txt", time.Now(), []byte("123")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := qfs.CopyFile("/a.txt", "/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	enc := &xfs.Encoding{Codec: "gzip", RawSize: 5}
+	if _, err := qfs.SaveEncodedFile("/d.txt", "d.txt", time.Now(), strings.NewReader("12"), enc); !errors.Is(err, xfs.ErrQuotaExceeded) {
+		t.Fatalf("SaveEncodedFile() = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := qfs.SaveEncodedFile("/d.txt", "d.txt", time.Now(), strings.NewReader("1"), enc); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := sfs.sums.Load(); n != 1 {
+		t.Errorf("SumSize() called %d times, want 1", n)
+	}
+
+	qus, err := qfs.Usages()
+	if err != nil || qus[0].Usage != 10 {
+		t.Fatalf("Usages() = %v, %v, want 10", qus, err)
+	}
+
+	// the usage is reloaded after delete
+	if err := qfs.DeleteFile("/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	qus, err = qfs.Usages()
+	if err != nil || qus[0].Usage != 7 || sfs.sums.Load() != 2 {
+		t.Fatalf("Usages() = %v, %v, want 7 by SumSize", qus, err)
+	}
+}
+
+func TestQuotaFSConcurrent(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+	qfs := xfs.NewQuotaFS(dfs, &xfs.Quota{Limit: 50})
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = qfs.SaveFile(fmt.Sprintf("/%d.txt", i), "x.txt", time.Now(), []byte("1234567890"))
+		}()
+	}
+	wg.Wait()
+
+	size, err := dfs.SumSize(&xfs.FileQuery{})
+	if err != nil || size != 50 {
+		t.Errorf("SumSize() = %d, %v, want 50", size, err)
+	}
+}
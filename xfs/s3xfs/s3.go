@@ -0,0 +1,343 @@
+package s3xfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/str"
+)
+
+const (
+	amzDateFormat  = "20060102T150405Z"
+	amzAlgorithm   = "AWS4-HMAC-SHA256"
+	amzEmptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	amzUnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Error the error response of the S3 server
+type S3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (se *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s - %s", se.StatusCode, se.Code, se.Message)
+}
+
+// Client a minimal S3 compatible object storage client (path-style, AWS Signature Version 4)
+type Client struct {
+	// Endpoint the S3 endpoint url, for example "http://localhost:9000"
+	Endpoint string
+
+	// Region the region name, default is "us-east-1"
+	Region string
+
+	// Bucket the bucket name
+	Bucket string
+
+	// AccessKey the access key id
+	AccessKey string
+
+	// SecretKey the secret access key
+	SecretKey string
+
+	// Client the http client, default is http.DefaultClient
+	Client *http.Client
+}
+
+func (c *Client) region() string {
+	return str.IfEmpty(c.Region, "us-east-1")
+}
+
+func (c *Client) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+// URL returns the path-style url of the object key
+func (c *Client) URL(key string) string {
+	return str.TrimSuffix(c.Endpoint, "/") + "/" + uriEncode(c.Bucket, false) + "/" + uriEncode(key, true)
+}
+
+// PutObject upload the object
+func (c *Client) PutObject(key string, data []byte, contentType ...string) error {
+	req, err := http.NewRequest(http.MethodPut, c.URL(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if ct := asg.First(contentType); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+
+	res, err := c.do(req, payloadHash(data))
+	if err != nil {
+		return err
+	}
+	return drain(res)
+}
+
+// PutObjectReader upload the object with the data read from r, size is the length of the data.
+// The payload is not signed ("UNSIGNED-PAYLOAD").
+func (c *Client) PutObjectReader(key string, r io.Reader, size int64, contentType ...string) error {
+	req, err := http.NewRequest(http.MethodPut, c.URL(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	if ct := asg.First(contentType); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+
+	res, err := c.do(req, amzUnsignedPayload)
+	if err != nil {
+		return err
+	}
+	return drain(res)
+}
+
+// GetObject download the object, the caller should close the returned reader.
+// Returns fs.ErrNotExist if the object does not exist.
+func (c *Client) GetObject(key string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(req, amzEmptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// GetObjectRange download the `n` bytes of the object from the offset `off`, the caller should close the returned reader.
+func (c *Client) GetObjectRange(key string, off, n int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.URL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+
+	res, err := c.do(req, amzEmptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// CopyObject copy the object src to dst in the bucket.
+// The S3 server may return "200 OK" with an error body if the copy fails after the response started,
+// so the response body is checked.
+func (c *Client) CopyObject(src, dst string) error {
+	req, err := http.NewRequest(http.MethodPut, c.URL(dst), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", "/"+uriEncode(c.Bucket, false)+"/"+uriEncode(src, true))
+
+	res, err := c.do(req, amzEmptySHA256)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	bs, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var er struct {
+		XMLName xml.Name
+		S3Error
+	}
+	if xml.Unmarshal(bs, &er) == nil && er.XMLName.Local == "Error" {
+		er.S3Error.StatusCode = res.StatusCode
+		return &er.S3Error
+	}
+	return nil
+}
+
+// DeleteObject delete the object, no error is returned if the object does not exist
+func (c *Client) DeleteObject(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, c.URL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(req, amzEmptySHA256)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return drain(res)
+}
+
+func payloadHash(body []byte) string {
+	if len(body) > 0 {
+		return sha256Hex(body)
+	}
+	return amzEmptySHA256
+}
+
+func (c *Client) do(req *http.Request, ph string) (*http.Response, error) {
+	SignV4(req, ph, c.AccessKey, c.SecretKey, c.region(), time.Now())
+
+	res, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fs.ErrNotExist
+	}
+
+	se := &S3Error{StatusCode: res.StatusCode}
+	bs, _ := io.ReadAll(res.Body)
+	_ = xml.Unmarshal(bs, se)
+	return nil, se
+}
+
+func drain(res *http.Response) error {
+	defer res.Body.Close()
+
+	_, err := io.Copy(io.Discard, res.Body)
+	return err
+}
+
+// SignV4 sign the S3 request with AWS Signature Version 4.
+// payloadHash is the hex encoded SHA256 of the request body, or "UNSIGNED-PAYLOAD".
+func SignV4(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format(amzDateFormat)
+	scope := t.Format("20060102") + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// canonical headers
+	hs := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			hs[lk] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+
+	names := make([]string, 0, len(hs))
+	for k := range hs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var chs strings.Builder
+	for _, k := range names {
+		chs.WriteString(k)
+		chs.WriteByte(':')
+		chs.WriteString(hs[k])
+		chs.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		chs.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	sts := strings.Join([]string{
+		amzAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex(str.UnsafeBytes(creq)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, sts))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		amzAlgorithm, accessKey, scope, signedHeaders, sig,
+	))
+}
+
+func canonicalQuery(q url.Values) string {
+	ks := make([]string, 0, len(q))
+	for k := range q {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+
+	var qs []string
+	for _, k := range ks {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			qs = append(qs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(qs, "&")
+}
+
+// uriEncode encode the string as the AWS UriEncode() function
+func uriEncode(s string, path bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(str.UnsafeBytes(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,675 @@
+package s3xfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/sqx"
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/sqlxfs"
+)
+
+// DeleteBatchSize the maximum count of the files to delete in one "DELETE ... WHERE id IN (...)" statement
+var DeleteBatchSize = 1000
+
+// s3fs implements xfs.XFS interface
+type s3fs struct {
+	db sqlx.Sqlx
+	tb string // file table
+	s3 *Client
+	tc *sqlxfs.TableColumns
+}
+
+// OptionalColumns the optional columns of the file table.
+// The "hash" column stores the SHA-256 hash of the file data (File.Hash), it is empty if the column does not exist.
+// The "mime" column stores the detected MIME type (File.MIME), it is empty if the column does not exist.
+// The "codec" and "raw_size" columns are required to save the encoded files (see xfs.EncodedSaver).
+// If the file table has the "deleted_at" column (the sqlxfs TrashFS table), the deleted files are ignored.
+var OptionalColumns = []string{"hash", "mime", "codec", "raw_size"}
+
+// FS create a xfs.XFS which stores the file data in the S3 bucket,
+// and the file metadata in the sqlxfs file table (the data column is left empty).
+// The object key of a file is the file id without the leading "/".
+func FS(db sqlx.Sqlx, table string, s3 *Client) xfs.XFS {
+	return &s3fs{db: db, tb: table, s3: s3, tc: &sqlxfs.TableColumns{}}
+}
+
+// optionals returns the OptionalColumns which exist in the file table
+func (s3fs *s3fs) optionals() ([]string, error) {
+	return s3fs.tc.Existing(s3fs.db, s3fs.tb, OptionalColumns...)
+}
+
+// alive add the condition of the not deleted files if the file table has the "deleted_at" column
+func (s3fs *s3fs) alive(sqb *sqlx.Builder) error {
+	dcs, err := s3fs.tc.Existing(s3fs.db, s3fs.tb, "deleted_at")
+	if err != nil {
+		return err
+	}
+
+	if len(dcs) > 0 {
+		sqb.Where("deleted_at IS NULL")
+	}
+	return nil
+}
+
+// columns returns the columns of the file without data
+func (s3fs *s3fs) columns() ([]string, error) {
+	ocs, err := s3fs.optionals()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := []string{"id", "name", "ext", "tag", "size", "time"}
+	return append(cols, ocs...), nil
+}
+
+func objectKey(id string) string {
+	return str.TrimPrefix(id, "/")
+}
+
+func (s3fs *s3fs) Open(name string) (fs.File, error) {
+	return xfs.OpenFile(s3fs, name)
+}
+
+func (s3fs *s3fs) ReadDir(name string) ([]fs.DirEntry, error) {
+	return xfs.ReadDir(s3fs, name)
+}
+
+func (s3fs *s3fs) Stat(name string) (fs.FileInfo, error) {
+	return xfs.Stat(s3fs, name)
+}
+
+func (s3fs *s3fs) Glob(pattern string) ([]string, error) {
+	return xfs.Glob(s3fs, pattern)
+}
+
+// FindFile find a file
+func (s3fs *s3fs) FindFile(id string) (*xfs.File, error) {
+	cols, err := s3fs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(s3fs.tb).Where("id = ?", id)
+	if err := s3fs.alive(sqb); err != nil {
+		return nil, err
+	}
+	sql, args := sqb.Build()
+
+	f := &xfs.File{}
+	if err := s3fs.db.Get(f, sql, args...); err != nil {
+		if errors.Is(err, sqlx.ErrNoRows) {
+			return nil, fs.ErrNotExist
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (s3fs *s3fs) ListPrefix(prefix string) ([]*xfs.File, error) {
+	cols, err := s3fs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(s3fs.tb).Where("id LIKE ?", sqx.StartsLike(prefix))
+	if err := s3fs.alive(sqb); err != nil {
+		return nil, err
+	}
+	sqb.Order("id")
+	sql, args := sqb.Build()
+
+	var files []*xfs.File
+	err = s3fs.db.Select(&files, sql, args...)
+	return files, err
+}
+
+// ListDir list the files directly under the directory prefix (ends with "/") ordered by id,
+// and the names of the sub directories with the latest time of the files in them.
+func (s3fs *s3fs) ListDir(prefix string) ([]*xfs.File, map[string]time.Time, error) {
+	like := sqx.StartsLike(prefix)
+
+	cols, err := s3fs.columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(s3fs.tb)
+	sqb.Like("id", like)
+	sqb.NotLike("id", like+"/%")
+	if err := s3fs.alive(sqb); err != nil {
+		return nil, nil, err
+	}
+	sqb.Order("id")
+	sql, args := sqb.Build()
+
+	var files []*xfs.File
+	if err := s3fs.db.Select(&files, sql, args...); err != nil {
+		return nil, nil, err
+	}
+
+	// the sub directory name is the part of the id between the prefix and the next "/"
+	rest := fmt.Sprintf("SUBSTR(id, %d)", utf8.RuneCountInString(prefix)+1)
+	name := fmt.Sprintf("SUBSTR(%s, 1, %s - 1)", rest, sqlxfs.Strpos(s3fs.db, rest, "'/'"))
+
+	sqb = s3fs.db.Builder()
+	sqb.Select(name+" AS name", "MAX(time) AS time")
+	sqb.From(s3fs.tb)
+	sqb.Like("id", like+"/%")
+	if err := s3fs.alive(sqb); err != nil {
+		return nil, nil, err
+	}
+	sql, args = sqb.Build()
+	sql += " GROUP BY " + name
+
+	var sds []*sqlxfs.SubDir
+	if err := s3fs.db.Select(&sds, sql, args...); err != nil {
+		return nil, nil, err
+	}
+
+	dirs := make(map[string]time.Time, len(sds))
+	for _, sd := range sds {
+		dirs[sd.Name] = sd.Time.Time
+	}
+	return files, dirs, nil
+}
+
+func (s3fs *s3fs) addQuery(sqb *sqlx.Builder, fq *xfs.FileQuery) error {
+	if fq.LastID != "" {
+		sqb.Where("id > ?", fq.LastID)
+	}
+	if fq.Prefix != "" {
+		sqb.Like("id", sqx.StartsLike(fq.Prefix))
+	}
+	if fq.Tag != "" {
+		sqb.Eq("tag", fq.Tag)
+	}
+	if len(fq.Exts) > 0 {
+		sqb.In("ext", fq.Exts)
+	}
+	if !fq.TimeMin.IsZero() {
+		sqb.Gte("time", fq.TimeMin)
+	}
+	if !fq.TimeMax.IsZero() {
+		sqb.Lte("time", fq.TimeMax)
+	}
+	return s3fs.alive(sqb)
+}
+
+func (s3fs *s3fs) CountFiles(fq *xfs.FileQuery) (total int, err error) {
+	sqb := s3fs.db.Builder()
+	sqb.Count()
+	sqb.From(s3fs.tb)
+	if err = s3fs.addQuery(sqb, fq); err != nil {
+		return
+	}
+	sql, args := sqb.Build()
+
+	err = s3fs.db.Get(&total, sql, args...)
+	return
+}
+
+func (s3fs *s3fs) FindFiles(fq *xfs.FileQuery) (files []*xfs.File, err error) {
+	cols, err := s3fs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(s3fs.tb)
+	if err = s3fs.addQuery(sqb, fq); err != nil {
+		return
+	}
+
+	sqb.Orders(fq.Order, "id")
+	sqb.Offset(fq.Start()).Limit(fq.Limit)
+
+	sql, args := sqb.Build()
+
+	err = s3fs.db.Select(&files, sql, args...)
+	return
+}
+
+func (s3fs *s3fs) SumSize(fq *xfs.FileQuery) (size int64, err error) {
+	sqb := s3fs.db.Builder()
+	sqb.Select("COALESCE(SUM(size), 0)")
+	sqb.From(s3fs.tb)
+	if err = s3fs.addQuery(sqb, fq); err != nil {
+		return
+	}
+	sql, args := sqb.Build()
+
+	err = s3fs.db.Get(&size, sql, args...)
+	return
+}
+
+func (s3fs *s3fs) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*xfs.File, error) {
+	fi := newFile(id, filename, filetime, tag...)
+	fi.Size = int64(len(data))
+	fi.Hash = xfs.HashData(data)
+	fi.MIME = xfs.DetectMIME(data, fi.Ext)
+	fi.Data = data
+
+	if err := s3fs.s3.PutObject(objectKey(id), data); err != nil {
+		return fi, err
+	}
+
+	return fi, s3fs.saveMeta(fi)
+}
+
+// SaveFileReader save a file with the data read from the reader.
+// The data is spooled to a temporary file to get the content length before uploading.
+func (s3fs *s3fs) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*xfs.File, error) {
+	return s3fs.saveReader(newFile(id, filename, filetime, tag...), r, nil)
+}
+
+// SaveEncodedFile save a file with the encoded data read from the reader and the encoding metadata.
+// The file table must have the "codec" and "raw_size" columns, see OptionalColumns.
+func (s3fs *s3fs) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	ocs, err := s3fs.optionals()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ocs, "codec") || !slices.Contains(ocs, "raw_size") {
+		return nil, fmt.Errorf("s3xfs: no codec and raw_size columns in the file table %q: %w", s3fs.tb, errors.ErrUnsupported)
+	}
+
+	return s3fs.saveReader(newFile(id, filename, filetime, tag...), r, enc)
+}
+
+func (s3fs *s3fs) saveReader(fi *xfs.File, r io.Reader, enc *xfs.Encoding) (*xfs.File, error) {
+
+	tf, err := os.CreateTemp("", "s3xfs-*")
+	if err != nil {
+		return fi, err
+	}
+	defer func() {
+		tf.Close()
+		os.Remove(tf.Name())
+	}()
+
+	hr := xfs.NewHashReader(r)
+	if _, err := io.Copy(tf, hr); err != nil {
+		return fi, err
+	}
+	fi.Size, fi.Hash, fi.MIME = hr.Size(), hr.Hash(), hr.MIME(fi.Ext)
+	if enc != nil {
+		fi.Codec, fi.RawSize, fi.MIME = enc.Codec, enc.RawSize, enc.MIME
+	}
+
+	if _, err := tf.Seek(0, io.SeekStart); err != nil {
+		return fi, err
+	}
+
+	if err := s3fs.s3.PutObjectReader(objectKey(fi.ID), tf, fi.Size); err != nil {
+		return fi, err
+	}
+
+	return fi, s3fs.saveMeta(fi)
+}
+
+func newFile(id string, filename string, filetime time.Time, tag ...string) *xfs.File {
+	return &xfs.File{
+		ID:   id,
+		Name: filepath.Base(filename),
+		Ext:  str.ToLower(filepath.Ext(filename)),
+		Tag:  asg.First(tag),
+		Time: filetime,
+	}
+}
+
+// setOptionals set the optional columns of the file
+func setOptionals(sqb *sqlx.Builder, ocs []string, fi *xfs.File) {
+	for _, c := range ocs {
+		switch c {
+		case "hash":
+			sqb.Setc(c, fi.Hash)
+		case "mime":
+			sqb.Setc(c, fi.MIME)
+		case "codec":
+			sqb.Setc(c, fi.Codec)
+		case "raw_size":
+			sqb.Setc(c, fi.RawSize)
+		}
+	}
+}
+
+// saveMeta insert or update the file record, the deleted file record of the same id is overwritten
+func (s3fs *s3fs) saveMeta(fi *xfs.File) error {
+	ocs, err := s3fs.optionals()
+	if err != nil {
+		return err
+	}
+
+	dcs, err := s3fs.tc.Existing(s3fs.db, s3fs.tb, "deleted_at")
+	if err != nil {
+		return err
+	}
+
+	var cnt int
+	if err := s3fs.db.Get(&cnt, s3fs.db.Rebind("SELECT COUNT(*) FROM "+s3fs.db.Quote(s3fs.tb)+" WHERE id = ?"), fi.ID); err != nil {
+		return err
+	}
+
+	sqb := s3fs.db.Builder()
+	if cnt > 0 {
+		sqb.Update(s3fs.tb)
+		sqb.Setc("name", fi.Name)
+		sqb.Setc("ext", fi.Ext)
+		sqb.Setc("tag", fi.Tag)
+		sqb.Setc("size", fi.Size)
+		sqb.Setc("time", fi.Time)
+		setOptionals(sqb, ocs, fi)
+		for _, c := range dcs {
+			sqb.Setc(c, nil)
+		}
+		sqb.Where("id = ?", fi.ID)
+	} else {
+		sqb.Insert(s3fs.tb)
+		sqb.Setc("id", fi.ID)
+		sqb.Setc("name", fi.Name)
+		sqb.Setc("ext", fi.Ext)
+		sqb.Setc("tag", fi.Tag)
+		sqb.Setc("size", fi.Size)
+		sqb.Setc("time", fi.Time)
+		sqb.Setc("data", []byte{})
+		setOptionals(sqb, ocs, fi)
+	}
+	sql, args := sqb.Build()
+
+	n, err := s3fs.db.Update(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s3fs *s3fs) OpenReader(id string) (io.ReadCloser, error) {
+	return s3fs.s3.GetObject(objectKey(id))
+}
+
+func (s3fs *s3fs) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	r, err := s3fs.s3.GetObjectRange(objectKey(id), off, int64(len(p)))
+	if err != nil {
+		var se *S3Error
+		if errors.As(err, &se) && se.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	defer r.Close()
+
+	n, err := io.ReadFull(r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// ReadFile read the file data, the name can be a file id or a fs.FS path name
+func (s3fs *s3fs) ReadFile(name string) ([]byte, error) {
+	id := xfs.FileID(name)
+
+	r, err := s3fs.OpenReader(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// CopyFile copy the file, the existing dst file is overwritten
+func (s3fs *s3fs) CopyFile(src, dst string, tag ...string) error {
+	if _, err := s3fs.FindFile(src); err != nil {
+		return err
+	}
+
+	if src == dst {
+		return s3fs.retag(src, tag...)
+	}
+
+	if err := s3fs.s3.CopyObject(objectKey(src), objectKey(dst)); err != nil {
+		return err
+	}
+
+	if err := s3fs.deleteMeta(dst); err != nil {
+		return err
+	}
+
+	ocs, err := s3fs.optionals()
+	if err != nil {
+		return err
+	}
+
+	tb := s3fs.db.Quote(s3fs.tb)
+	cols := strings.Join(append([]string{"time", "size", "data"}, ocs...), ", ")
+
+	var args []any
+
+	sql := fmt.Sprintf("INSERT INTO %s (id, name, ext, tag, %s) ", tb, cols)
+	if len(tag) == 0 {
+		sql += fmt.Sprintf("SELECT ?, name, ext, tag, %s FROM %s WHERE id = ?", cols, tb)
+		args = append(args, dst, src)
+	} else {
+		sql += fmt.Sprintf("SELECT ? AS id, name, ext, ? AS tag, %s FROM %s WHERE id = ?", cols, tb)
+		args = append(args, dst, tag[0], src)
+	}
+	sql = s3fs.db.Rebind(sql)
+
+	cnt, err := s3fs.db.Update(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if cnt == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// MoveFile move the file, the existing dst file is overwritten
+func (s3fs *s3fs) MoveFile(src, dst string, tag ...string) error {
+	if _, err := s3fs.FindFile(src); err != nil {
+		return err
+	}
+
+	if src == dst {
+		return s3fs.retag(src, tag...)
+	}
+
+	if err := s3fs.s3.CopyObject(objectKey(src), objectKey(dst)); err != nil {
+		return err
+	}
+
+	if err := s3fs.deleteMeta(dst); err != nil {
+		return err
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Update(s3fs.tb)
+	sqb.Setc("id", dst)
+	if len(tag) > 0 {
+		sqb.Setc("tag", tag[0])
+	}
+	sqb.Where("id = ?", src)
+	sql, args := sqb.Build()
+
+	cnt, err := s3fs.db.Update(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if cnt == 0 {
+		return fs.ErrNotExist
+	}
+
+	return s3fs.s3.DeleteObject(objectKey(src))
+}
+
+// retag update the tag of the file for CopyFile/MoveFile to the same id
+func (s3fs *s3fs) retag(id string, tag ...string) error {
+	if len(tag) == 0 {
+		return nil
+	}
+
+	sqb := s3fs.db.Builder()
+	sqb.Update(s3fs.tb)
+	sqb.Setc("tag", tag[0])
+	sqb.Where("id = ?", id)
+	sql, args := sqb.Build()
+
+	_, err := s3fs.db.Exec(sql, args...)
+	return err
+}
+
+func (s3fs *s3fs) deleteMeta(id string) error {
+	sqb := s3fs.db.Builder()
+	sqb.Delete(s3fs.tb).Where("id = ?", id)
+	sql, args := sqb.Build()
+
+	_, err := s3fs.db.Exec(sql, args...)
+	return err
+}
+
+func (s3fs *s3fs) DeleteFile(id string) error {
+	if err := s3fs.deleteMeta(id); err != nil {
+		return err
+	}
+
+	return s3fs.s3.DeleteObject(objectKey(id))
+}
+
+func (s3fs *s3fs) DeleteFiles(ids ...string) (int64, error) {
+	sql, args := sqx.In("id", ids)
+	return s3fs.DeleteWhere(sql, args...)
+}
+
+func (s3fs *s3fs) DeletePrefix(prefix string) (int64, error) {
+	return s3fs.DeleteWhere("id LIKE ?", sqx.StartsLike(prefix))
+}
+
+func (s3fs *s3fs) DeleteTagged(tag string) (int64, error) {
+	return s3fs.DeleteWhere("tag = ?", tag)
+}
+
+func (s3fs *s3fs) DeleteBefore(before time.Time) (int64, error) {
+	return s3fs.DeleteWhere("time < ?", before)
+}
+
+func (s3fs *s3fs) DeletePrefixBefore(prefix string, before time.Time) (int64, error) {
+	return s3fs.DeleteWhere("id LIKE ? AND time < ?", sqx.StartsLike(prefix), before)
+}
+
+func (s3fs *s3fs) DeleteTaggedBefore(tag string, before time.Time) (int64, error) {
+	return s3fs.DeleteWhere("tag = ? AND time < ?", tag, before)
+}
+
+// DeleteWhere delete the file records by the where filter, then delete the objects of the deleted files.
+// The deleted file records (see OptionalColumns) are not matched.
+func (s3fs *s3fs) DeleteWhere(where string, args ...any) (int64, error) {
+	tb := s3fs.db.Quote(s3fs.tb)
+
+	dcs, err := s3fs.tc.Existing(s3fs.db, s3fs.tb, "deleted_at")
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []string
+
+	sql := "SELECT id FROM " + tb
+	if where != "" {
+		sql += " WHERE (" + where + ")"
+		if len(dcs) > 0 {
+			sql += " AND deleted_at IS NULL"
+		}
+	} else if len(dcs) > 0 {
+		sql += " WHERE deleted_at IS NULL"
+	}
+	sql = s3fs.db.Rebind(sql)
+
+	if err := s3fs.db.Select(&ids, sql, args...); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for len(ids) > 0 {
+		n := min(len(ids), DeleteBatchSize)
+
+		sql, args = sqx.In("id", ids[:n])
+		sql = s3fs.db.Rebind("DELETE FROM " + tb + " WHERE " + sql)
+
+		cnt, err := s3fs.db.Update(sql, args...)
+		total += cnt
+		if err != nil {
+			return total, err
+		}
+
+		if err := s3fs.deleteObjects(ids[:n]); err != nil {
+			return total, err
+		}
+		ids = ids[n:]
+	}
+	return total, nil
+}
+
+func (s3fs *s3fs) deleteObjects(ids []string) error {
+	var errs []error
+	for _, id := range ids {
+		if err := s3fs.s3.DeleteObject(objectKey(id)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteAll delete all files
+func (s3fs *s3fs) DeleteAll() (int64, error) {
+	return s3fs.DeleteWhere("")
+}
+
+// Truncate delete all objects and use "TRUNCATE TABLE files" to truncate files
+func (s3fs *s3fs) Truncate() error {
+	var ids []string
+
+	sql := "SELECT id FROM " + s3fs.db.Quote(s3fs.tb)
+	if err := s3fs.db.Select(&ids, sql); err != nil {
+		return err
+	}
+
+	if err := s3fs.deleteObjects(ids); err != nil {
+		return err
+	}
+
+	_, err := s3fs.db.Exec("TRUNCATE TABLE " + s3fs.db.Quote(s3fs.tb))
+	return err
+}
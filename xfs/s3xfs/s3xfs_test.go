@@ -0,0 +1,125 @@
+package s3xfs
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/xfstest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const testFileTableDDL = `CREATE TABLE files (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	ext  TEXT NOT NULL,
+	tag  TEXT NOT NULL DEFAULT '',
+	time TIMESTAMP NOT NULL,
+	size INTEGER NOT NULL,
+	hash TEXT NOT NULL DEFAULT '',
+	mime TEXT NOT NULL DEFAULT '',
+	data BLOB NOT NULL,
+	codec    TEXT NOT NULL DEFAULT '',
+	raw_size INTEGER NOT NULL DEFAULT 0
+)`
+
+func TestS3XFS(t *testing.T) {
+	fs3 := &fakeS3{ak: "ak", sk: "sk", objs: map[string][]byte{}}
+	svr := httptest.NewServer(fs3)
+	defer svr.Close()
+
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	if _, err := db.Exec(testFileTableDDL); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{Endpoint: svr.URL, Bucket: "files", AccessKey: "ak", SecretKey: "sk"}
+
+	bs := DeleteBatchSize
+	DeleteBatchSize = 2
+	defer func() { DeleteBatchSize = bs }()
+
+	sfs := FS(db, "files", c)
+	xfstest.TestXFS(t, sfs)
+
+	// all objects should be deleted with the file records
+	if _, err := sfs.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs3.mu.Lock()
+	defer fs3.mu.Unlock()
+	if len(fs3.objs) != 0 {
+		keys := make([]string, 0, len(fs3.objs))
+		for k := range fs3.objs {
+			keys = append(keys, k)
+		}
+		t.Errorf("objects left: %v", keys)
+	}
+}
+
+func TestS3XFSDeletedAt(t *testing.T) {
+	fs3 := &fakeS3{ak: "ak", sk: "sk", objs: map[string][]byte{}}
+	svr := httptest.NewServer(fs3)
+	defer svr.Close()
+
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	if _, err := db.Exec(strings.Replace(testFileTableDDL, "data BLOB NOT NULL,", "data BLOB NOT NULL,\n\tdeleted_at TIMESTAMP NULL,", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Client{Endpoint: svr.URL, Bucket: "files", AccessKey: "ak", SecretKey: "sk"}
+	sfs := FS(db, "files", c)
+
+	for _, id := range []string{"/a/1.txt", "/a/2.txt"} {
+		if _, err := sfs.SaveFile(id, "x.txt", time.Now(), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("UPDATE files SET deleted_at = ? WHERE id = ?", time.Now(), "/a/2.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sfs.FindFile("/a/2.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FindFile(deleted) = %v, want %v", err, fs.ErrNotExist)
+	}
+	if files, err := sfs.ListPrefix("/a/"); err != nil || len(files) != 1 {
+		t.Errorf("ListPrefix() = %d, %v, want 1", len(files), err)
+	}
+	if n, err := sfs.CountFiles(&xfs.FileQuery{Prefix: "/a/"}); err != nil || n != 1 {
+		t.Errorf("CountFiles() = %d, %v, want 1", n, err)
+	}
+	if _, dirs, err := sfs.(xfs.DirLister).ListDir("/"); err != nil || len(dirs) != 1 {
+		t.Errorf("ListDir() = %v, %v, want 1 dir", dirs, err)
+	}
+	if n, err := sfs.DeletePrefix("/a/"); err != nil || n != 1 {
+		t.Errorf("DeletePrefix() = %d, %v, want 1", n, err)
+	}
+
+	// save the file of the deleted id
+	if _, err := sfs.SaveFile("/a/2.txt", "y.txt", time.Now(), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := sfs.FindFile("/a/2.txt"); err != nil || f.Name != "y.txt" || f.DeletedAt != nil {
+		t.Errorf("FindFile(saved) = %v, %v", f, err)
+	}
+}
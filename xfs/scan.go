@@ -0,0 +1,212 @@
+package xfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrInfected indicates the scanned file is infected
+	ErrInfected = errors.New("xfs: file infected")
+
+	// ErrScanWrapped indicates the ScannedSaver is wrapped by a XFS wrapper,
+	// so the file can not be scanned by SaveUploadedFile(), SaveLocalFile() and SaveFileReadSeeker()
+	ErrScanWrapped = errors.New("xfs: the scanning XFS is not the outermost wrapper")
+)
+
+// InfectedError the error of the infected file, it unwraps to ErrInfected
+type InfectedError struct {
+	ID        string
+	Signature string
+}
+
+func (ie *InfectedError) Error() string {
+	return ErrInfected.Error() + ": " + ie.ID + " (" + ie.Signature + ")"
+}
+
+func (ie *InfectedError) Unwrap() error {
+	return ErrInfected
+}
+
+// ScanResult the result of the file scan
+type ScanResult struct {
+	Scanner   string    `json:"scanner"`
+	Infected  bool      `json:"infected"`
+	Signature string    `json:"signature,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Scanner scans the data for the viruses
+type Scanner interface {
+	// Name returns the name of the scanner
+	Name() string
+
+	// Scan scans the data read from r
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+}
+
+// ScannedSaver is implemented by the XFS which scans the data before saving, see ScanFS
+type ScannedSaver interface {
+	// SaveScanned scans the data read from r, then save the data to the file id
+	SaveScanned(ctx context.Context, id string, filename string, filetime time.Time, r io.ReadSeeker, tag ...string) (*File, error)
+}
+
+// saveScanned save the data read from r by SaveScanned() if the xfs is a ScannedSaver, otherwise by SaveFileReader().
+// Returns ErrScanWrapped if a ScannedSaver is found by unwrapping the xfs (see Unwrapper),
+// so the file is not saved without the scan.
+func saveScanned(xfs XFS, id string, filename string, filetime time.Time, r io.ReadSeeker, tag ...string) (*File, error) {
+	if ss, ok := xfs.(ScannedSaver); ok {
+		return ss.SaveScanned(context.Background(), id, filename, filetime, r, tag...)
+	}
+
+	for x := xfs; ; {
+		u, ok := x.(Unwrapper)
+		if !ok {
+			break
+		}
+
+		x = u.Unwrap()
+		if _, ok := x.(ScannedSaver); ok {
+			return nil, fmt.Errorf("%w: %T wraps %T", ErrScanWrapped, xfs, x)
+		}
+	}
+
+	return xfs.SaveFileReader(id, filename, filetime, r, tag...)
+}
+
+const scanResultName = "scan.json"
+
+// ScanFS wraps a XFS to scan the files saved by SaveUploadedFile(), SaveLocalFile() and SaveFileReadSeeker().
+// The infected file is saved with the QuarantineTag and a *InfectedError is returned.
+// The scan result is stored as the derived file "{Prefix}{id}/scan.json" of the Store (see DerivedFS),
+// and can be read by ScanResult().
+// ScanFS should be the outermost wrapper of the XFS, otherwise SaveUploadedFile(), SaveLocalFile() and SaveFileReadSeeker() return ErrScanWrapped.
+type ScanFS struct {
+	DerivedFS
+
+	Scanner Scanner
+
+	// QuarantineTag the tag of the infected files, default "quarantine"
+	QuarantineTag string
+}
+
+// NewScanFS create a ScanFS which stores the scan results in the store
+func NewScanFS(xfs, store XFS, scanner Scanner) *ScanFS {
+	return &ScanFS{
+		DerivedFS:     DerivedFS{XFS: xfs, Store: store, Prefix: "/.scans"},
+		Scanner:       scanner,
+		QuarantineTag: "quarantine",
+	}
+}
+
+func (sfs *ScanFS) resultID(id string) string {
+	return sfs.DerivedDir(id) + scanResultName
+}
+
+// ScanResult read the scan result of the file id
+func (sfs *ScanFS) ScanResult(id string) (*ScanResult, error) {
+	data, err := sfs.Store.ReadFile(sfs.resultID(id))
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &ScanResult{}
+	if err := json.Unmarshal(data, sr); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// SaveScanned scans the data read from r, then save the data to the file id.
+// If the data is infected, the file is saved with the QuarantineTag and a *InfectedError is returned with the saved file.
+func (sfs *ScanFS) SaveScanned(ctx context.Context, id string, filename string, filetime time.Time, r io.ReadSeeker, tag ...string) (*File, error) {
+	sr, err := sfs.Scanner.Scan(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if sr.Scanner == "" {
+		sr.Scanner = sfs.Scanner.Name()
+	}
+	if sr.Time.IsZero() {
+		sr.Time = time.Now()
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if sr.Infected {
+		tag = []string{sfs.QuarantineTag}
+	}
+
+	f, err := sfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+	if err != nil {
+		return f, err
+	}
+
+	data, err := json.Marshal(sr)
+	if err != nil {
+		return f, err
+	}
+	if _, err := sfs.Store.SaveFile(sfs.resultID(id), scanResultName, sr.Time, data); err != nil {
+		return f, err
+	}
+
+	if sr.Infected {
+		return f, &InfectedError{ID: id, Signature: sr.Signature}
+	}
+	return f, nil
+}
+
+// FindQuarantined find the quarantined files by the query
+func (sfs *ScanFS) FindQuarantined(fq *FileQuery) ([]*File, error) {
+	q := *fq
+	q.Tag = sfs.QuarantineTag
+	return sfs.XFS.FindFiles(&q)
+}
+
+func (sfs *ScanFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	f, err := sfs.XFS.SaveFile(id, filename, filetime, data, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, sfs.deleteDerived(id)
+}
+
+func (sfs *ScanFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	f, err := sfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, sfs.deleteDerived(id)
+}
+
+func (sfs *ScanFS) CopyFile(src, dst string, tag ...string) error {
+	if err := sfs.XFS.CopyFile(src, dst, tag...); err != nil {
+		return err
+	}
+	if src == dst {
+		return nil
+	}
+	if err := sfs.deleteDerived(dst); err != nil {
+		return err
+	}
+	return sfs.copyDerived(src, dst)
+}
+
+func (sfs *ScanFS) MoveFile(src, dst string, tag ...string) error {
+	if err := sfs.XFS.MoveFile(src, dst, tag...); err != nil {
+		return err
+	}
+	if src == dst {
+		return nil
+	}
+	if err := sfs.deleteDerived(dst); err != nil {
+		return err
+	}
+	return sfs.moveDerived(src, dst)
+}
@@ -0,0 +1,126 @@
+package xfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+type testScanner struct{}
+
+func (ts testScanner) Name() string {
+	return "test"
+}
+
+func (ts testScanner) Scan(ctx context.Context, r io.Reader) (*xfs.ScanResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &xfs.ScanResult{}
+	if bytes.Contains(data, []byte("EICAR")) {
+		sr.Infected, sr.Signature = true, "Eicar"
+	}
+	return sr, nil
+}
+
+func TestScanFS(t *testing.T) {
+	sfs := xfs.NewScanFS(dirxfs.FS(t.TempDir()), dirxfs.FS(t.TempDir()), testScanner{})
+
+	dir := t.TempDir()
+	clean, infected := filepath.Join(dir, "clean.txt"), filepath.Join(dir, "infected.txt")
+	if err := os.WriteFile(clean, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(infected, []byte("hello EICAR"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := xfs.SaveLocalFile(sfs, "/a/clean.txt", clean, "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Tag != "doc" {
+		t.Errorf("clean tag = %q, want %q", f.Tag, "doc")
+	}
+
+	sr, err := sfs.ScanResult("/a/clean.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Infected || sr.Scanner != "test" {
+		t.Errorf("ScanResult(clean) = %+v", sr)
+	}
+
+	f, err = xfs.SaveLocalFile(sfs, "/a/infected.txt", infected, "doc")
+	if !errors.Is(err, xfs.ErrInfected) {
+		t.Fatalf("SaveLocalFile(infected) = %v, want %v", err, xfs.ErrInfected)
+	}
+	if f.Tag != "quarantine" {
+		t.Errorf("infected tag = %q, want %q", f.Tag, "quarantine")
+	}
+
+	sr, err = sfs.ScanResult("/a/infected.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sr.Infected || sr.Signature != "Eicar" {
+		t.Errorf("ScanResult(infected) = %+v", sr)
+	}
+
+	qfs, err := sfs.FindQuarantined(&xfs.FileQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(qfs) != 1 || qfs[0].ID != "/a/infected.txt" {
+		t.Errorf("FindQuarantined() = %v", qfs)
+	}
+
+	// the scan results are not in the original xfs
+	if files, err := sfs.XFS.ListPrefix("/"); err != nil || len(files) != 2 {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+
+	if err := sfs.MoveFile("/a/clean.txt", "/b/clean.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sfs.ScanResult("/a/clean.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ScanResult(moved) = %v", err)
+	}
+	if sr, err := sfs.ScanResult("/b/clean.txt"); err != nil || sr.Infected {
+		t.Errorf("ScanResult(/b/clean.txt) = %v, %v", sr, err)
+	}
+
+	if err := sfs.DeleteFile("/a/infected.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sfs.ScanResult("/a/infected.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ScanResult(deleted) = %v", err)
+	}
+}
+
+func TestScanFSWrapped(t *testing.T) {
+	sfs := xfs.NewScanFS(dirxfs.FS(t.TempDir()), dirxfs.FS(t.TempDir()), testScanner{})
+	qfs := xfs.NewQuotaFS(sfs)
+
+	local := filepath.Join(t.TempDir(), "infected.txt")
+	if err := os.WriteFile(local, []byte("hello EICAR"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := xfs.SaveLocalFile(qfs, "/a.txt", local); !errors.Is(err, xfs.ErrScanWrapped) {
+		t.Fatalf("SaveLocalFile() = %v, want %v", err, xfs.ErrScanWrapped)
+	}
+	if _, err := sfs.FindFile("/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FindFile() = %v, want %v", err, fs.ErrNotExist)
+	}
+}
@@ -0,0 +1,80 @@
+package xfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSignedURLInvalid indicates the signature of the signed url is invalid
+	ErrSignedURLInvalid = errors.New("xfs: invalid signed url")
+
+	// ErrSignedURLExpired indicates the signed url is expired
+	ErrSignedURLExpired = errors.New("xfs: signed url expired")
+)
+
+// the query parameter names of the signed url
+const (
+	SignedURLExpires  = "expires"
+	SignedURLFilename = "filename"
+	SignedURLSign     = "sign"
+)
+
+// SignedURLSignature returns the hex encoded HMAC-SHA256 of "id\nexpires\nfilename"
+func SignedURLSignature(secret, id string, expires int64, filename string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(filename))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL returns the signed url "{base}{escaped id}?expires=...&filename=...&sign=..." of the file id.
+// The optional filename is used as the Content-Disposition attachment filename.
+func SignURL(secret, base, id string, expires time.Time, filename ...string) string {
+	exp := expires.Unix()
+
+	q := url.Values{}
+	q.Set(SignedURLExpires, strconv.FormatInt(exp, 10))
+
+	fn := ""
+	if len(filename) > 0 && filename[0] != "" {
+		fn = filename[0]
+		q.Set(SignedURLFilename, fn)
+	}
+	q.Set(SignedURLSign, SignedURLSignature(secret, id, exp, fn))
+
+	ps := strings.Split(id, "/")
+	for i, p := range ps {
+		ps[i] = url.PathEscape(p)
+	}
+	return strings.TrimSuffix(base, "/") + strings.Join(ps, "/") + "?" + q.Encode()
+}
+
+// VerifySignedURL verify the query parameters of the signed url of the file id,
+// returns the Content-Disposition attachment filename of the signed url.
+func VerifySignedURL(secret, id string, q url.Values) (string, error) {
+	exp, err := strconv.ParseInt(q.Get(SignedURLExpires), 10, 64)
+	if err != nil {
+		return "", ErrSignedURLInvalid
+	}
+
+	fn := q.Get(SignedURLFilename)
+	sig := SignedURLSignature(secret, id, exp, fn)
+	if !hmac.Equal([]byte(sig), []byte(q.Get(SignedURLSign))) {
+		return "", ErrSignedURLInvalid
+	}
+
+	if time.Now().Unix() > exp {
+		return "", ErrSignedURLExpired
+	}
+	return fn, nil
+}
@@ -0,0 +1,54 @@
+package xfs_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+)
+
+func TestSignURL(t *testing.T) {
+	secret := "secret"
+	id := "/a b/テスト.txt"
+
+	su := xfs.SignURL(secret, "/files/", id, time.Now().Add(time.Minute), "報告.txt")
+	if !strings.HasPrefix(su, "/files/a%20b/%E3%83%86%E3%82%B9%E3%83%88.txt?") {
+		t.Fatalf("SignURL() = %s", su)
+	}
+
+	u, err := url.Parse(su)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Path != "/files"+id {
+		t.Errorf("Path = %q", u.Path)
+	}
+
+	fn, err := xfs.VerifySignedURL(secret, id, u.Query())
+	if err != nil || fn != "報告.txt" {
+		t.Errorf("VerifySignedURL() = %q, %v", fn, err)
+	}
+
+	if _, err := xfs.VerifySignedURL("other", id, u.Query()); !errors.Is(err, xfs.ErrSignedURLInvalid) {
+		t.Errorf("VerifySignedURL(other secret) = %v", err)
+	}
+	if _, err := xfs.VerifySignedURL(secret, "/a b/x.txt", u.Query()); !errors.Is(err, xfs.ErrSignedURLInvalid) {
+		t.Errorf("VerifySignedURL(other id) = %v", err)
+	}
+
+	q := u.Query()
+	q.Set(xfs.SignedURLFilename, "x.txt")
+	if _, err := xfs.VerifySignedURL(secret, id, q); !errors.Is(err, xfs.ErrSignedURLInvalid) {
+		t.Errorf("VerifySignedURL(other filename) = %v", err)
+	}
+
+	su = xfs.SignURL(secret, "/files", id, time.Now().Add(-time.Second))
+	u, _ = url.Parse(su)
+	if _, err := xfs.VerifySignedURL(secret, id, u.Query()); !errors.Is(err, xfs.ErrSignedURLExpired) {
+		t.Errorf("VerifySignedURL(expired) = %v", err)
+	}
+}
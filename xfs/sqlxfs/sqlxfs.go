@@ -1,11 +1,18 @@
 package sqlxfs
 
 import (
+	"bytes"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/askasoft/pango/asg"
 	"github.com/askasoft/pango/sqx"
@@ -14,36 +21,120 @@ import (
 	"github.com/askasoft/pangox/xfs"
 )
 
+// ChunkSize the data size of a file chunk
+const ChunkSize = 1 << 20
+
 // sfs implements xfs.XFS interface
 type sfs struct {
 	db sqlx.Sqlx
 	tb string // file table
+	ct string // file chunk table
+	bt string // file blob table
+	tr bool   // soft deletion (trash bin)
+	tc *TableColumns
 }
 
-func FS(db sqlx.Sqlx, table string) xfs.XFS {
-	return &sfs{db, table}
+// OptionalColumns the optional columns of the file table.
+// The "hash" column stores the SHA-256 hash of the file data (File.Hash), it is empty if the column does not exist.
+// The "mime" column stores the detected MIME type (File.MIME), it is empty if the column does not exist.
+// The "codec" and "raw_size" columns are required to save the encoded files (see xfs.EncodedSaver).
+var OptionalColumns = []string{"hash", "mime", "codec", "raw_size"}
+
+// FS create a sqlx file system.
+// chunkTable: the file chunk table to store the file data in chunks of ChunkSize (optional),
+// if chunkTable is specified, the data column of the file table is left empty.
+func FS(db sqlx.Sqlx, table string, chunkTable ...string) xfs.XFS {
+	return &sfs{db: db, tb: table, ct: asg.First(chunkTable), tc: &TableColumns{}}
 }
 
-func (sfs *sfs) Open(name string) (fs.File, error) {
-	f, err := sfs.FindFile(name)
+// DedupFS create a sqlx file system which deduplicates the file data by the SHA-256 hash.
+// The files with the same hash reference a blob of the blobTable, the data of the blob is stored
+// in the chunkTable with the hash as the fid, and the blob is deleted when no file references it.
+// The file table must have the "hash" column, see OptionalColumns.
+// The reference counts are updated in a transaction if db is a *sqlx.DB.
+func DedupFS(db sqlx.Sqlx, table, chunkTable, blobTable string) xfs.XFS {
+	return &sfs{db: db, tb: table, ct: chunkTable, bt: blobTable, tc: &TableColumns{}}
+}
+
+// TrashFS create a sqlx file system with the soft deletion.
+// The Delete* methods set the "deleted_at" column of the files instead of deleting them,
+// the deleted files can be listed by ListTrash(), restored by Restore(), and permanently deleted by Purge*().
+// See FS() for the chunkTable argument.
+func TrashFS(db sqlx.Sqlx, table string, chunkTable ...string) xfs.TrashFS {
+	return &tsfs{sfs{db: db, tb: table, ct: asg.First(chunkTable), tr: true, tc: &TableColumns{}}}
+}
+
+// DedupTrashFS create a deduplicated sqlx file system with the soft deletion.
+// See DedupFS() and TrashFS() for details.
+func DedupTrashFS(db sqlx.Sqlx, table, chunkTable, blobTable string) xfs.TrashFS {
+	return &tsfs{sfs{db: db, tb: table, ct: chunkTable, bt: blobTable, tr: true, tc: &TableColumns{}}}
+}
+
+// optionals returns the OptionalColumns which exist in the file table
+func (sfs *sfs) optionals() ([]string, error) {
+	return sfs.tc.Existing(sfs.db, sfs.tb, OptionalColumns...)
+}
+
+// columns returns the columns of the file without data
+func (sfs *sfs) columns() ([]string, error) {
+	ocs, err := sfs.optionals()
 	if err != nil {
 		return nil, err
 	}
 
-	hf := &xfs.FSFile{XFS: sfs, File: f}
-	return hf, nil
+	cols := []string{"id", "name", "ext", "tag", "size", "time"}
+	if sfs.tr {
+		cols = append(cols, "deleted_at")
+	}
+	return append(cols, ocs...), nil
+}
+
+// alive add the condition of the not deleted files
+func (sfs *sfs) alive(sqb *sqlx.Builder) {
+	if sfs.tr {
+		sqb.Where("deleted_at IS NULL")
+	}
+}
+
+// aliveWhere returns the where filter of the not deleted files
+func (sfs *sfs) aliveWhere(where string) string {
+	if sfs.tr {
+		return "(" + where + ") AND deleted_at IS NULL"
+	}
+	return where
+}
+
+func (sfs *sfs) Open(name string) (fs.File, error) {
+	return xfs.OpenFile(sfs, name)
+}
+
+func (sfs *sfs) ReadDir(name string) ([]fs.DirEntry, error) {
+	return xfs.ReadDir(sfs, name)
+}
+
+func (sfs *sfs) Stat(name string) (fs.FileInfo, error) {
+	return xfs.Stat(sfs, name)
+}
+
+func (sfs *sfs) Glob(pattern string) ([]string, error) {
+	return xfs.Glob(sfs, pattern)
 }
 
 // FindFile find a file
 func (sfs *sfs) FindFile(id string) (*xfs.File, error) {
+	cols, err := sfs.columns()
+	if err != nil {
+		return nil, err
+	}
+
 	sqb := sfs.db.Builder()
-	sqb.Select("id", "name", "ext", "tag", "size", "time")
+	sqb.Select(cols...)
 	sqb.From(sfs.tb).Where("id = ?", id)
+	sfs.alive(sqb)
 	sql, args := sqb.Build()
 
 	f := &xfs.File{}
-	err := sfs.db.Get(f, sql, args...)
-	if err != nil {
+	if err := sfs.db.Get(f, sql, args...); err != nil {
 		if errors.Is(err, sqlx.ErrNoRows) {
 			return nil, fs.ErrNotExist
 		}
@@ -53,30 +144,432 @@ func (sfs *sfs) FindFile(id string) (*xfs.File, error) {
 	return f, nil
 }
 
+// ListPrefix list the files which id starts with the prefix, ordered by id
+func (sfs *sfs) ListPrefix(prefix string) ([]*xfs.File, error) {
+	cols, err := sfs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := sfs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(sfs.tb).Where("id LIKE ?", sqx.StartsLike(prefix))
+	sfs.alive(sqb)
+	sqb.Order("id")
+	sql, args := sqb.Build()
+
+	var files []*xfs.File
+	err = sfs.db.Select(&files, sql, args...)
+	return files, err
+}
+
+// ListDir list the files directly under the directory prefix (ends with "/") ordered by id,
+// and the names of the sub directories with the latest time of the files in them.
+func (sfs *sfs) ListDir(prefix string) ([]*xfs.File, map[string]time.Time, error) {
+	like := sqx.StartsLike(prefix)
+
+	cols, err := sfs.columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sqb := sfs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(sfs.tb)
+	sqb.Like("id", like)
+	sqb.NotLike("id", like+"/%")
+	sfs.alive(sqb)
+	sqb.Order("id")
+	sql, args := sqb.Build()
+
+	var files []*xfs.File
+	if err := sfs.db.Select(&files, sql, args...); err != nil {
+		return nil, nil, err
+	}
+
+	// the sub directory name is the part of the id between the prefix and the next "/"
+	rest := fmt.Sprintf("SUBSTR(id, %d)", utf8.RuneCountInString(prefix)+1)
+	name := fmt.Sprintf("SUBSTR(%s, 1, %s - 1)", rest, Strpos(sfs.db, rest, "'/'"))
+
+	sqb = sfs.db.Builder()
+	sqb.Select(name+" AS name", "MAX(time) AS time")
+	sqb.From(sfs.tb)
+	sqb.Like("id", like+"/%")
+	sfs.alive(sqb)
+	sql, args = sqb.Build()
+	sql += " GROUP BY " + name
+
+	var sds []*SubDir
+	if err := sfs.db.Select(&sds, sql, args...); err != nil {
+		return nil, nil, err
+	}
+
+	dirs := make(map[string]time.Time, len(sds))
+	for _, sd := range sds {
+		dirs[sd.Name] = sd.Time.Time
+	}
+	return files, dirs, nil
+}
+
+func (sfs *sfs) addQuery(sqb *sqlx.Builder, fq *xfs.FileQuery) {
+	if fq.LastID != "" {
+		sqb.Where("id > ?", fq.LastID)
+	}
+	if fq.Prefix != "" {
+		sqb.Like("id", sqx.StartsLike(fq.Prefix))
+	}
+	if fq.Tag != "" {
+		sqb.Eq("tag", fq.Tag)
+	}
+	if len(fq.Exts) > 0 {
+		sqb.In("ext", fq.Exts)
+	}
+	if !fq.TimeMin.IsZero() {
+		sqb.Gte("time", fq.TimeMin)
+	}
+	if !fq.TimeMax.IsZero() {
+		sqb.Lte("time", fq.TimeMax)
+	}
+}
+
+func (sfs *sfs) CountFiles(fq *xfs.FileQuery) (total int, err error) {
+	sqb := sfs.db.Builder()
+	sqb.Count()
+	sqb.From(sfs.tb)
+	sfs.alive(sqb)
+	sfs.addQuery(sqb, fq)
+	sql, args := sqb.Build()
+
+	err = sfs.db.Get(&total, sql, args...)
+	return
+}
+
+func (sfs *sfs) FindFiles(fq *xfs.FileQuery) (files []*xfs.File, err error) {
+	cols, err := sfs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := sfs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(sfs.tb)
+	sfs.alive(sqb)
+	sfs.addQuery(sqb, fq)
+
+	sqb.Orders(fq.Order, "id")
+	sqb.Offset(fq.Start()).Limit(fq.Limit)
+
+	sql, args := sqb.Build()
+
+	err = sfs.db.Select(&files, sql, args...)
+	return
+}
+
+func (sfs *sfs) SumSize(fq *xfs.FileQuery) (size int64, err error) {
+	sqb := sfs.db.Builder()
+	sqb.Select("COALESCE(SUM(size), 0)")
+	sqb.From(sfs.tb)
+	sfs.alive(sqb)
+	sfs.addQuery(sqb, fq)
+	sql, args := sqb.Build()
+
+	err = sfs.db.Get(&size, sql, args...)
+	return
+}
+
 func (sfs *sfs) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*xfs.File, error) {
-	name := filepath.Base(filename)
-	fext := str.ToLower(filepath.Ext(filename))
+	if sfs.ct != "" {
+		fi, err := sfs.SaveFileReader(id, filename, filetime, bytes.NewReader(data), tag...)
+		fi.Data = data
+		return fi, err
+	}
+
+	fi := newFile(id, filename, filetime, tag...)
+	fi.Size = int64(len(data))
+	fi.Hash = xfs.HashData(data)
+	fi.MIME = xfs.DetectMIME(data, fi.Ext)
+	fi.Data = data
+
+	return fi, sfs.saveData(fi, data)
+}
+
+// SaveFileReader save a file with the data read from the reader.
+// If the chunk table is not specified, the entire data is read into memory.
+func (sfs *sfs) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*xfs.File, error) {
+	return sfs.saveReader(newFile(id, filename, filetime, tag...), r, nil)
+}
+
+// SaveEncodedFile save a file with the encoded data read from the reader and the encoding metadata.
+// The file table must have the "codec" and "raw_size" columns, see OptionalColumns.
+func (sfs *sfs) SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *xfs.Encoding, tag ...string) (*xfs.File, error) {
+	ocs, err := sfs.optionals()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(ocs, "codec") || !slices.Contains(ocs, "raw_size") {
+		return nil, fmt.Errorf("sqlxfs: no codec and raw_size columns in the file table %q: %w", sfs.tb, errors.ErrUnsupported)
+	}
+
+	return sfs.saveReader(newFile(id, filename, filetime, tag...), r, enc)
+}
 
-	fi := &xfs.File{
+// setEncoding set the encoding metadata to the file
+func setEncoding(fi *xfs.File, enc *xfs.Encoding) {
+	if enc != nil {
+		fi.Codec, fi.RawSize, fi.MIME = enc.Codec, enc.RawSize, enc.MIME
+	}
+}
+
+func (sfs *sfs) saveReader(fi *xfs.File, r io.Reader, enc *xfs.Encoding) (*xfs.File, error) {
+	if sfs.ct == "" {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fi, err
+		}
+
+		fi.Size = int64(len(data))
+		fi.Hash = xfs.HashData(data)
+		fi.MIME = xfs.DetectMIME(data, fi.Ext)
+		fi.Data = data
+		setEncoding(fi, enc)
+		return fi, sfs.saveData(fi, data)
+	}
+
+	if sfs.bt != "" {
+		return fi, sfs.saveBlob(fi, r, enc)
+	}
+
+	// store the data to the chunks of a temporary fid, so the old data is kept if the read fails
+	tid := "~" + rand.Text()
+
+	hr := xfs.NewHashReader(r)
+	if err := sfs.insertChunks(tid, hr); err != nil {
+		_ = sfs.deleteChunks("fid = ?", tid)
+		return fi, err
+	}
+	fi.Size, fi.Hash, fi.MIME = hr.Size(), hr.Hash(), hr.MIME(fi.Ext)
+	setEncoding(fi, enc)
+
+	err := sfs.transaction(func(db sqlx.Sqlx) error {
+		tfs := sfs.withDB(db)
+		if err := tfs.purgeTrashed(fi.ID); err != nil {
+			return err
+		}
+		if err := tfs.deleteChunks("fid = ?", fi.ID); err != nil {
+			return err
+		}
+		if err := tfs.renameChunks(tid, fi.ID); err != nil {
+			return err
+		}
+		return tfs.saveFile(fi, []byte{})
+	})
+	if err != nil {
+		_ = sfs.deleteChunks("fid = ?", tid)
+	}
+	return fi, err
+}
+
+// transaction call fn with a transaction of the db if the db is a *sqlx.DB, otherwise call fn with the db
+func (sfs *sfs) transaction(fn func(db sqlx.Sqlx) error) error {
+	if db, ok := sfs.db.(*sqlx.DB); ok {
+		return db.Transaction(func(tx *sqlx.Tx) error {
+			return fn(tx)
+		})
+	}
+	return fn(sfs.db)
+}
+
+// withDB returns a copy of the sfs which uses the db
+func (sfs *sfs) withDB(db sqlx.Sqlx) *sfs {
+	tfs := *sfs
+	tfs.db = db
+	return &tfs
+}
+
+// saveBlob store the data to the chunks of a temporary fid, then add the blob reference and save the file in a transaction.
+// The temporary chunks are renamed to the hash if the blob does not exist, otherwise they are deleted.
+func (sfs *sfs) saveBlob(fi *xfs.File, r io.Reader, enc *xfs.Encoding) error {
+	ocs, err := sfs.optionals()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(ocs, "hash") {
+		return fmt.Errorf("sqlxfs: no hash column in the file table %q: %w", sfs.tb, errors.ErrUnsupported)
+	}
+
+	tid := "~" + rand.Text()
+
+	hr := xfs.NewHashReader(r)
+	if err := sfs.insertChunks(tid, hr); err != nil {
+		_ = sfs.deleteChunks("fid = ?", tid)
+		return err
+	}
+	fi.Size, fi.Hash, fi.MIME = hr.Size(), hr.Hash(), hr.MIME(fi.Ext)
+	setEncoding(fi, enc)
+
+	save := func(db sqlx.Sqlx) error {
+		tfs := sfs.withDB(db)
+
+		if err := tfs.purgeTrashed(fi.ID); err != nil {
+			return err
+		}
+
+		if err := tfs.addBlob(fi.Hash, fi.Size, tid); err != nil {
+			return err
+		}
+
+		old, err := tfs.FindFile(fi.ID)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err := tfs.saveFile(fi, []byte{}); err != nil {
+			return err
+		}
+
+		if old != nil {
+			return tfs.releaseBlobs(old.Hash)
+		}
+		return nil
+	}
+
+	err = sfs.transaction(save)
+	if _, ok := sfs.db.(*sqlx.DB); ok && err != nil {
+		// the blob may be inserted by a concurrent transaction, retry to increase the reference count
+		err = sfs.transaction(save)
+	}
+	if err != nil {
+		_ = sfs.deleteChunks("fid = ?", tid)
+	}
+	return err
+}
+
+// addBlob increase the reference count of the blob and delete the chunks of the temporary fid,
+// or insert the blob and rename the chunks of the temporary fid to the hash if the blob does not exist.
+// The insert fails with the duplicate key error if the blob is inserted concurrently.
+func (sfs *sfs) addBlob(hash string, size int64, tid string) error {
+	cnt, err := sfs.refBlob(hash, 1)
+	if err != nil {
+		return err
+	}
+
+	if cnt > 0 {
+		return sfs.deleteChunks("fid = ?", tid)
+	}
+
+	sqb := sfs.db.Builder()
+	sqb.Insert(sfs.bt)
+	sqb.Setc("hash", hash)
+	sqb.Setc("size", size)
+	sqb.Setc("refs", 1)
+	sql, args := sqb.Build()
+
+	if _, err := sfs.db.Exec(sql, args...); err != nil {
+		return err
+	}
+
+	return sfs.renameChunks(tid, hash)
+}
+
+// refBlob add n to the reference count of the blob, returns the updated row count
+func (sfs *sfs) refBlob(hash string, n int) (int64, error) {
+	bt := sfs.db.Quote(sfs.bt)
+	sql := sfs.db.Rebind("UPDATE " + bt + " SET refs = refs + ? WHERE hash = ?")
+	return sfs.db.Update(sql, n, hash)
+}
+
+// releaseBlobs decrease the reference count of the blobs, and delete the unreferenced blobs
+func (sfs *sfs) releaseBlobs(hashes ...string) error {
+	if sfs.bt == "" || len(hashes) == 0 {
+		return nil
+	}
+
+	refs := map[string]int{}
+	for _, h := range hashes {
+		refs[h]++
+	}
+
+	for h, n := range refs {
+		if _, err := sfs.refBlob(h, -n); err != nil {
+			return err
+		}
+	}
+
+	bt := sfs.db.Quote(sfs.bt)
+	if err := sfs.deleteChunks("fid IN (SELECT hash FROM " + bt + " WHERE refs <= 0)"); err != nil {
+		return err
+	}
+
+	_, err := sfs.db.Exec("DELETE FROM " + bt + " WHERE refs <= 0")
+	return err
+}
+
+func newFile(id string, filename string, filetime time.Time, tag ...string) *xfs.File {
+	return &xfs.File{
 		ID:   id,
-		Name: name,
-		Ext:  fext,
+		Name: filepath.Base(filename),
+		Ext:  str.ToLower(filepath.Ext(filename)),
 		Tag:  asg.First(tag),
-		Size: int64(len(data)),
 		Time: filetime,
-		Data: data,
+	}
+}
+
+// setOptionals set the optional columns of the file
+func setOptionals(sqb *sqlx.Builder, ocs []string, fi *xfs.File) {
+	for _, c := range ocs {
+		switch c {
+		case "hash":
+			sqb.Setc(c, fi.Hash)
+		case "mime":
+			sqb.Setc(c, fi.MIME)
+		case "codec":
+			sqb.Setc(c, fi.Codec)
+		case "raw_size":
+			sqb.Setc(c, fi.RawSize)
+		}
+	}
+}
+
+// saveData purge the deleted file with the same id and save the file with the data in a transaction
+func (sfs *sfs) saveData(fi *xfs.File, data []byte) error {
+	return sfs.transaction(func(db sqlx.Sqlx) error {
+		tfs := sfs.withDB(db)
+		if err := tfs.purgeTrashed(fi.ID); err != nil {
+			return err
+		}
+		return tfs.saveFile(fi, data)
+	})
+}
+
+// purgeTrashed permanently delete the deleted file with the id in the trash bin,
+// so the id can be used by a new file. It must be called before the chunks of the new file are saved.
+func (sfs *sfs) purgeTrashed(id string) error {
+	if !sfs.tr {
+		return nil
+	}
+
+	_, err := sfs.purgeWhere("id = ? AND deleted_at IS NOT NULL", id)
+	return err
+}
+
+// saveFile insert or update the file, the deleted file with the same id must be purged by purgeTrashed() before.
+func (sfs *sfs) saveFile(fi *xfs.File, data []byte) error {
+	ocs, err := sfs.optionals()
+	if err != nil {
+		return err
 	}
 
 	sqb := sfs.db.Builder()
-	if _, err := sfs.FindFile(id); err == nil {
+	if _, err := sfs.FindFile(fi.ID); err == nil {
 		sqb.Update(sfs.tb)
 		sqb.Setc("name", fi.Name)
 		sqb.Setc("ext", fi.Ext)
 		sqb.Setc("tag", fi.Tag)
 		sqb.Setc("size", fi.Size)
 		sqb.Setc("time", fi.Time)
-		sqb.Setc("data", fi.Data)
-		sqb.Where("id = ?", id)
+		sqb.Setc("data", data)
+		setOptionals(sqb, ocs, fi)
+		sqb.Where("id = ?", fi.ID)
 	} else {
 		sqb.Insert(sfs.tb)
 		sqb.Setc("id", fi.ID)
@@ -85,24 +578,131 @@ func (sfs *sfs) SaveFile(id string, filename string, filetime time.Time, data []
 		sqb.Setc("tag", fi.Tag)
 		sqb.Setc("size", fi.Size)
 		sqb.Setc("time", fi.Time)
-		sqb.Setc("data", fi.Data)
+		sqb.Setc("data", data)
+		setOptionals(sqb, ocs, fi)
 	}
 	sql, args := sqb.Build()
 
 	cnt, err := sfs.db.Update(sql, args...)
 	if err != nil {
-		return fi, err
+		return err
 	}
 
 	if cnt != 1 {
-		return fi, fs.ErrNotExist
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// insertChunks insert the data read from r as the chunks of the fid
+func (sfs *sfs) insertChunks(fid string, r io.Reader) error {
+	buf := make([]byte, ChunkSize)
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := sfs.insertChunk(fid, seq, buf[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (sfs *sfs) insertChunk(fid string, seq int, data []byte) error {
+	sqb := sfs.db.Builder()
+	sqb.Insert(sfs.ct)
+	sqb.Setc("fid", fid)
+	sqb.Setc("seq", seq)
+	sqb.Setc("data", data)
+	sql, args := sqb.Build()
+
+	_, err := sfs.db.Exec(sql, args...)
+	return err
+}
+
+// renameChunks change the fid of the chunks from src to dst
+func (sfs *sfs) renameChunks(src, dst string) error {
+	sqb := sfs.db.Builder()
+	sqb.Update(sfs.ct)
+	sqb.Setc("fid", dst)
+	sqb.Where("fid = ?", src)
+	sql, args := sqb.Build()
+
+	_, err := sfs.db.Exec(sql, args...)
+	return err
+}
+
+// findChunks find the chunks [from, to] of the file
+func (sfs *sfs) findChunks(fid string, from, to int64) ([]*xfs.FileChunk, error) {
+	sqb := sfs.db.Builder()
+	sqb.Select().From(sfs.ct).Where("fid = ?", fid)
+	sqb.Btw("seq", from, to)
+	sqb.Order("seq")
+	sql, args := sqb.Build()
+
+	var fcs []*xfs.FileChunk
+	err := sfs.db.Select(&fcs, sql, args...)
+	return fcs, err
+}
+
+// findFileChunks find the chunks [from, to] of the not deleted file id
+func (sfs *sfs) findFileChunks(id string, from, to int64) ([]*xfs.FileChunk, error) {
+	fk := "id"
+	if sfs.bt != "" {
+		fk = "hash"
+	}
+
+	where := "f.id = ? AND c.seq BETWEEN ? AND ?"
+	if sfs.tr {
+		where += " AND f.deleted_at IS NULL"
 	}
-	return fi, nil
+
+	sql := fmt.Sprintf("SELECT c.fid, c.seq, c.data FROM %s c JOIN %s f ON c.fid = f.%s WHERE %s ORDER BY c.seq",
+		sfs.db.Quote(sfs.ct), sfs.db.Quote(sfs.tb), fk, where)
+	sql = sfs.db.Rebind(sql)
+
+	var fcs []*xfs.FileChunk
+	err := sfs.db.Select(&fcs, sql, id, from, to)
+	return fcs, err
 }
 
-func (sfs *sfs) ReadFile(id string) ([]byte, error) {
+func (sfs *sfs) deleteChunks(where string, args ...any) error {
+	if sfs.ct == "" {
+		return nil
+	}
+
+	sql := sfs.db.Rebind("DELETE FROM " + sfs.db.Quote(sfs.ct) + " WHERE " + where)
+	_, err := sfs.db.Exec(sql, args...)
+	return err
+}
+
+// ReadFile read the file data, the name can be a file id or a fs.FS path name
+func (sfs *sfs) ReadFile(name string) ([]byte, error) {
+	id := xfs.FileID(name)
+
+	if sfs.ct != "" {
+		f, err := sfs.FindFile(id)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, f.Size)
+		n, err := sfs.readChunksAt(sfs.chunkID(f), data, 0)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return data[:n], nil
+	}
+
 	sqb := sfs.db.Builder()
 	sqb.Select().From(sfs.tb).Where("id = ?", id)
+	sfs.alive(sqb)
 	sql, args := sqb.Build()
 
 	f := &xfs.File{}
@@ -117,17 +717,140 @@ func (sfs *sfs) ReadFile(id string) ([]byte, error) {
 	return f.Data, nil
 }
 
+// OpenReader open a reader to read the file data.
+// If the chunk table is not specified, the entire data is read into memory.
+func (sfs *sfs) OpenReader(id string) (io.ReadCloser, error) {
+	f, err := sfs.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if sfs.ct == "" {
+		data, err := sfs.ReadFile(id)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return &chunkReader{sfs: sfs, fid: sfs.chunkID(f), size: f.Size}, nil
+}
+
+func (sfs *sfs) ReadFileAt(id string, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if sfs.ct == "" {
+		return sfs.readDataAt(id, p, off)
+	}
+
+	var n int
+	var err error
+	if sfs.bt != "" || sfs.tr {
+		n, err = sfs.readFileChunksAt(id, p, off)
+	} else {
+		n, err = sfs.readChunksAt(id, p, off)
+	}
+	if n == 0 && errors.Is(err, io.EOF) {
+		if _, err := sfs.FindFile(id); err != nil {
+			return 0, err
+		}
+	}
+	return n, err
+}
+
+// chunkID returns the fid of the chunks of the file
+func (sfs *sfs) chunkID(f *xfs.File) string {
+	if sfs.bt != "" {
+		return f.Hash
+	}
+	return f.ID
+}
+
+func (sfs *sfs) readChunksAt(fid string, p []byte, off int64) (int, error) {
+	fcs, err := sfs.findChunks(fid, off/ChunkSize, (off+int64(len(p))-1)/ChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	return copyChunks(fcs, p, off)
+}
+
+// readFileChunksAt read the chunks of the not deleted file id by a query joined with the file table,
+// so the file is not found by a extra query for each read.
+func (sfs *sfs) readFileChunksAt(id string, p []byte, off int64) (int, error) {
+	fcs, err := sfs.findFileChunks(id, off/ChunkSize, (off+int64(len(p))-1)/ChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	return copyChunks(fcs, p, off)
+}
+
+// copyChunks copy the data of the chunks from the offset off to p
+func copyChunks(fcs []*xfs.FileChunk, p []byte, off int64) (int, error) {
+	n := 0
+	for _, fc := range fcs {
+		co := off + int64(n) - int64(fc.Seq)*ChunkSize
+		if co < 0 || co >= int64(len(fc.Data)) {
+			break
+		}
+		n += copy(p[n:], fc.Data[co:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readDataAt read the data column by SUBSTR(data, off+1, len(p))
+func (sfs *sfs) readDataAt(id string, p []byte, off int64) (int, error) {
+	sql := sfs.db.Rebind("SELECT SUBSTR(data, ?, ?) FROM " + sfs.db.Quote(sfs.tb) + " WHERE " + sfs.aliveWhere("id = ?"))
+
+	var data []byte
+	if err := sfs.db.Get(&data, sql, off+1, len(p), id); err != nil {
+		if errors.Is(err, sqlx.ErrNoRows) {
+			return 0, fs.ErrNotExist
+		}
+		return 0, err
+	}
+
+	n := copy(p, data)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// CopyFile copy the file, the existing dst file is overwritten
 func (sfs *sfs) CopyFile(src, dst string, tag ...string) error {
+	if src == dst {
+		return sfs.retag(src, tag...)
+	}
+
+	if err := sfs.prepareDest(src, dst); err != nil {
+		return err
+	}
+
+	ocs, err := sfs.optionals()
+	if err != nil {
+		return err
+	}
+
 	tb := sfs.db.Quote(sfs.tb)
+	cols := strings.Join(append([]string{"time", "size", "data"}, ocs...), ", ")
 
 	var args []any
 
-	sql := fmt.Sprintf("INSERT INTO %s (id, name, ext, tag, time, size, data) ", tb)
+	sql := fmt.Sprintf("INSERT INTO %s (id, name, ext, tag, %s) ", tb, cols)
 	if len(tag) == 0 {
-		sql += fmt.Sprintf("SELECT ?, name, ext, tag, time, size, data FROM %s WHERE id = ?", tb)
+		sql += fmt.Sprintf("SELECT ?, name, ext, tag, %s FROM %s WHERE %s", cols, tb, sfs.aliveWhere("id = ?"))
 		args = append(args, dst, src)
 	} else {
-		sql += fmt.Sprintf("SELECT ? AS id, name, ext, ? AS tag, time, size, data FROM %s WHERE id = ?", tb)
+		sql += fmt.Sprintf("SELECT ? AS id, name, ext, ? AS tag, %s FROM %s WHERE %s", cols, tb, sfs.aliveWhere("id = ?"))
 		args = append(args, dst, tag[0], src)
 	}
 	sql = sfs.db.Rebind(sql)
@@ -140,10 +863,39 @@ func (sfs *sfs) CopyFile(src, dst string, tag ...string) error {
 	if cnt == 0 {
 		return fs.ErrNotExist
 	}
+
+	if sfs.bt != "" {
+		f, err := sfs.FindFile(dst)
+		if err != nil {
+			return err
+		}
+
+		_, err = sfs.refBlob(f.Hash, 1)
+		return err
+	}
+
+	if sfs.ct != "" {
+		ct := sfs.db.Quote(sfs.ct)
+		sql = fmt.Sprintf("INSERT INTO %s (fid, seq, data) SELECT ?, seq, data FROM %s WHERE fid = ?", ct, ct)
+		sql = sfs.db.Rebind(sql)
+
+		if _, err := sfs.db.Exec(sql, dst, src); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// MoveFile move the file, the existing dst file is overwritten
 func (sfs *sfs) MoveFile(src, dst string, tag ...string) error {
+	if src == dst {
+		return sfs.retag(src, tag...)
+	}
+
+	if err := sfs.prepareDest(src, dst); err != nil {
+		return err
+	}
+
 	sqb := sfs.db.Builder()
 	sqb.Update(sfs.tb)
 	sqb.Setc("id", dst)
@@ -151,6 +903,47 @@ func (sfs *sfs) MoveFile(src, dst string, tag ...string) error {
 		sqb.Setc("tag", tag[0])
 	}
 	sqb.Where("id = ?", src)
+	sfs.alive(sqb)
+	sql, args := sqb.Build()
+
+	cnt, err := sfs.db.Update(sql, args...)
+	if err != nil {
+		return err
+	}
+
+	if cnt == 0 {
+		return fs.ErrNotExist
+	}
+
+	if sfs.ct != "" && sfs.bt == "" {
+		return sfs.renameChunks(src, dst)
+	}
+	return nil
+}
+
+// prepareDest check the src file exists, and permanently delete the dst file (include the deleted file)
+// so the dst id can be used by CopyFile/MoveFile.
+func (sfs *sfs) prepareDest(src, dst string) error {
+	if _, err := sfs.FindFile(src); err != nil {
+		return err
+	}
+
+	_, err := sfs.purgeWhere("id = ?", dst)
+	return err
+}
+
+// retag update the tag of the file for CopyFile/MoveFile to the same id
+func (sfs *sfs) retag(id string, tag ...string) error {
+	if len(tag) == 0 {
+		_, err := sfs.FindFile(id)
+		return err
+	}
+
+	sqb := sfs.db.Builder()
+	sqb.Update(sfs.tb)
+	sqb.Setc("tag", tag[0])
+	sqb.Where("id = ?", id)
+	sfs.alive(sqb)
 	sql, args := sqb.Build()
 
 	cnt, err := sfs.db.Update(sql, args...)
@@ -165,6 +958,15 @@ func (sfs *sfs) MoveFile(src, dst string, tag ...string) error {
 }
 
 func (sfs *sfs) DeleteFile(id string) error {
+	if sfs.bt != "" || sfs.tr {
+		_, err := sfs.DeleteWhere("id = ?", id)
+		return err
+	}
+
+	if err := sfs.deleteChunks("fid = ?", id); err != nil {
+		return err
+	}
+
 	sqb := sfs.db.Builder()
 	sqb.Delete(sfs.tb).Where("id = ?", id)
 	sql, args := sqb.Build()
@@ -198,18 +1000,289 @@ func (sfs *sfs) DeleteTaggedBefore(tag string, before time.Time) (int64, error)
 	return sfs.DeleteWhere("tag = ? AND time < ?", tag, before)
 }
 
+// DeleteWhere delete files by customized where filter.
+// For the soft deletion file system, the files are marked as deleted (moved to the trash bin).
 func (sfs *sfs) DeleteWhere(where string, args ...any) (int64, error) {
-	sql := sfs.db.Rebind("DELETE FROM " + sfs.db.Quote(sfs.tb) + " WHERE " + where)
+	if sfs.tr {
+		sql := sfs.db.Rebind("UPDATE " + sfs.db.Quote(sfs.tb) + " SET deleted_at = ? WHERE " + sfs.aliveWhere(where))
+		return sfs.db.Update(sql, append([]any{time.Now()}, args...)...)
+	}
+
+	return sfs.purgeWhere(where, args...)
+}
+
+// purgeWhere permanently delete files by customized where filter
+func (sfs *sfs) purgeWhere(where string, args ...any) (int64, error) {
+	tb := sfs.db.Quote(sfs.tb)
+
+	if sfs.bt != "" {
+		var cnt int64
+		err := sfs.transaction(func(db sqlx.Sqlx) error {
+			tfs := sfs.withDB(db)
+
+			var hashes []string
+
+			sql := db.Rebind("SELECT hash FROM " + tb + " WHERE " + where)
+			if err := db.Select(&hashes, sql, args...); err != nil {
+				return err
+			}
+
+			sql = db.Rebind("DELETE FROM " + tb + " WHERE " + where)
+			n, err := db.Update(sql, args...)
+			if err != nil {
+				return err
+			}
+			cnt = n
+
+			return tfs.releaseBlobs(hashes...)
+		})
+		return cnt, err
+	}
+
+	if err := sfs.deleteChunks("fid IN (SELECT id FROM "+tb+" WHERE "+where+")", args...); err != nil {
+		return 0, err
+	}
+
+	sql := sfs.db.Rebind("DELETE FROM " + tb + " WHERE " + where)
 	return sfs.db.Update(sql, args...)
 }
 
-// DeleteAll use "DELETE FROM files" to delete all files
+// DeleteAll use "DELETE FROM files" to delete all files.
+// For the soft deletion file system, all files are marked as deleted (moved to the trash bin).
 func (sfs *sfs) DeleteAll() (int64, error) {
+	if sfs.tr {
+		sql := sfs.db.Rebind("UPDATE " + sfs.db.Quote(sfs.tb) + " SET deleted_at = ? WHERE deleted_at IS NULL")
+		return sfs.db.Update(sql, time.Now())
+	}
+
+	if sfs.bt != "" {
+		if _, err := sfs.db.Exec("DELETE FROM " + sfs.db.Quote(sfs.bt)); err != nil {
+			return 0, err
+		}
+	}
+
+	if sfs.ct != "" {
+		if _, err := sfs.db.Exec("DELETE FROM " + sfs.db.Quote(sfs.ct)); err != nil {
+			return 0, err
+		}
+	}
+
 	return sfs.db.Update("DELETE FROM " + sfs.db.Quote(sfs.tb))
 }
 
-// Truncate use "TRUNCATE TABLE files" to truncate files
+// Truncate use "TRUNCATE TABLE files" to truncate files.
+// The deleted files of the soft deletion file system are truncated too.
 func (sfs *sfs) Truncate() error {
+	if sfs.bt != "" {
+		if _, err := sfs.db.Exec("TRUNCATE TABLE " + sfs.db.Quote(sfs.bt)); err != nil {
+			return err
+		}
+	}
+
+	if sfs.ct != "" {
+		if _, err := sfs.db.Exec("TRUNCATE TABLE " + sfs.db.Quote(sfs.ct)); err != nil {
+			return err
+		}
+	}
+
 	_, err := sfs.db.Exec("TRUNCATE TABLE " + sfs.db.Quote(sfs.tb))
 	return err
 }
+
+// tsfs implements xfs.TrashFS interface
+type tsfs struct {
+	sfs
+}
+
+func (tsfs *tsfs) CountTrash(fq *xfs.FileQuery) (total int, err error) {
+	sqb := tsfs.db.Builder()
+	sqb.Count()
+	sqb.From(tsfs.tb)
+	sqb.Where("deleted_at IS NOT NULL")
+	tsfs.addQuery(sqb, fq)
+	sql, args := sqb.Build()
+
+	err = tsfs.db.Get(&total, sql, args...)
+	return
+}
+
+func (tsfs *tsfs) ListTrash(fq *xfs.FileQuery) (files []*xfs.File, err error) {
+	cols, err := tsfs.columns()
+	if err != nil {
+		return nil, err
+	}
+
+	sqb := tsfs.db.Builder()
+	sqb.Select(cols...)
+	sqb.From(tsfs.tb)
+	sqb.Where("deleted_at IS NOT NULL")
+	tsfs.addQuery(sqb, fq)
+
+	sqb.Orders(fq.Order, "id")
+	sqb.Offset(fq.Start()).Limit(fq.Limit)
+
+	sql, args := sqb.Build()
+
+	err = tsfs.db.Select(&files, sql, args...)
+	return
+}
+
+func (tsfs *tsfs) Restore(ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	sqb := tsfs.db.Builder()
+	sqb.Update(tsfs.tb)
+	sqb.Setc("deleted_at", nil)
+	sqb.In("id", ids)
+	sqb.Where("deleted_at IS NOT NULL")
+	sql, args := sqb.Build()
+
+	return tsfs.db.Update(sql, args...)
+}
+
+func (tsfs *tsfs) Purge(ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	sql, args := sqx.In("id", ids)
+	return tsfs.purgeWhere(sql+" AND deleted_at IS NOT NULL", args...)
+}
+
+func (tsfs *tsfs) PurgeBefore(before time.Time) (int64, error) {
+	return tsfs.purgeWhere("deleted_at < ?", before)
+}
+
+func (tsfs *tsfs) PurgeWhere(where string, args ...any) (int64, error) {
+	return tsfs.purgeWhere(where, args...)
+}
+
+// chunkReader reads the file data chunk by chunk
+type chunkReader struct {
+	sfs  *sfs
+	fid  string
+	size int64
+	off  int64
+	buf  []byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if len(cr.buf) == 0 {
+		if cr.off >= cr.size {
+			return 0, io.EOF
+		}
+
+		seq := cr.off / ChunkSize
+		fcs, err := cr.sfs.findChunks(cr.fid, seq, seq)
+		if err != nil {
+			return 0, err
+		}
+		if len(fcs) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		cr.buf = fcs[0].Data
+	}
+
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	cr.off += int64(n)
+	return n, nil
+}
+
+func (cr *chunkReader) Close() error {
+	cr.buf = nil
+	return nil
+}
+
+// TableColumns the columns of a table which are detected on the first use
+type TableColumns struct {
+	mu   sync.Mutex
+	cols []string
+}
+
+// Columns returns the columns of the table, the columns are detected by "SELECT * FROM table WHERE 1 = 0" on the first call
+func (tc *TableColumns) Columns(db sqlx.Sqlx, table string) ([]string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.cols != nil {
+		return tc.cols, nil
+	}
+
+	rows, err := db.Query("SELECT * FROM " + db.Quote(table) + " WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	tc.cols = cols
+	return cols, nil
+}
+
+// Existing returns the columns of cols which exist in the table
+func (tc *TableColumns) Existing(db sqlx.Sqlx, table string, cols ...string) ([]string, error) {
+	tcs, err := tc.Columns(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	ecs := []string{}
+	for _, c := range cols {
+		if slices.Contains(tcs, c) {
+			ecs = append(ecs, c)
+		}
+	}
+	return ecs, nil
+}
+
+// Strpos returns the SQL expression of the position of the substring sub in the string s
+func Strpos(db sqlx.Sqlx, s, sub string) string {
+	if db.DriverName() == "sqlite3" {
+		return "INSTR(" + s + ", " + sub + ")"
+	}
+	return "POSITION(" + sub + " IN " + s + ")"
+}
+
+// SubDir the sub directory name and the latest time of the files in it
+type SubDir struct {
+	Name string
+	Time SubTime
+}
+
+// SubTime scans the aggregated time which is returned as a string by some drivers (sqlite3)
+type SubTime struct {
+	time.Time
+}
+
+func (st *SubTime) Scan(v any) (err error) {
+	switch t := v.(type) {
+	case time.Time:
+		st.Time = t
+	case string:
+		st.Time, err = ParseTime(t)
+	case []byte:
+		st.Time, err = ParseTime(string(t))
+	case nil:
+		st.Time = time.Time{}
+	default:
+		err = fmt.Errorf("sqlxfs: unsupported time %T", v)
+	}
+	return
+}
+
+// ParseTime parse the time string returned by the drivers
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, time.DateTime} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("sqlxfs: invalid time %q", s)
+}
@@ -0,0 +1,355 @@
+package sqlxfs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/xfstest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	testFileTableDDL = `CREATE TABLE files (
+	id         TEXT PRIMARY KEY,
+	name       TEXT NOT NULL,
+	ext        TEXT NOT NULL,
+	tag        TEXT NOT NULL DEFAULT '',
+	time       TIMESTAMP NOT NULL,
+	size       INTEGER NOT NULL,
+	hash       TEXT NOT NULL DEFAULT '',
+	mime       TEXT NOT NULL DEFAULT '',
+	data       BLOB NOT NULL,
+	codec      TEXT NOT NULL DEFAULT '',
+	raw_size   INTEGER NOT NULL DEFAULT 0,
+	deleted_at TIMESTAMP NULL
+)`
+
+	testChunkTableDDL = `CREATE TABLE file_chunks (
+	fid  TEXT NOT NULL,
+	seq  INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (fid, seq)
+)`
+
+	testBlobTableDDL = `CREATE TABLE file_blobs (
+	hash TEXT PRIMARY KEY,
+	size INTEGER NOT NULL,
+	refs INTEGER NOT NULL
+)`
+)
+
+func testOpenDB(t *testing.T, conns ...int) *sqlx.DB {
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+
+	if len(conns) > 0 {
+		sdb.SetMaxOpenConns(conns[0])
+	}
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	for _, ddl := range []string{testFileTableDDL, testChunkTableDDL, testBlobTableDDL} {
+		if _, err := db.Exec(ddl); err != nil {
+			t.Fatalf("%v\n%s", err, ddl)
+		}
+	}
+	return db
+}
+
+func TestSqlxFS(t *testing.T) {
+	cs := []struct {
+		name string
+		xfs  func(db sqlx.Sqlx) xfs.XFS
+	}{
+		{"FS", func(db sqlx.Sqlx) xfs.XFS { return FS(db, "files") }},
+		{"ChunkFS", func(db sqlx.Sqlx) xfs.XFS { return FS(db, "files", "file_chunks") }},
+		{"DedupFS", func(db sqlx.Sqlx) xfs.XFS { return DedupFS(db, "files", "file_chunks", "file_blobs") }},
+		{"TrashFS", func(db sqlx.Sqlx) xfs.XFS { return TrashFS(db, "files") }},
+		{"ChunkTrashFS", func(db sqlx.Sqlx) xfs.XFS { return TrashFS(db, "files", "file_chunks") }},
+		{"DedupTrashFS", func(db sqlx.Sqlx) xfs.XFS { return DedupTrashFS(db, "files", "file_chunks", "file_blobs") }},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			xfstest.TestXFS(t, c.xfs(testOpenDB(t)))
+		})
+	}
+}
+
+// failReader returns the error after reading n bytes
+type failReader struct {
+	n   int
+	err error
+}
+
+func (fr *failReader) Read(p []byte) (int, error) {
+	if fr.n <= 0 {
+		return 0, fr.err
+	}
+	n := min(len(p), fr.n)
+	clear(p[:n])
+	fr.n -= n
+	return n, nil
+}
+
+func TestSaveFileReaderFailed(t *testing.T) {
+	cs := []struct {
+		name string
+		xfs  func(db sqlx.Sqlx) xfs.XFS
+	}{
+		{"ChunkFS", func(db sqlx.Sqlx) xfs.XFS { return FS(db, "files", "file_chunks") }},
+		{"DedupFS", func(db sqlx.Sqlx) xfs.XFS { return DedupFS(db, "files", "file_chunks", "file_blobs") }},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			db := testOpenDB(t)
+			sfs := c.xfs(db)
+
+			if _, err := sfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+
+			rerr := errors.New("read failed")
+			if _, err := sfs.SaveFileReader("/a.txt", "a.txt", time.Now(), &failReader{ChunkSize + 10, rerr}); !errors.Is(err, rerr) {
+				t.Fatalf("SaveFileReader() = %v, want %v", err, rerr)
+			}
+
+			if data, err := sfs.ReadFile("/a.txt"); err != nil || string(data) != "old" {
+				t.Errorf("ReadFile() = %q, %v, want %q", data, err, "old")
+			}
+
+			var cnt int
+			if err := db.Get(&cnt, "SELECT COUNT(*) FROM file_chunks WHERE fid LIKE '~%'"); err != nil || cnt != 0 {
+				t.Errorf("temporary chunks = %d, %v, want 0", cnt, err)
+			}
+		})
+	}
+}
+
+func TestDedupConcurrentSave(t *testing.T) {
+	// the statements in a transaction must use the transaction, otherwise they are blocked
+	db := testOpenDB(t, 1)
+
+	sfs := DedupFS(db, "files", "file_chunks", "file_blobs")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Go(func() {
+			_, errs[i] = sfs.SaveFile(fmt.Sprintf("/%d.txt", i), "a.txt", time.Now(), []byte("same"))
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("[%d] SaveFile() = %v", i, err)
+		}
+	}
+
+	var refs int
+	if err := db.Get(&refs, "SELECT refs FROM file_blobs"); err != nil || refs != len(errs) {
+		t.Errorf("refs = %d, %v, want %d", refs, err, len(errs))
+	}
+
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM file_chunks"); err != nil || cnt != 1 {
+		t.Errorf("chunks = %d, %v, want 1", cnt, err)
+	}
+
+	if n, err := sfs.DeleteAll(); err != nil || n != int64(len(errs)) {
+		t.Errorf("DeleteAll() = %d, %v", n, err)
+	}
+}
+
+func TestSqlxFSNoOptionalColumns(t *testing.T) {
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sdb.Close()
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	ddl := `CREATE TABLE files (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	ext  TEXT NOT NULL,
+	tag  TEXT NOT NULL DEFAULT '',
+	time TIMESTAMP NOT NULL,
+	size INTEGER NOT NULL,
+	hash TEXT NOT NULL DEFAULT '',
+	data BLOB NOT NULL
+)`
+	if _, err := db.Exec(ddl); err != nil {
+		t.Fatal(err)
+	}
+
+	sfs := FS(db, "files")
+	xfstest.TestXFS(t, sfs)
+
+	enc := &xfs.Encoding{Codec: "gzip"}
+	if _, err := sfs.(xfs.EncodedSaver).SaveEncodedFile("/a.txt", "a.txt", time.Now(), strings.NewReader("a"), enc); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SaveEncodedFile() = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestSqlxFSNoHashColumn(t *testing.T) {
+	db := testOpenDB(t)
+	if _, err := db.Exec("ALTER TABLE files DROP COLUMN hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	sfs := FS(db, "files", "file_chunks")
+	if _, err := sfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := sfs.FindFile("/a.txt")
+	if err != nil || f.Hash != "" || f.Size != 1 {
+		t.Errorf("FindFile() = %v, %v", f, err)
+	}
+	if bs, err := sfs.ReadFile("/a.txt"); err != nil || string(bs) != "a" {
+		t.Errorf("ReadFile() = %q, %v", bs, err)
+	}
+	if err := sfs.CopyFile("/a.txt", "/b.txt"); err != nil {
+		t.Errorf("CopyFile() = %v", err)
+	}
+
+	dfs := DedupFS(db, "files", "file_chunks", "file_blobs")
+	if _, err := dfs.SaveFile("/c.txt", "c.txt", time.Now(), []byte("c")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("DedupFS.SaveFile() = %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestTrashFS(t *testing.T) {
+	cs := []struct {
+		name string
+		xfs  func(db sqlx.Sqlx) xfs.TrashFS
+	}{
+		{"TrashFS", func(db sqlx.Sqlx) xfs.TrashFS { return TrashFS(db, "files") }},
+		{"ChunkTrashFS", func(db sqlx.Sqlx) xfs.TrashFS { return TrashFS(db, "files", "file_chunks") }},
+		{"DedupTrashFS", func(db sqlx.Sqlx) xfs.TrashFS { return DedupTrashFS(db, "files", "file_chunks", "file_blobs") }},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			db := testOpenDB(t)
+			tfs := c.xfs(db)
+
+			for _, id := range []string{"/a.txt", "/b.txt", "/c.txt", "/d.txt"} {
+				if _, err := tfs.SaveFile(id, "a.txt", time.Now(), []byte("same")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// DeleteWhere marks the files as deleted
+			if n, err := tfs.DeleteWhere("id IN (?, ?, ?)", "/a.txt", "/b.txt", "/c.txt"); err != nil || n != 3 {
+				t.Fatalf("DeleteWhere() = %d, %v, want 3", n, err)
+			}
+			if n, err := tfs.DeleteWhere("id = ?", "/a.txt"); err != nil || n != 0 {
+				t.Errorf("DeleteWhere(deleted) = %d, %v, want 0", n, err)
+			}
+
+			var deleted int
+			if err := db.Get(&deleted, "SELECT COUNT(*) FROM files WHERE deleted_at IS NOT NULL"); err != nil || deleted != 3 {
+				t.Errorf("deleted rows = %d, %v, want 3", deleted, err)
+			}
+
+			// the deleted files are excluded
+			if n, err := tfs.CountFiles(&xfs.FileQuery{}); err != nil || n != 1 {
+				t.Errorf("CountFiles() = %d, %v, want 1", n, err)
+			}
+			if n, err := tfs.SumSize(&xfs.FileQuery{}); err != nil || n != 4 {
+				t.Errorf("SumSize() = %d, %v, want 4", n, err)
+			}
+			if _, err := tfs.ReadFile("/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("ReadFile(deleted) = %v, want %v", err, fs.ErrNotExist)
+			}
+			if _, err := tfs.ReadFileAt("/a.txt", make([]byte, 2), 0); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("ReadFileAt(deleted) = %v, want %v", err, fs.ErrNotExist)
+			}
+			if n, err := tfs.CountTrash(&xfs.FileQuery{}); err != nil || n != 3 {
+				t.Errorf("CountTrash() = %d, %v, want 3", n, err)
+			}
+
+			// Restore
+			if n, err := tfs.Restore("/a.txt", "/d.txt"); err != nil || n != 1 {
+				t.Errorf("Restore() = %d, %v, want 1", n, err)
+			}
+			bs := make([]byte, 2)
+			if n, err := tfs.ReadFileAt("/a.txt", bs, 2); err != nil || string(bs[:n]) != "me" {
+				t.Errorf("ReadFileAt(restored) = %q, %v", bs[:n], err)
+			}
+
+			// id reuse: the deleted file with the same id is purged
+			if _, err := tfs.SaveFile("/b.txt", "b.txt", time.Now(), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := tfs.ReadFile("/b.txt"); err != nil || string(data) != "new" {
+				t.Errorf("ReadFile(reused) = %q, %v", data, err)
+			}
+			if files, err := tfs.ListTrash(&xfs.FileQuery{}); err != nil || len(files) != 1 || files[0].ID != "/c.txt" {
+				t.Errorf("ListTrash() = %v, %v", files, err)
+			}
+
+			// Purge
+			if n, err := tfs.Purge("/a.txt", "/c.txt"); err != nil || n != 1 {
+				t.Errorf("Purge() = %d, %v, want 1", n, err)
+			}
+			if n, err := tfs.CountTrash(&xfs.FileQuery{}); err != nil || n != 0 {
+				t.Errorf("CountTrash() = %d, %v, want 0", n, err)
+			}
+
+			// PurgeBefore
+			if err := tfs.DeleteFile("/a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := tfs.PurgeBefore(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+				t.Errorf("PurgeBefore(past) = %d, %v, want 0", n, err)
+			}
+			if n, err := tfs.PurgeBefore(time.Now().Add(time.Second)); err != nil || n != 1 {
+				t.Errorf("PurgeBefore() = %d, %v, want 1", n, err)
+			}
+
+			// PurgeWhere
+			if n, err := tfs.PurgeWhere("id = ?", "/d.txt"); err != nil || n != 1 {
+				t.Errorf("PurgeWhere() = %d, %v, want 1", n, err)
+			}
+
+			// only "/b.txt" remains
+			var files int
+			if err := db.Get(&files, "SELECT COUNT(*) FROM files"); err != nil || files != 1 {
+				t.Errorf("file rows = %d, %v, want 1", files, err)
+			}
+
+			if c.name == "DedupTrashFS" {
+				var refs int
+				if err := db.Get(&refs, "SELECT COALESCE(SUM(refs), 0) FROM file_blobs"); err != nil || refs != 1 {
+					t.Errorf("refs = %d, %v, want 1", refs, err)
+				}
+				var blobs int
+				if err := db.Get(&blobs, "SELECT COUNT(*) FROM file_blobs"); err != nil || blobs != 1 {
+					t.Errorf("blobs = %d, %v, want 1", blobs, err)
+				}
+			}
+			if c.name != "TrashFS" {
+				var chunks int
+				if err := db.Get(&chunks, "SELECT COUNT(*) FROM file_chunks"); err != nil || chunks != 1 {
+					t.Errorf("chunks = %d, %v, want 1", chunks, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package xfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xcw/xpdf"
+)
+
+var (
+	// ErrThumbUnsupported indicates the thumbnail of the file type is not supported
+	ErrThumbUnsupported = errors.New("xfs: unsupported thumbnail file type")
+
+	// ErrThumbTooLarge indicates the image pixels exceed the ThumbFS.MaxPixels
+	ErrThumbTooLarge = errors.New("xfs: too large thumbnail image")
+)
+
+// ThumbSize the bounding box size of the thumbnail
+type ThumbSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (ts ThumbSize) String() string {
+	return strconv.Itoa(ts.Width) + "x" + strconv.Itoa(ts.Height)
+}
+
+// ThumbFS wraps a XFS to generate the thumbnails of the image (.jpg, .jpeg, .png, .gif) and pdf files.
+// The thumbnails are stored as the derived files "{Prefix}{id}/{width}x{height}.{format}" of the Store (see DerivedFS)
+// with the file time of the original file, so a cached thumbnail is regenerated when the original file is updated.
+// The thumbnails are deleted when the original file is updated or deleted.
+// The image dimensions are checked by image.DecodeConfig() before the decoding, see MaxPixels.
+// The first page of the pdf file is converted to a image by xpdf.PdfReaderImagify() (pdftoppm command).
+type ThumbFS struct {
+	DerivedFS
+
+	// Format the thumbnail image format "jpeg" or "png", default "jpeg"
+	Format string
+
+	// Quality the jpeg quality, default jpeg.DefaultQuality
+	Quality int
+
+	// MaxPixels the maximum pixels (width * height) of the image to decode, default 50M
+	MaxPixels int64
+
+	// Sizes the thumbnail sizes to generate in background after SaveFile() and SaveFileReader()
+	Sizes []ThumbSize
+
+	// Logger the logger to log the thumbnail generation error in background
+	Logger log.Logger
+
+	wg sync.WaitGroup
+}
+
+// NewThumbFS create a ThumbFS which stores the thumbnails in the store,
+// and generates the thumbnails of the sizes in background after the file is saved.
+func NewThumbFS(xfs, store XFS, sizes ...ThumbSize) *ThumbFS {
+	return &ThumbFS{
+		DerivedFS: DerivedFS{XFS: xfs, Store: store, Prefix: "/.thumbs"},
+		Format:    "jpeg",
+		Quality:   jpeg.DefaultQuality,
+		MaxPixels: 50_000_000,
+		Sizes:     sizes,
+	}
+}
+
+// ThumbSupported returns true if the thumbnail of the file extension is supported
+func ThumbSupported(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".pdf":
+		return true
+	default:
+		return false
+	}
+}
+
+func (tfs *ThumbFS) thumbExt() string {
+	if tfs.Format == "png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// ThumbID returns the file id of the thumbnail in the Store of the original file id
+func (tfs *ThumbFS) ThumbID(id string, size ThumbSize) string {
+	return tfs.DerivedDir(id) + size.String() + tfs.thumbExt()
+}
+
+// Thumbnail returns the thumbnail file in the Store of the original file id, the thumbnail is generated if not cached.
+// Returns ErrThumbUnsupported if the original file is not a image or pdf file.
+func (tfs *ThumbFS) Thumbnail(ctx context.Context, id string, size ThumbSize) (*File, error) {
+	if size.Width <= 0 || size.Height <= 0 {
+		return nil, fs.ErrInvalid
+	}
+
+	of, err := tfs.XFS.FindFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ThumbSupported(of.Ext) {
+		return nil, ErrThumbUnsupported
+	}
+
+	tid := tfs.ThumbID(id, size)
+
+	tf, err := tfs.Store.FindFile(tid)
+	if err == nil && tf.Time.Equal(of.Time) {
+		return tf, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return tfs.generate(ctx, of, size)
+}
+
+// Wait waits for the thumbnail generations in background to finish
+func (tfs *ThumbFS) Wait() {
+	tfs.wg.Wait()
+}
+
+func (tfs *ThumbFS) generate(ctx context.Context, of *File, size ThumbSize) (*File, error) {
+	img, err := tfs.decode(ctx, of, size)
+	if err != nil {
+		return nil, err
+	}
+
+	img = ResizeImage(img, size.Width, size.Height)
+
+	buf := &bytes.Buffer{}
+	if tfs.Format == "png" {
+		err = png.Encode(buf, img)
+	} else {
+		err = jpeg.Encode(buf, flattenImage(img), &jpeg.Options{Quality: tfs.Quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tid := tfs.ThumbID(of.ID, size)
+	return tfs.Store.SaveFile(tid, path.Base(tid), of.Time, buf.Bytes())
+}
+
+// checkPixels check the image dimensions by image.DecodeConfig() without decoding the image
+func (tfs *ThumbFS) checkPixels(of *File) error {
+	r, err := tfs.XFS.OpenReader(of.ID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+
+	if tfs.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > tfs.MaxPixels {
+		return fmt.Errorf("%w: %q (%dx%d)", ErrThumbTooLarge, of.ID, cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+func (tfs *ThumbFS) decode(ctx context.Context, of *File, size ThumbSize) (image.Image, error) {
+	ext := strings.ToLower(of.Ext)
+	if ext != ".pdf" {
+		if err := tfs.checkPixels(of); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := tfs.XFS.OpenReader(of.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	switch ext {
+	case ".jpg", ".jpeg":
+		return jpeg.Decode(r)
+	case ".png":
+		return png.Decode(r)
+	case ".gif":
+		return gif.Decode(r)
+	case ".pdf":
+		return decodePdfPage(ctx, r, max(size.Width, size.Height))
+	default:
+		return nil, ErrThumbUnsupported
+	}
+}
+
+// decodePdfPage convert the first page of the pdf to a png image by pdftoppm
+func decodePdfPage(ctx context.Context, r io.Reader, scale int) (image.Image, error) {
+	dir, err := os.MkdirTemp("", "xfs-thumb-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	prefix := filepath.Join(dir, "page")
+	err = xpdf.PdfReaderImagify(ctx, r, prefix, "-f", "1", "-l", "1", "-singlefile", "-png", "-scale-to", strconv.Itoa(scale))
+	if err != nil {
+		return nil, err
+	}
+
+	fr, err := os.Open(prefix + ".png")
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	return png.Decode(fr)
+}
+
+// saved delete the thumbnails of the saved file, and generate the thumbnails of the Sizes in background
+func (tfs *ThumbFS) saved(f *File) error {
+	if err := tfs.deleteDerived(f.ID); err != nil {
+		return err
+	}
+
+	if len(tfs.Sizes) == 0 || !ThumbSupported(f.Ext) {
+		return nil
+	}
+
+	of := *f
+	of.Data = nil
+
+	tfs.wg.Add(1)
+	go func() {
+		defer tfs.wg.Done()
+
+		for _, size := range tfs.Sizes {
+			if _, err := tfs.generate(context.Background(), &of, size); err != nil {
+				if tfs.Logger != nil {
+					tfs.Logger.Warnf("Failed to generate %s thumbnail of %q: %v", size, f.ID, err)
+				}
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (tfs *ThumbFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	f, err := tfs.XFS.SaveFile(id, filename, filetime, data, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, tfs.saved(f)
+}
+
+func (tfs *ThumbFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	f, err := tfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, tfs.saved(f)
+}
+
+func (tfs *ThumbFS) CopyFile(src, dst string, tag ...string) error {
+	if err := tfs.XFS.CopyFile(src, dst, tag...); err != nil {
+		return err
+	}
+	return tfs.deleteDerived(dst)
+}
+
+func (tfs *ThumbFS) MoveFile(src, dst string, tag ...string) error {
+	if err := tfs.XFS.MoveFile(src, dst, tag...); err != nil {
+		return err
+	}
+	return tfs.deleteDerived(src, dst)
+}
+
+//----------------------------------------------------
+
+// ResizeImage scales down the image to fit in the width x height box with the area averaging,
+// the aspect ratio is kept and the image is never scaled up.
+func ResizeImage(src image.Image, width, height int) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	if sw <= width && sh <= height {
+		return src
+	}
+
+	scale := min(float64(width)/float64(sw), float64(height)/float64(sh))
+	dw := max(1, int(float64(sw)*scale+0.5))
+	dh := max(1, int(float64(sh)*scale+0.5))
+
+	// convert to RGBA (fast paths of draw.Draw) to read the pixels directly
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, sb.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := range dw {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				i := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[i])
+					g += int(rgba.Pix[i+1])
+					b += int(rgba.Pix[i+2])
+					a += int(rgba.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)   //nolint: gosec
+			dst.Pix[i+1] = uint8(g / n) //nolint: gosec
+			dst.Pix[i+2] = uint8(b / n) //nolint: gosec
+			dst.Pix[i+3] = uint8(a / n) //nolint: gosec
+		}
+	}
+	return dst
+}
+
+// flattenImage draw the image over a white background for the jpeg encoding
+func flattenImage(src image.Image) image.Image {
+	if o, ok := src.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return src
+	}
+
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+	return dst
+}
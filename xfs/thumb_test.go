@@ -0,0 +1,147 @@
+package xfs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+
+	r := xfs.ResizeImage(img, 100, 100)
+	if b := r.Bounds(); b.Dx() != 100 || b.Dy() != 25 {
+		t.Errorf("ResizeImage() = %v", b)
+	}
+	if c := r.At(50, 10).(color.RGBA); c.R != 200 || c.A != 200 {
+		t.Errorf("ResizeImage().At() = %v", c)
+	}
+
+	if r := xfs.ResizeImage(img, 500, 500); r != image.Image(img) {
+		t.Errorf("ResizeImage() should not scale up")
+	}
+}
+
+func TestThumbFS(t *testing.T) {
+	dfs, sfs := dirxfs.FS(t.TempDir()), dirxfs.FS(t.TempDir())
+	tfs := xfs.NewThumbFS(dfs, sfs, xfs.ThumbSize{Width: 32, Height: 32})
+
+	tm := time.Now().Truncate(time.Second)
+	if _, err := tfs.SaveFile("/a/b.png", "b.png", tm, testPNG(t, 200, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfs.SaveFile("/a/b.png/c.png", "c.png", tm, testPNG(t, 10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	tfs.Wait()
+
+	// the thumbnails are not in the original xfs
+	if files, err := dfs.ListPrefix("/"); err != nil || len(files) != 2 {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+
+	tid := tfs.ThumbID("/a/b.png", xfs.ThumbSize{Width: 32, Height: 32})
+	if tid != "/.thumbs/a/b.png/32x32.jpg" {
+		t.Errorf("ThumbID() = %q", tid)
+	}
+
+	data, err := sfs.ReadFile(tid)
+	if err != nil {
+		t.Fatalf("auto generated thumbnail: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil || img.Bounds().Dx() != 32 || img.Bounds().Dy() != 16 {
+		t.Errorf("thumbnail = %v, %v", img.Bounds(), err)
+	}
+
+	// on request
+	tf, err := tfs.Thumbnail(context.Background(), "/a/b.png", xfs.ThumbSize{Width: 64, Height: 64})
+	if err != nil || tf.ID != "/.thumbs/a/b.png/64x64.jpg" || !tf.Time.Equal(tm) {
+		t.Fatalf("Thumbnail() = %v, %v", tf, err)
+	}
+
+	// cached
+	tf2, err := tfs.Thumbnail(context.Background(), "/a/b.png", xfs.ThumbSize{Width: 64, Height: 64})
+	if err != nil || tf2.Hash != tf.Hash {
+		t.Errorf("Thumbnail(cached) = %v, %v", tf2, err)
+	}
+
+	if _, err := tfs.SaveFile("/a/c.txt", "c.txt", tm, []byte("text")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfs.Thumbnail(context.Background(), "/a/c.txt", xfs.ThumbSize{Width: 64, Height: 64}); !errors.Is(err, xfs.ErrThumbUnsupported) {
+		t.Errorf("Thumbnail(txt) = %v", err)
+	}
+
+	// too large
+	tfs.MaxPixels = 100
+	if _, err := tfs.Thumbnail(context.Background(), "/a/b.png", xfs.ThumbSize{Width: 16, Height: 16}); !errors.Is(err, xfs.ErrThumbTooLarge) {
+		t.Errorf("Thumbnail(too large) = %v", err)
+	}
+	tfs.MaxPixels = 0
+
+	// delete with the original, the thumbnails of the descendant file are kept
+	if err := tfs.DeleteFile("/a/b.png"); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := sfs.ListPrefix("/.thumbs/"); len(files) != 1 || files[0].ID != "/.thumbs/a/b.png/c.png/32x32.jpg" {
+		t.Errorf("thumbnails are not deleted: %v", files)
+	}
+	if cnt, err := tfs.DeletePrefix("/a/"); err != nil || cnt != 2 {
+		t.Errorf("DeletePrefix() = %d, %v", cnt, err)
+	}
+	if files, _ := sfs.ListPrefix("/.thumbs/"); len(files) != 0 {
+		t.Errorf("thumbnails are not deleted: %v", files)
+	}
+
+	// orphans
+	if _, err := tfs.SaveFile("/d.gif.png", "d.png", tm, testPNG(t, 10, 10), "img"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tfs.SaveFile("/e.png", "e.png", tm, testPNG(t, 10, 10)); err != nil {
+		t.Fatal(err)
+	}
+	tfs.Wait()
+
+	if cnt, err := tfs.DeleteTagged("img"); err != nil || cnt != 1 {
+		t.Errorf("DeleteTagged() = %d, %v", cnt, err)
+	}
+	if _, err := sfs.FindFile("/.thumbs/d.gif.png/32x32.jpg"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("thumbnail of the tagged file is not deleted: %v", err)
+	}
+
+	if err := dfs.DeleteFile("/e.png"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt, err := tfs.CleanOrphans(); err != nil || cnt != 1 {
+		t.Errorf("CleanOrphans() = %d, %v", cnt, err)
+	}
+}
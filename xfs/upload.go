@@ -0,0 +1,357 @@
+package xfs
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUploadOffset indicates the upload offset does not match the current offset of the upload session
+	ErrUploadOffset = errors.New("xfs: upload offset mismatch")
+
+	// ErrUploadLength indicates the uploaded data exceeds the upload length
+	ErrUploadLength = errors.New("xfs: upload length exceeded")
+
+	// ErrUploadIncomplete indicates the upload session is not completed
+	ErrUploadIncomplete = errors.New("xfs: upload incomplete")
+
+	// ErrUploadLocked indicates the upload session is being appended or finalized by another request
+	ErrUploadLocked = errors.New("xfs: upload locked")
+)
+
+// Upload a resumable upload session
+type Upload struct {
+	ID       string            `json:"id"`
+	Filename string            `json:"filename"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Created  time.Time         `json:"created"`
+	Updated  time.Time         `json:"updated"`
+}
+
+// IsCompleted returns true if all data of the upload session is uploaded
+func (u *Upload) IsCompleted() bool {
+	return u.Offset >= u.Length
+}
+
+const uploadMeta = "upload.json"
+
+// Uploads manages the resumable upload sessions which partial data are stored in the Store XFS,
+// and the completed upload sessions are finalized into the regular files of the XFS.
+// The Store should be a XFS separated from the XFS (for example a dirxfs of a temporary directory),
+// so the partial data are not listed, counted or limited by the quota of the XFS.
+//
+// The files of the upload session "sid" are stored under the directory Prefix + sid of the Store:
+// the session metadata file "upload.json" and the data chunk files named by the hex offset.
+// An upload session is created by Create(), the chunks are appended by Append(),
+// and the completed session is finalized into a regular file by Finalize().
+//
+// An upload session is locked while a chunk is appended or the session is finalized,
+// the other requests of the locked session fail with ErrUploadLocked instead of waiting for the client.
+type Uploads struct {
+	XFS    XFS
+	Store  XFS
+	Prefix string // the file id prefix of the upload sessions in the Store, default "/"
+
+	mu    sync.Mutex
+	locks map[string]bool // the locked upload sessions
+}
+
+// NewUploads create a Uploads which stores the upload sessions in the store
+func NewUploads(xfs, store XFS, prefix ...string) *Uploads {
+	ups := &Uploads{XFS: xfs, Store: store, Prefix: "/"}
+	if len(prefix) > 0 {
+		ups.Prefix = prefix[0]
+	}
+	return ups
+}
+
+func (ups *Uploads) dir(sid string) string {
+	return ups.Prefix + sid + "/"
+}
+
+// lock lock the upload session, returns ErrUploadLocked if the session is already locked
+func (ups *Uploads) lock(sid string) error {
+	ups.mu.Lock()
+	defer ups.mu.Unlock()
+
+	if ups.locks[sid] {
+		return ErrUploadLocked
+	}
+
+	if ups.locks == nil {
+		ups.locks = make(map[string]bool)
+	}
+	ups.locks[sid] = true
+	return nil
+}
+
+func (ups *Uploads) unlock(sid string) {
+	ups.mu.Lock()
+	delete(ups.locks, sid)
+	ups.mu.Unlock()
+}
+
+func (ups *Uploads) validSID(sid string) bool {
+	return sid != "" && !strings.ContainsAny(sid, "/.")
+}
+
+// Get get the upload session, returns fs.ErrNotExist if not found
+func (ups *Uploads) Get(sid string) (*Upload, error) {
+	if !ups.validSID(sid) {
+		return nil, fs.ErrNotExist
+	}
+
+	data, err := ups.Store.ReadFile(ups.dir(sid) + uploadMeta)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (ups *Uploads) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = ups.Store.SaveFile(ups.dir(u.ID)+uploadMeta, uploadMeta, u.Updated, data)
+	return err
+}
+
+// Create create a upload session of the file with the total length
+func (ups *Uploads) Create(filename string, length int64, metadata map[string]string) (*Upload, error) {
+	if length < 0 {
+		return nil, fs.ErrInvalid
+	}
+
+	now := time.Now()
+	u := &Upload{
+		ID:       rand.Text(),
+		Filename: filename,
+		Length:   length,
+		Metadata: metadata,
+		Created:  now,
+		Updated:  now,
+	}
+
+	if err := ups.save(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Append append the data read from r at the offset of the upload session.
+// The offset should be equal to the current offset of the upload session, or ErrUploadOffset is returned.
+// If r returns a error, the received data is kept, and the updated upload session is returned with the error,
+// so the client can resume the upload from the updated offset.
+// The upload session is locked until the data is read to EOF, see ErrUploadLocked.
+func (ups *Uploads) Append(sid string, offset int64, r io.Reader) (*Upload, error) {
+	if !ups.validSID(sid) {
+		return nil, fs.ErrNotExist
+	}
+
+	if err := ups.lock(sid); err != nil {
+		return nil, err
+	}
+	defer ups.unlock(sid)
+
+	u, err := ups.Get(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != u.Offset {
+		return u, ErrUploadOffset
+	}
+
+	// read one more byte to detect the exceeded data
+	rest := u.Length - u.Offset
+	ur := &uploadReader{r: io.LimitReader(r, rest+1)}
+
+	cid := ups.dir(sid) + fmt.Sprintf("%016x", offset)
+	f, err := ups.Store.SaveFileReader(cid, u.Filename, time.Now(), ur)
+	if err != nil {
+		return u, err
+	}
+
+	if f.Size > rest {
+		if err := ups.Store.DeleteFile(cid); err != nil {
+			return u, err
+		}
+		return u, ErrUploadLength
+	}
+
+	if f.Size == 0 {
+		_ = ups.Store.DeleteFile(cid)
+		return u, ur.err
+	}
+
+	u.Offset += f.Size
+	u.Updated = time.Now()
+	if err := ups.save(u); err != nil {
+		return u, err
+	}
+	return u, ur.err
+}
+
+// Finalize save the data of the completed upload session to the file `id`, and delete the upload session.
+func (ups *Uploads) Finalize(sid, id string, tag ...string) (*File, error) {
+	if !ups.validSID(sid) {
+		return nil, fs.ErrNotExist
+	}
+
+	if err := ups.lock(sid); err != nil {
+		return nil, err
+	}
+	defer ups.unlock(sid)
+
+	u, err := ups.Get(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsCompleted() {
+		return nil, ErrUploadIncomplete
+	}
+
+	files, err := ups.Store.ListPrefix(ups.dir(sid))
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &chunksReader{xfs: ups.Store}
+	for _, f := range files {
+		if !strings.HasSuffix(f.ID, "/"+uploadMeta) {
+			cr.ids = append(cr.ids, f.ID)
+		}
+	}
+	defer cr.Close()
+
+	f, err := ups.XFS.SaveFileReader(id, u.Filename, time.Now(), cr, tag...)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ups.Store.DeletePrefix(ups.dir(sid)); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// Cancel delete the upload session
+func (ups *Uploads) Cancel(sid string) error {
+	if !ups.validSID(sid) {
+		return fs.ErrNotExist
+	}
+
+	if err := ups.lock(sid); err != nil {
+		return err
+	}
+	defer ups.unlock(sid)
+
+	_, err := ups.Store.DeletePrefix(ups.dir(sid))
+	return err
+}
+
+// CleanOutdated delete the upload sessions which are not updated after the time `before`.
+// The locked upload sessions are skipped. Returns the count of the deleted upload sessions.
+func (ups *Uploads) CleanOutdated(before time.Time) (int, error) {
+	files, err := ups.Store.ListPrefix(ups.Prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+	for _, f := range files {
+		if !strings.HasSuffix(f.ID, "/"+uploadMeta) || !f.Time.Before(before) {
+			continue
+		}
+
+		dir := strings.TrimSuffix(f.ID, uploadMeta)
+		sid := strings.TrimSuffix(strings.TrimPrefix(dir, ups.Prefix), "/")
+		if ups.lock(sid) != nil {
+			continue
+		}
+
+		_, err := ups.Store.DeletePrefix(dir)
+		ups.unlock(sid)
+		if err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// uploadReader records the error of the underlying reader and returns io.EOF instead,
+// so the received data can be saved.
+type uploadReader struct {
+	r   io.Reader
+	err error
+}
+
+func (ur *uploadReader) Read(p []byte) (int, error) {
+	n, err := ur.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		ur.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunksReader reads the files of ids sequentially
+type chunksReader struct {
+	xfs XFS
+	ids []string
+	rc  io.ReadCloser
+}
+
+func (cr *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if cr.rc == nil {
+			if len(cr.ids) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := cr.xfs.OpenReader(cr.ids[0])
+			if err != nil {
+				return 0, err
+			}
+			cr.rc, cr.ids = rc, cr.ids[1:]
+		}
+
+		n, err := cr.rc.Read(p)
+		if errors.Is(err, io.EOF) {
+			cr.rc.Close()
+			cr.rc = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (cr *chunksReader) Close() error {
+	if cr.rc != nil {
+		err := cr.rc.Close()
+		cr.rc = nil
+		return err
+	}
+	return nil
+}
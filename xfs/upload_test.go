@@ -0,0 +1,111 @@
+package xfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+)
+
+type failReader struct {
+	r io.Reader
+}
+
+func (fr *failReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestUploads(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+	ups := xfs.NewUploads(dfs, dirxfs.FS(t.TempDir()))
+
+	u, err := ups.Create("a.txt", 10, map[string]string{"filename": "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ups.Append(u.ID, 3, strings.NewReader("abc")); !errors.Is(err, xfs.ErrUploadOffset) {
+		t.Errorf("Append(3) = %v, want ErrUploadOffset", err)
+	}
+
+	// broken connection keeps the received data
+	u, err = ups.Append(u.ID, 0, &failReader{strings.NewReader("0123")})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || u.Offset != 4 {
+		t.Errorf("Append(0) = %v, %v", u, err)
+	}
+
+	if _, err := ups.Finalize(u.ID, "/a.txt"); !errors.Is(err, xfs.ErrUploadIncomplete) {
+		t.Errorf("Finalize() = %v, want ErrUploadIncomplete", err)
+	}
+
+	if _, err := ups.Append(u.ID, 4, strings.NewReader("4567890")); !errors.Is(err, xfs.ErrUploadLength) {
+		t.Errorf("Append(4) = %v, want ErrUploadLength", err)
+	}
+
+	// the session is locked while the chunk is being appended
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := ups.Append(u.ID, 4, pr)
+		done <- err
+	}()
+	pw.Write([]byte("45"))
+
+	if _, err := ups.Append(u.ID, 4, strings.NewReader("456789")); !errors.Is(err, xfs.ErrUploadLocked) {
+		t.Errorf("Append(locked) = %v, want ErrUploadLocked", err)
+	}
+	if _, err := ups.Finalize(u.ID, "/a.txt"); !errors.Is(err, xfs.ErrUploadLocked) {
+		t.Errorf("Finalize(locked) = %v, want ErrUploadLocked", err)
+	}
+	if u, err := ups.Get(u.ID); err != nil || u.Offset != 4 {
+		t.Errorf("Get(locked) = %v, %v", u, err)
+	}
+
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Append(4) = %v", err)
+	}
+
+	if u, err = ups.Append(u.ID, 6, strings.NewReader("6789")); err != nil || !u.IsCompleted() {
+		t.Fatalf("Append(4) = %v, %v", u, err)
+	}
+
+	if u, err = ups.Get(u.ID); err != nil || u.Offset != 10 || u.Metadata["filename"] != "a.txt" {
+		t.Errorf("Get() = %v, %v", u, err)
+	}
+
+	f, err := ups.Finalize(u.ID, "/a.txt", "doc")
+	if err != nil || f.Size != 10 || f.Name != "a.txt" || f.Tag != "doc" {
+		t.Fatalf("Finalize() = %v, %v", f, err)
+	}
+	if bs, err := dfs.ReadFile("/a.txt"); err != nil || string(bs) != "0123456789" {
+		t.Errorf("ReadFile() = %q, %v", bs, err)
+	}
+	if files, err := dfs.ListPrefix("/"); err != nil || len(files) != 1 {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+	if _, err := ups.Get(u.ID); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(finalized) = %v", err)
+	}
+
+	// clean outdated
+	u1, _ := ups.Create("b.txt", 5, nil)
+	if cnt, err := ups.CleanOutdated(time.Now().Add(-time.Hour)); err != nil || cnt != 0 {
+		t.Errorf("CleanOutdated(-1h) = %d, %v", cnt, err)
+	}
+	if cnt, err := ups.CleanOutdated(time.Now().Add(time.Second)); err != nil || cnt != 1 {
+		t.Errorf("CleanOutdated(+1s) = %d, %v", cnt, err)
+	}
+	if _, err := ups.Get(u1.ID); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get(cleaned) = %v", err)
+	}
+}
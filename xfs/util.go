@@ -1,35 +1,46 @@
 package xfs
 
 import (
+	"io"
 	"mime/multipart"
 	"os"
 	"time"
 
-	"github.com/askasoft/pango/fsu"
-	"github.com/askasoft/pango/net/httpx"
 	"github.com/askasoft/pango/str"
 )
 
+// SaveLocalFile save the local file to the xfs.
+// If xfs is a ScannedSaver (see ScanFS), the file is scanned by SaveScanned().
 func SaveLocalFile(xfs XFS, id string, filename string, tag ...string) (*File, error) {
 	fi, err := os.Stat(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := fsu.ReadFile(filename)
+	fr, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer fr.Close()
 
-	return xfs.SaveFile(id, filename, fi.ModTime(), data, tag...)
+	return saveScanned(xfs, id, filename, fi.ModTime(), fr, tag...)
 }
 
+// SaveUploadedFile save the uploaded file to the xfs.
+// If xfs is a ScannedSaver (see ScanFS), the file is scanned by SaveScanned().
 func SaveUploadedFile(xfs XFS, id string, file *multipart.FileHeader, tag ...string) (*File, error) {
-	data, err := httpx.ReadMultipartFile(file)
+	fr, err := file.Open()
 	if err != nil {
 		return nil, err
 	}
+	defer fr.Close()
 
 	filename := str.ToValidUTF8(file.Filename, " ")
-	return xfs.SaveFile(id, filename, time.Now(), data, tag...)
+	return saveScanned(xfs, id, filename, time.Now(), fr, tag...)
+}
+
+// SaveFileReadSeeker save the data read from r to the xfs.
+// If xfs is a ScannedSaver (see ScanFS), the data is scanned by SaveScanned().
+func SaveFileReadSeeker(xfs XFS, id string, filename string, filetime time.Time, r io.ReadSeeker, tag ...string) (*File, error) {
+	return saveScanned(xfs, id, filename, filetime, r, tag...)
 }
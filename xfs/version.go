@@ -0,0 +1,363 @@
+package xfs
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileVersion a prior version of a file, the embedded File is the version file,
+// the File.Time is the time when the version is created.
+type FileVersion struct {
+	*File
+	Version int `json:"version"`
+}
+
+// VersionFS wraps a XFS to keep the version history of the files.
+// When a existing file is overwritten by SaveFile(), SaveFileReader(), CopyFile() or MoveFile(),
+// the prior content is copied to the numbered version file "{Prefix}{id}/{version}" of the Store (see DerivedFS)
+// with the name and tag of the prior file, and the time when the version is created.
+// The versions are deleted when the file is deleted.
+// The changes of a file id are serialized by a lock of the file id.
+//
+// The prior content is read from the XFS and saved to the Store by SaveFileReader(),
+// so the Store must be wrapped by the same encoding wrappers (CryptFS, CompressFS) as the XFS,
+// otherwise the versions of the encrypted files are stored as the plain data.
+type VersionFS struct {
+	DerivedFS
+
+	// KeepLast keep the last N versions of a file, 0 means unlimited
+	KeepLast int
+
+	// KeepWithin keep the versions which are created within the duration, 0 means unlimited
+	KeepWithin time.Duration
+
+	locks idLocks
+}
+
+// NewVersionFS create a VersionFS which stores the versions in the store,
+// and keeps the last N versions of a file (0 means unlimited).
+// The store must be wrapped by the same encoding wrappers as the xfs, see VersionFS.
+func NewVersionFS(xfs, store XFS, keepLast int) *VersionFS {
+	return &VersionFS{
+		DerivedFS: DerivedFS{XFS: xfs, Store: store, Prefix: "/.versions"},
+		KeepLast:  keepLast,
+	}
+}
+
+// VersionID returns the file id in the Store of the version of the file id
+func (vfs *VersionFS) VersionID(id string, version int) string {
+	return vfs.DerivedDir(id) + fmt.Sprintf("%08d", version)
+}
+
+// ListVersions list the versions of the file id, ordered by version
+func (vfs *VersionFS) ListVersions(id string) ([]*FileVersion, error) {
+	files, err := vfs.ListDerived(id)
+	if err != nil {
+		return nil, err
+	}
+
+	fvs := make([]*FileVersion, 0, len(files))
+	for _, f := range files {
+		v, err := strconv.Atoi(path.Base(f.ID))
+		if err != nil {
+			continue
+		}
+		fvs = append(fvs, &FileVersion{File: f, Version: v})
+	}
+	return fvs, nil
+}
+
+// ReadVersion read the data of the version of the file id
+func (vfs *VersionFS) ReadVersion(id string, version int) ([]byte, error) {
+	return vfs.Store.ReadFile(vfs.VersionID(id, version))
+}
+
+// RevertTo revert the file id to the version, the current content is kept as a new version.
+// The reverted file has the name and tag of the version, and the time of the revert.
+// The version is copied to a temporary file, then the temporary file is moved to the file id,
+// so the file id is never missing during the revert.
+func (vfs *VersionFS) RevertTo(id string, version int) error {
+	vid := vfs.VersionID(id, version)
+
+	unlock := vfs.locks.lock(id)
+	defer unlock()
+
+	if _, err := vfs.Store.FindFile(vid); err != nil {
+		return err
+	}
+
+	if err := vfs.snapshot(id); err != nil {
+		return err
+	}
+
+	tmp := id + "." + rand.Text() + ".tmp"
+	if _, err := copyXFS(vfs.XFS, tmp, vfs.Store, vid, time.Now()); err != nil {
+		return err
+	}
+
+	if err := vfs.XFS.MoveFile(tmp, id); err != nil {
+		_ = vfs.XFS.DeleteFile(tmp)
+		return err
+	}
+	return vfs.prune(id)
+}
+
+// snapshot copy the current content of the file id to a new version
+func (vfs *VersionFS) snapshot(id string) error {
+	if _, err := vfs.XFS.FindFile(id); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	fvs, err := vfs.ListVersions(id)
+	if err != nil {
+		return err
+	}
+
+	v := 1
+	if len(fvs) > 0 {
+		v = fvs[len(fvs)-1].Version + 1
+	}
+
+	_, err = copyXFS(vfs.Store, vfs.VersionID(id, v), vfs.XFS, id, time.Now())
+	return err
+}
+
+// prune delete the versions of the file id by the retention policy
+func (vfs *VersionFS) prune(id string) error {
+	if vfs.KeepLast <= 0 && vfs.KeepWithin <= 0 {
+		return nil
+	}
+
+	fvs, err := vfs.ListVersions(id)
+	if err != nil {
+		return err
+	}
+
+	before := time.Now().Add(-vfs.KeepWithin)
+
+	var ids []string
+	for i, fv := range fvs {
+		if (vfs.KeepLast > 0 && i < len(fvs)-vfs.KeepLast) || (vfs.KeepWithin > 0 && fv.Time.Before(before)) {
+			ids = append(ids, fv.ID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err = vfs.Store.DeleteFiles(ids...)
+	return err
+}
+
+// Prune delete the versions of all files by the retention policy.
+// It can be registered as a scheduled task to apply the KeepWithin policy to the files which are not updated.
+func (vfs *VersionFS) Prune() error {
+	files, err := vfs.Store.ListPrefix(vfs.Prefix + "/")
+	if err != nil {
+		return err
+	}
+
+	done := map[string]bool{}
+	for _, f := range files {
+		oid := vfs.originalID(f.ID)
+		if done[oid] {
+			continue
+		}
+		done[oid] = true
+
+		unlock := vfs.locks.lock(oid)
+		err := vfs.prune(oid)
+		unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveVersions move the versions of the file src to the versions of the file dst
+func (vfs *VersionFS) moveVersions(src, dst string) error {
+	svs, err := vfs.ListVersions(src)
+	if err != nil || len(svs) == 0 {
+		return err
+	}
+
+	dvs, err := vfs.ListVersions(dst)
+	if err != nil {
+		return err
+	}
+
+	v := 0
+	if len(dvs) > 0 {
+		v = dvs[len(dvs)-1].Version
+	}
+
+	for _, sv := range svs {
+		v++
+		if err := vfs.Store.MoveFile(sv.ID, vfs.VersionID(dst, v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vfs *VersionFS) SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error) {
+	unlock := vfs.locks.lock(id)
+	defer unlock()
+
+	if err := vfs.snapshot(id); err != nil {
+		return nil, err
+	}
+
+	f, err := vfs.XFS.SaveFile(id, filename, filetime, data, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, vfs.prune(id)
+}
+
+func (vfs *VersionFS) SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error) {
+	unlock := vfs.locks.lock(id)
+	defer unlock()
+
+	if err := vfs.snapshot(id); err != nil {
+		return nil, err
+	}
+
+	f, err := vfs.XFS.SaveFileReader(id, filename, filetime, r, tag...)
+	if err != nil {
+		return f, err
+	}
+	return f, vfs.prune(id)
+}
+
+func (vfs *VersionFS) CopyFile(src, dst string, tag ...string) error {
+	unlock := vfs.locks.lock(dst)
+	defer unlock()
+
+	if src != dst {
+		if err := vfs.snapshot(dst); err != nil {
+			return err
+		}
+	}
+	if err := vfs.XFS.CopyFile(src, dst, tag...); err != nil {
+		return err
+	}
+	return vfs.prune(dst)
+}
+
+func (vfs *VersionFS) MoveFile(src, dst string, tag ...string) error {
+	unlock := vfs.locks.lock(src, dst)
+	defer unlock()
+
+	if src == dst {
+		return vfs.XFS.MoveFile(src, dst, tag...)
+	}
+
+	if err := vfs.snapshot(dst); err != nil {
+		return err
+	}
+	if err := vfs.XFS.MoveFile(src, dst, tag...); err != nil {
+		return err
+	}
+	if err := vfs.moveVersions(src, dst); err != nil {
+		return err
+	}
+	return vfs.prune(dst)
+}
+
+func (vfs *VersionFS) DeleteFile(id string) error {
+	unlock := vfs.locks.lock(id)
+	defer unlock()
+
+	return vfs.DerivedFS.DeleteFile(id)
+}
+
+func (vfs *VersionFS) DeleteFiles(ids ...string) (int64, error) {
+	unlock := vfs.locks.lock(ids...)
+	defer unlock()
+
+	return vfs.DerivedFS.DeleteFiles(ids...)
+}
+
+//----------------------------------------------------
+
+// copyXFS copy the file sid of the src XFS to the file did of the dst XFS with the name and tag of the source file,
+// and the file time `filetime`
+func copyXFS(dst XFS, did string, src XFS, sid string, filetime time.Time) (*File, error) {
+	f, err := src.FindFile(sid)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := src.OpenReader(sid)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return dst.SaveFileReader(did, f.Name, filetime, r, f.Tag)
+}
+
+// idLocks the mutexes of the file ids, the mutex is removed when it is not used
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idLock
+}
+
+type idLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock lock the file ids in the sorted order, and returns the function to unlock them
+func (ls *idLocks) lock(ids ...string) func() {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+
+	ms := make([]*idLock, len(ids))
+
+	ls.mu.Lock()
+	if ls.locks == nil {
+		ls.locks = make(map[string]*idLock)
+	}
+	for i, id := range ids {
+		l, ok := ls.locks[id]
+		if !ok {
+			l = &idLock{}
+			ls.locks[id] = l
+		}
+		l.refs++
+		ms[i] = l
+	}
+	ls.mu.Unlock()
+
+	for _, l := range ms {
+		l.Lock()
+	}
+
+	return func() {
+		for _, l := range ms {
+			l.Unlock()
+		}
+
+		ls.mu.Lock()
+		for i, id := range ids {
+			if ms[i].refs--; ms[i].refs == 0 {
+				delete(ls.locks, id)
+			}
+		}
+		ls.mu.Unlock()
+	}
+}
@@ -0,0 +1,157 @@
+package xfs_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+)
+
+func TestVersionFS(t *testing.T) {
+	dfs, sfs := dirxfs.FS(t.TempDir()), dirxfs.FS(t.TempDir())
+	vfs := xfs.NewVersionFS(dfs, sfs, 2)
+
+	for _, s := range []string{"v1", "v2", "v3", "v4"} {
+		if _, err := vfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fvs, err := vfs.ListVersions("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fvs) != 2 || fvs[0].Version != 2 || fvs[1].Version != 3 {
+		t.Fatalf("ListVersions() = %v", fvs)
+	}
+
+	data, err := vfs.ReadVersion("/a.txt", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "v2" {
+		t.Errorf("ReadVersion(2) = %q, want %q", data, "v2")
+	}
+
+	if err := vfs.RevertTo("/a.txt", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = vfs.ReadFile("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "v2" {
+		t.Errorf("ReadFile() = %q, want %q", data, "v2")
+	}
+
+	fvs, _ = vfs.ListVersions("/a.txt")
+	if len(fvs) != 2 || fvs[1].Version != 4 {
+		t.Fatalf("ListVersions() = %v", fvs)
+	}
+	data, _ = vfs.ReadVersion("/a.txt", 4)
+	if string(data) != "v4" {
+		t.Errorf("ReadVersion(4) = %q, want %q", data, "v4")
+	}
+
+	if err := vfs.MoveFile("/a.txt", "/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if fvs, _ := vfs.ListVersions("/a.txt"); len(fvs) != 0 {
+		t.Errorf("ListVersions(a) = %v", fvs)
+	}
+	if fvs, _ := vfs.ListVersions("/b.txt"); len(fvs) != 2 {
+		t.Errorf("ListVersions(b) = %v", fvs)
+	}
+
+	// the versions are not in the original xfs
+	if files, err := dfs.ListPrefix("/"); err != nil || len(files) != 1 || files[0].ID != "/b.txt" {
+		t.Errorf("ListPrefix() = %v, %v", files, err)
+	}
+
+	// the versions of the descendant file are kept
+	for _, s := range []string{"c1", "c2"} {
+		if _, err := vfs.SaveFile("/b.txt/c.txt", "c.txt", time.Now(), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := vfs.DeleteFile("/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if fvs, _ := vfs.ListVersions("/b.txt"); len(fvs) != 0 {
+		t.Errorf("ListVersions(b) = %v", fvs)
+	}
+	if fvs, _ := vfs.ListVersions("/b.txt/c.txt"); len(fvs) != 1 {
+		t.Errorf("ListVersions(c) = %v", fvs)
+	}
+}
+
+func TestVersionFSConcurrent(t *testing.T) {
+	vfs := xfs.NewVersionFS(memxfs.FS(), memxfs.FS(), 0)
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := vfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte(strconv.Itoa(i))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fvs, err := vfs.ListVersions("/a.txt")
+	if err != nil || len(fvs) != 9 || fvs[8].Version != 9 {
+		t.Fatalf("ListVersions() = %v, %v", fvs, err)
+	}
+}
+
+func TestVersionFSKeepWithin(t *testing.T) {
+	vfs := xfs.NewVersionFS(memxfs.FS(), memxfs.FS(), 0)
+	vfs.KeepWithin = time.Hour
+
+	// the versions are pruned by the created time, not the file time
+	old := time.Now().AddDate(-1, 0, 0)
+	for _, s := range []string{"v1", "v2"} {
+		if _, err := vfs.SaveFile("/a.txt", "a.txt", old, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fvs, err := vfs.ListVersions("/a.txt")
+	if err != nil || len(fvs) != 1 || time.Since(fvs[0].Time) > time.Minute {
+		t.Fatalf("ListVersions() = %v, %v", fvs, err)
+	}
+}
+
+func TestVersionFSCrypt(t *testing.T) {
+	dfs, sfs := memxfs.FS(), memxfs.FS()
+
+	key := xfs.SecretKey("k1", "secret")
+	dcfs, _ := xfs.NewCryptFS(dfs, key)
+	scfs, _ := xfs.NewCryptFS(sfs, key)
+
+	vfs := xfs.NewVersionFS(dcfs, scfs, 0)
+	for _, s := range []string{"secret v1", "secret v2"} {
+		if _, err := vfs.SaveFile("/a.txt", "a.txt", time.Now(), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := vfs.ReadVersion("/a.txt", 1); err != nil || string(data) != "secret v1" {
+		t.Errorf("ReadVersion(1) = %q, %v", data, err)
+	}
+
+	// the version is encrypted in the store
+	raw, err := sfs.ReadFile(vfs.VersionID("/a.txt", 1))
+	if err != nil || strings.Contains(string(raw), "secret") {
+		t.Errorf("store.ReadFile() = %q, %v", raw, err)
+	}
+}
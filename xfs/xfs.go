@@ -1,13 +1,33 @@
 package xfs
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"strings"
 	"time"
 )
 
+// XFS is a file system which file ids are the hierarchical paths start with "/".
+// The fs.FS path name "a/b.txt" is the file id "/a/b.txt", see PathID() for details.
 type XFS interface {
 	fs.ReadFileFS
+	fs.ReadDirFS
+	fs.StatFS
+	fs.GlobFS
+
+	// ListPrefix list the files which id starts with the prefix, ordered by id
+	ListPrefix(prefix string) ([]*File, error)
+
+	// CountFiles count the files by the query
+	CountFiles(fq *FileQuery) (int, error)
+
+	// FindFiles find the files by the query
+	FindFiles(fq *FileQuery) ([]*File, error)
+
+	// SumSize sum the size of the files by the query
+	SumSize(fq *FileQuery) (int64, error)
 
 	// FindFile find a file
 	FindFile(id string) (*File, error)
@@ -15,10 +35,22 @@ type XFS interface {
 	// SaveFile save a file
 	SaveFile(id string, filename string, filetime time.Time, data []byte, tag ...string) (*File, error)
 
-	// CopyFile copy file `src` to `dst`
+	// SaveFileReader save a file with the data read from the reader
+	SaveFileReader(id string, filename string, filetime time.Time, r io.Reader, tag ...string) (*File, error)
+
+	// OpenReader open a reader to read the file data, the caller should close the reader
+	OpenReader(id string) (io.ReadCloser, error)
+
+	// ReadFileAt read len(p) bytes of the file data starting at the offset `off`.
+	// It has the same semantics as io.ReaderAt.ReadAt().
+	ReadFileAt(id string, p []byte, off int64) (int, error)
+
+	// CopyFile copy file `src` to `dst`, the existing `dst` file is overwritten.
+	// Only the tag is updated if `src` and `dst` are same.
 	CopyFile(src, dst string, tag ...string) error
 
-	// MoveFile move file `src` to `dst`
+	// MoveFile move file `src` to `dst`, the existing `dst` file is overwritten.
+	// Only the tag is updated if `src` and `dst` are same.
 	MoveFile(src, dst string, tag ...string) error
 
 	// DeleteFile delete file by id
@@ -52,46 +84,152 @@ type XFS interface {
 	Truncate() error
 }
 
+// TrashFS is a XFS with the soft deletion.
+// The Delete* methods (except Truncate) mark the files as deleted (move the files to the trash bin) instead of deleting them.
+// The deleted files are invisible to the XFS methods, and can be restored or permanently deleted (purged).
+type TrashFS interface {
+	XFS
+
+	// CountTrash count the deleted files by the query
+	CountTrash(fq *FileQuery) (int, error)
+
+	// ListTrash find the deleted files by the query
+	ListTrash(fq *FileQuery) ([]*File, error)
+
+	// Restore restore the deleted files by ids
+	Restore(ids ...string) (int64, error)
+
+	// Purge permanently delete the deleted files by ids
+	Purge(ids ...string) (int64, error)
+
+	// PurgeBefore permanently delete the files which are deleted before the time
+	PurgeBefore(before time.Time) (int64, error)
+
+	// PurgeWhere permanently delete the files (including the not deleted files) by customized where filter
+	PurgeWhere(where string, args ...any) (int64, error)
+}
+
+// Unwrapper is implemented by the XFS wrappers (for example CryptFS, CompressFS and QuotaFS)
+// to return the wrapped XFS
+type Unwrapper interface {
+	Unwrap() XFS
+}
+
+// Encoding the content coding metadata of the encoded data, see EncodedSaver
+type Encoding struct {
+	// Codec the content codings applied to the data, see File.Codec
+	Codec string
+
+	// RawSize the size of the original data, it is read after the encoded data is read to EOF
+	RawSize int64
+
+	// MIME the MIME type of the original data
+	MIME string
+}
+
+// EncodedSaver is implemented by the XFS which stores the File.Codec and File.RawSize of the encoded data.
+// The CompressFS and CryptFS save the encoded data by it, so the encoded files are marked by the metadata
+// instead of the content of the stored data.
+type EncodedSaver interface {
+	// SaveEncodedFile save a file with the encoded data read from the reader and the encoding metadata.
+	// The File.Size and File.Hash are the size and hash of the encoded data, and the File.MIME is the enc.MIME.
+	SaveEncodedFile(id string, filename string, filetime time.Time, r io.Reader, enc *Encoding, tag ...string) (*File, error)
+}
+
+// encodedSaver returns the EncodedSaver of the xfs, or a errors.ErrUnsupported error
+func encodedSaver(xfs XFS) (EncodedSaver, error) {
+	if es, ok := xfs.(EncodedSaver); ok {
+		return es, nil
+	}
+	return nil, fmt.Errorf("xfs: %T can not store the encoded file: %w", xfs, errors.ErrUnsupported)
+}
+
+// appendCoding returns the codec with the content coding appended
+func appendCoding(codec, coding string) string {
+	if codec == "" {
+		return coding
+	}
+	return codec + "," + coding
+}
+
+// cutCoding returns the codec without the last content coding, and the last content coding
+func cutCoding(codec string) (string, string) {
+	if i := strings.LastIndexByte(codec, ','); i >= 0 {
+		return codec[:i], codec[i+1:]
+	}
+	return "", codec
+}
+
 //----------------------------------------------------
 
-// FSFile implements fs.File interface
+// FSFileBufferSize the read buffer size of FSFile
+var FSFileBufferSize = 1 << 20
+
+// FSFile implements fs.File, io.Seeker and io.ReaderAt interface.
+// The file data is read by XFS.ReadFileAt() with a buffer of FSFileBufferSize on demand,
+// so a Range request of a large file does not load the entire file data.
 type FSFile struct {
 	XFS  XFS
 	File *File
-	r    *bytes.Reader
+	off  int64  // read offset
+	bof  int64  // buffer offset
+	buf  []byte // read buffer
+}
+
+func (f *FSFile) Close() error {
+	f.buf = nil
+	return nil
 }
 
-func (f *FSFile) open() error {
-	if f.r == nil {
-		data, err := f.XFS.ReadFile(f.File.ID)
-		if err != nil {
-			return err
+func (f *FSFile) Read(p []byte) (int, error) {
+	if f.off >= f.File.Size {
+		return 0, io.EOF
+	}
+
+	if f.off < f.bof || f.off >= f.bof+int64(len(f.buf)) {
+		n := int(min(int64(FSFileBufferSize), f.File.Size-f.off))
+		if cap(f.buf) < n {
+			f.buf = make([]byte, n)
+		}
+
+		n, err := f.XFS.ReadFileAt(f.File.ID, f.buf[:n], f.off)
+		if n == 0 && err != nil {
+			f.buf = f.buf[:0]
+			return 0, err
 		}
 
-		f.File.Data = data
-		f.r = bytes.NewReader(f.File.Data)
+		f.buf, f.bof = f.buf[:n], f.off
 	}
-	return nil
-}
 
-func (f *FSFile) Close() error {
-	return nil
+	n := copy(p, f.buf[f.off-f.bof:])
+	f.off += int64(n)
+	return n, nil
 }
 
-func (f *FSFile) Read(p []byte) (int, error) {
-	err := f.open()
-	if err != nil {
-		return 0, err
+func (f *FSFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fs.ErrInvalid
 	}
-	return f.r.Read(p)
+	return f.XFS.ReadFileAt(f.File.ID, p, off)
 }
 
 func (f *FSFile) Seek(offset int64, whence int) (int64, error) {
-	err := f.open()
-	if err != nil {
-		return 0, err
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.File.Size
+	default:
+		return 0, fs.ErrInvalid
 	}
-	return f.r.Seek(offset, whence)
+
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	f.off = offset
+	return offset, nil
 }
 
 func (f *FSFile) Readdir(count int) ([]fs.FileInfo, error) {
@@ -0,0 +1,523 @@
+package xfstest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/askasoft/pango/xin/taglib/args"
+	"github.com/askasoft/pangox/xfs"
+)
+
+// TestXFS test the behaviors of the xfs.XFS implementation.
+// The xfs should be empty before the test.
+// CopyFile/MoveFile should overwrite the existing dst file, and only update the tag if the src and dst are same.
+func TestXFS(t *testing.T, x xfs.XFS) {
+	t.Run("SaveFind", func(t *testing.T) { testSaveFind(t, x) })
+	t.Run("CopyMove", func(t *testing.T) { testCopyMove(t, x) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, x) })
+	t.Run("Stream", func(t *testing.T) { testStream(t, x) })
+	t.Run("Dir", func(t *testing.T) { testDir(t, x) })
+	t.Run("Query", func(t *testing.T) { testQuery(t, x) })
+
+	// the EncodedSaver should store the encoding metadata
+	if es, ok := x.(xfs.EncodedSaver); ok {
+		t.Run("Encoded", func(t *testing.T) { testEncoded(t, x, es) })
+	}
+}
+
+var (
+	t1 = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 = time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	t3 = time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+)
+
+func mustSave(t *testing.T, xfs xfs.XFS, id, name string, tm time.Time, data string, tag ...string) {
+	t.Helper()
+
+	if _, err := xfs.SaveFile(id, name, tm, []byte(data), tag...); err != nil {
+		t.Fatalf("SaveFile(%q) = %v", id, err)
+	}
+}
+
+func assertFile(t *testing.T, xfs xfs.XFS, id, name, ext, tag string, tm time.Time, data string) {
+	t.Helper()
+
+	h := hashData(data)
+
+	f, err := xfs.FindFile(id)
+	if err != nil {
+		t.Fatalf("FindFile(%q) = %v", id, err)
+	}
+
+	if f.ID != id || f.Name != name || f.Ext != ext || f.Tag != tag || f.Size != int64(len(data)) || !f.Time.Equal(tm) {
+		t.Errorf("FindFile(%q) = %v", id, f)
+	}
+	if f.Hash != h {
+		t.Errorf("FindFile(%q).Hash = %q, want %q", id, f.Hash, h)
+	}
+
+	bs, err := xfs.ReadFile(id)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) = %v", id, err)
+	}
+	if string(bs) != data {
+		t.Errorf("ReadFile(%q) = %q, want %q", id, bs, data)
+	}
+}
+
+func hashData(data string) string {
+	return xfs.HashData([]byte(data))
+}
+
+func assertNotExist(t *testing.T, xfs xfs.XFS, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		if _, err := xfs.FindFile(id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("FindFile(%q) = %v, want %v", id, err, fs.ErrNotExist)
+		}
+		if _, err := xfs.ReadFile(id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("ReadFile(%q) = %v, want %v", id, err, fs.ErrNotExist)
+		}
+	}
+}
+
+func assertDeleted(t *testing.T, name string, cnt int64, err error, want int64) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s() = %v", name, err)
+	}
+	if cnt != want {
+		t.Errorf("%s() = %d, want %d", name, cnt, want)
+	}
+}
+
+func testSaveFind(t *testing.T, xfs xfs.XFS) {
+	defer xfs.DeleteAll() //nolint: errcheck
+
+	assertNotExist(t, xfs, "/a/none.txt")
+
+	mustSave(t, xfs, "/a/1/Test.TXT", "/tmp/Test.TXT", t1, "hello")
+	assertFile(t, xfs, "/a/1/Test.TXT", "Test.TXT", ".txt", "", t1, "hello")
+
+	// overwrite
+	mustSave(t, xfs, "/a/1/Test.TXT", "Test.TXT", t2, "hello world", "x")
+	assertFile(t, xfs, "/a/1/Test.TXT", "Test.TXT", ".txt", "x", t2, "hello world")
+
+	// fs.FS
+	f, err := xfs.Open("a/1/Test.TXT")
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat() = %v", err)
+	}
+	if fi.Name() != "Test.TXT" || fi.Size() != 11 || fi.IsDir() {
+		t.Errorf("Stat() = %v %d", fi.Name(), fi.Size())
+	}
+
+	if s, ok := f.(io.Seeker); ok {
+		if _, err := s.Seek(6, io.SeekStart); err != nil {
+			t.Fatalf("Seek() = %v", err)
+		}
+	}
+
+	bs, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if !bytes.HasSuffix([]byte("hello world"), bs) {
+		t.Errorf("Read() = %q", bs)
+	}
+
+	if _, err := xfs.Open("a/none.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func testCopyMove(t *testing.T, xfs xfs.XFS) {
+	defer xfs.DeleteAll() //nolint: errcheck
+
+	mustSave(t, xfs, "/c/src.txt", "src.txt", t1, "copy", "s")
+
+	if err := xfs.CopyFile("/c/src.txt", "/c/dst1.txt"); err != nil {
+		t.Fatalf("CopyFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/dst1.txt", "src.txt", ".txt", "s", t1, "copy")
+	assertFile(t, xfs, "/c/src.txt", "src.txt", ".txt", "s", t1, "copy")
+
+	if err := xfs.CopyFile("/c/src.txt", "/c/dst2.txt", "d"); err != nil {
+		t.Fatalf("CopyFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/dst2.txt", "src.txt", ".txt", "d", t1, "copy")
+
+	if err := xfs.MoveFile("/c/dst2.txt", "/c/dst3.txt", "m"); err != nil {
+		t.Fatalf("MoveFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/dst3.txt", "src.txt", ".txt", "m", t1, "copy")
+	assertNotExist(t, xfs, "/c/dst2.txt")
+
+	// overwrite the existing dst file
+	mustSave(t, xfs, "/c/dst4.txt", "dst4.txt", t2, "old4")
+	if err := xfs.CopyFile("/c/src.txt", "/c/dst4.txt"); err != nil {
+		t.Fatalf("CopyFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/dst4.txt", "src.txt", ".txt", "s", t1, "copy")
+
+	mustSave(t, xfs, "/c/dst5.txt", "dst5.txt", t2, "old5")
+	if err := xfs.MoveFile("/c/dst4.txt", "/c/dst5.txt"); err != nil {
+		t.Fatalf("MoveFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/dst5.txt", "src.txt", ".txt", "s", t1, "copy")
+	assertNotExist(t, xfs, "/c/dst4.txt")
+
+	// the same src and dst only update the tag
+	if err := xfs.CopyFile("/c/src.txt", "/c/src.txt", "x"); err != nil {
+		t.Fatalf("CopyFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/src.txt", "src.txt", ".txt", "x", t1, "copy")
+
+	if err := xfs.MoveFile("/c/src.txt", "/c/src.txt"); err != nil {
+		t.Fatalf("MoveFile() = %v", err)
+	}
+	assertFile(t, xfs, "/c/src.txt", "src.txt", ".txt", "x", t1, "copy")
+
+	// the missing src does not delete the dst file
+	if err := xfs.CopyFile("/c/none.txt", "/c/dst5.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CopyFile() = %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := xfs.MoveFile("/c/none.txt", "/c/dst5.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("MoveFile() = %v, want %v", err, fs.ErrNotExist)
+	}
+	assertFile(t, xfs, "/c/dst5.txt", "src.txt", ".txt", "s", t1, "copy")
+
+	if err := xfs.CopyFile("/c/none.txt", "/c/dst4.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CopyFile() = %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := xfs.MoveFile("/c/none.txt", "/c/dst4.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("MoveFile() = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func assertEncoding(t *testing.T, x xfs.XFS, id, codec string, raw int64) {
+	t.Helper()
+
+	f, err := x.FindFile(id)
+	if err != nil {
+		t.Fatalf("FindFile(%q) = %v", id, err)
+	}
+	if f.Codec != codec || f.RawSize != raw {
+		t.Errorf("FindFile(%q) = %q %d, want %q %d", id, f.Codec, f.RawSize, codec, raw)
+	}
+
+	files, err := x.ListPrefix(id)
+	if err != nil || len(files) != 1 || files[0].Codec != codec || files[0].RawSize != raw {
+		t.Errorf("ListPrefix(%q) = %v, %v", id, files, err)
+	}
+}
+
+func testEncoded(t *testing.T, x xfs.XFS, es xfs.EncodedSaver) {
+	defer x.DeleteAll() //nolint: errcheck
+
+	enc := &xfs.Encoding{Codec: "gzip", RawSize: 100, MIME: "text/csv"}
+	f, err := es.SaveEncodedFile("/e/a.csv", "a.csv", t1, bytes.NewReader([]byte("encoded")), enc, "e")
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("SaveEncodedFile() = %v", err)
+	}
+	if err != nil {
+		t.Fatalf("SaveEncodedFile() = %v", err)
+	}
+	if f.Codec != "gzip" || f.RawSize != 100 || f.MIME != "text/csv" || f.Size != 7 || f.Hash != hashData("encoded") {
+		t.Errorf("SaveEncodedFile() = %v", f)
+	}
+	assertFile(t, x, "/e/a.csv", "a.csv", ".csv", "e", t1, "encoded")
+	assertEncoding(t, x, "/e/a.csv", "gzip", 100)
+
+	if err := x.CopyFile("/e/a.csv", "/e/b.csv"); err != nil {
+		t.Fatalf("CopyFile() = %v", err)
+	}
+	assertEncoding(t, x, "/e/b.csv", "gzip", 100)
+
+	if err := x.MoveFile("/e/b.csv", "/e/c.csv", "m"); err != nil {
+		t.Fatalf("MoveFile() = %v", err)
+	}
+	assertEncoding(t, x, "/e/c.csv", "gzip", 100)
+
+	// the plain data clears the encoding metadata
+	mustSave(t, x, "/e/a.csv", "a.csv", t2, "plain")
+	assertEncoding(t, x, "/e/a.csv", "", 0)
+
+	if _, err := x.SaveFileReader("/e/c.csv", "c.csv", t2, bytes.NewReader([]byte("plain"))); err != nil {
+		t.Fatalf("SaveFileReader() = %v", err)
+	}
+	assertEncoding(t, x, "/e/c.csv", "", 0)
+}
+
+func testDelete(t *testing.T, xfs xfs.XFS) {
+	defer xfs.DeleteAll() //nolint: errcheck
+
+	mustSave(t, xfs, "/d/a/1.txt", "1.txt", t1, "1", "a")
+	mustSave(t, xfs, "/d/a/2.txt", "2.txt", t2, "2", "a")
+	mustSave(t, xfs, "/d/a/3.txt", "3.txt", t3, "3", "b")
+	mustSave(t, xfs, "/d/b/4.txt", "4.txt", t1, "4", "b")
+	mustSave(t, xfs, "/d/b/5.txt", "5.txt", t2, "5", "c")
+	mustSave(t, xfs, "/d/b/6.txt", "6.txt", t3, "6", "c")
+	mustSave(t, xfs, "/d/c/7.txt", "7.txt", t1, "7")
+	mustSave(t, xfs, "/d/c/8.txt", "8.txt", t2, "8")
+	mustSave(t, xfs, "/d/c/9.txt", "9.txt", t3, "9")
+
+	if err := xfs.DeleteFile("/d/a/1.txt"); err != nil {
+		t.Fatalf("DeleteFile() = %v", err)
+	}
+	assertNotExist(t, xfs, "/d/a/1.txt")
+
+	cnt, err := xfs.DeleteFiles("/d/a/1.txt", "/d/c/7.txt")
+	assertDeleted(t, "DeleteFiles", cnt, err, 1)
+
+	cnt, err = xfs.DeleteTaggedBefore("a", t3)
+	assertDeleted(t, "DeleteTaggedBefore", cnt, err, 1)
+	assertNotExist(t, xfs, "/d/a/2.txt")
+
+	cnt, err = xfs.DeletePrefixBefore("/d/b/", t2)
+	assertDeleted(t, "DeletePrefixBefore", cnt, err, 1)
+	assertNotExist(t, xfs, "/d/b/4.txt")
+
+	cnt, err = xfs.DeleteTagged("c")
+	assertDeleted(t, "DeleteTagged", cnt, err, 2)
+	assertNotExist(t, xfs, "/d/b/5.txt", "/d/b/6.txt")
+
+	cnt, err = xfs.DeleteBefore(t3)
+	assertDeleted(t, "DeleteBefore", cnt, err, 1)
+	assertNotExist(t, xfs, "/d/c/8.txt")
+
+	cnt, err = xfs.DeletePrefix("/d/c/")
+	assertDeleted(t, "DeletePrefix", cnt, err, 1)
+	assertNotExist(t, xfs, "/d/c/9.txt")
+
+	cnt, err = xfs.DeleteAll()
+	assertDeleted(t, "DeleteAll", cnt, err, 1)
+	assertNotExist(t, xfs, "/d/a/3.txt")
+}
+
+func testStream(t *testing.T, xfs xfs.XFS) {
+	defer xfs.DeleteAll() //nolint: errcheck
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	f, err := xfs.SaveFileReader("/s/big.bin", "big.bin", t1, bytes.NewReader(data), "s")
+	if err != nil {
+		t.Fatalf("SaveFileReader() = %v", err)
+	}
+	if f.Size != int64(len(data)) {
+		t.Errorf("SaveFileReader().Size = %d, want %d", f.Size, len(data))
+	}
+	assertFile(t, xfs, "/s/big.bin", "big.bin", ".bin", "s", t1, string(data))
+
+	r, err := xfs.OpenReader("/s/big.bin")
+	if err != nil {
+		t.Fatalf("OpenReader() = %v", err)
+	}
+	bs, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(bs, data) {
+		t.Errorf("OpenReader() = %d, %v", len(bs), err)
+	}
+
+	p := make([]byte, 10)
+	if n, err := xfs.ReadFileAt("/s/big.bin", p, 9995); n != 5 || !errors.Is(err, io.EOF) || string(p[:n]) != "56789" {
+		t.Errorf("ReadFileAt(9995) = %d, %v, %q", n, err, p[:n])
+	}
+	if n, err := xfs.ReadFileAt("/s/big.bin", p, 1234); n != 10 || err != nil || string(p) != "4567890123" {
+		t.Errorf("ReadFileAt(1234) = %d, %v, %q", n, err, p)
+	}
+	if _, err := xfs.ReadFileAt("/s/none.bin", p, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFileAt() = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := xfs.OpenReader("/s/none.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenReader() = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	fsf, err := xfs.Open("s/big.bin")
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer fsf.Close()
+
+	if s, ok := fsf.(io.ReadSeeker); ok {
+		if _, err := s.Seek(-15, io.SeekEnd); err != nil {
+			t.Fatalf("Seek() = %v", err)
+		}
+		bs, err := io.ReadAll(s)
+		if err != nil || string(bs) != "567890123456789" {
+			t.Errorf("Seek().Read() = %q, %v", bs, err)
+		}
+	}
+}
+
+// dirFS hides the ReadFile() of the XFS,
+// and rejects the file ids "/a/b.txt" which are accepted by the XFS but are invalid fs.FS path names.
+type dirFS struct {
+	x xfs.XFS
+}
+
+func (d dirFS) valid(op, name string) error {
+	if fs.ValidPath(name) {
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	if err := d.valid("open", name); err != nil {
+		return nil, err
+	}
+	return d.x.Open(name)
+}
+
+func (d dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := d.valid("readdir", name); err != nil {
+		return nil, err
+	}
+	return d.x.ReadDir(name)
+}
+
+func (d dirFS) Stat(name string) (fs.FileInfo, error) {
+	if err := d.valid("stat", name); err != nil {
+		return nil, err
+	}
+	return d.x.Stat(name)
+}
+
+func (d dirFS) Glob(pattern string) ([]string, error) { return d.x.Glob(pattern) }
+
+func testDir(t *testing.T, xfs xfs.XFS) {
+	defer xfs.DeleteAll() //nolint: errcheck
+
+	mustSave(t, xfs, "/p/2024/0102/1/a.txt", "a.txt", t1, "a")
+	mustSave(t, xfs, "/p/2024/0102/2/b.pdf", "b.pdf", t2, "bb")
+	mustSave(t, xfs, "/p/2024/0103/3/c.txt", "c.txt", t3, "ccc")
+	mustSave(t, xfs, "/q/d.txt", "d.txt", t1, "dddd")
+
+	// XFS.ReadFile() accepts the file id "/a/b.txt", so test without fs.ReadFileFS
+	if err := fstest.TestFS(dirFS{xfs}, "p/2024/0102/1/a.txt", "p/2024/0102/2/b.pdf", "p/2024/0103/3/c.txt", "q/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	des, err := fs.ReadDir(xfs, "p/2024")
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	if len(des) != 2 || des[0].Name() != "0102" || !des[0].IsDir() || des[1].Name() != "0103" {
+		t.Errorf("ReadDir() = %v", des)
+	}
+	if _, err := fs.ReadDir(xfs, "p/none"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir() = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	fi, err := fs.Stat(xfs, "p/2024/0102/2/b.pdf")
+	if err != nil || fi.Size() != 2 || fi.IsDir() || fi.Name() != "b.pdf" {
+		t.Errorf("Stat() = %v, %v", fi, err)
+	}
+	fi, err = fs.Stat(xfs, "p/2024/0102")
+	if err != nil || !fi.IsDir() || !fi.ModTime().Equal(t2) {
+		t.Errorf("Stat() = %v, %v", fi, err)
+	}
+	if _, err := fs.Stat(xfs, "p/none"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// the file id is accepted
+	if f, err := xfs.Open("/q/d.txt"); err != nil {
+		t.Errorf("Open() = %v", err)
+	} else {
+		f.Close()
+	}
+	if fi, err := xfs.Stat("/p/2024/0103"); err != nil || !fi.IsDir() || fi.Name() != "0103" || !fi.ModTime().Equal(t3) {
+		t.Errorf("Stat() = %v, %v", fi, err)
+	}
+
+	ms, err := fs.Glob(xfs, "p/*/*/*/*.txt")
+	if err != nil || len(ms) != 2 || ms[0] != "p/2024/0102/1/a.txt" || ms[1] != "p/2024/0103/3/c.txt" {
+		t.Errorf("Glob() = %v, %v", ms, err)
+	}
+
+	var size int64
+	err = fs.WalkDir(xfs, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil || size != 10 {
+		t.Errorf("WalkDir() = %d, %v", size, err)
+	}
+}
+
+func testQuery(t *testing.T, x xfs.XFS) {
+	defer x.DeleteAll() //nolint: errcheck
+
+	mustSave(t, x, "/q/1.txt", "1.txt", t1, "1", "a")
+	mustSave(t, x, "/q/2.pdf", "2.pdf", t2, "22", "a")
+	mustSave(t, x, "/q/3.txt", "3.txt", t3, "333", "b")
+	mustSave(t, x, "/r/4.txt", "4.txt", t2, "4444", "a")
+	mustSave(t, x, "/qx/5.TXT", "5.TXT", t1, "5", "c")
+
+	cs := []struct {
+		fq   xfs.FileQuery
+		ids  []string
+		size int64
+	}{
+		{xfs.FileQuery{}, []string{"/q/1.txt", "/q/2.pdf", "/q/3.txt", "/qx/5.TXT", "/r/4.txt"}, 11},
+		{xfs.FileQuery{Prefix: "/q/"}, []string{"/q/1.txt", "/q/2.pdf", "/q/3.txt"}, 6},
+		{xfs.FileQuery{Prefix: "/q_"}, []string{}, 0}, // the LIKE wildcard is escaped
+		{xfs.FileQuery{Tag: "a", Exts: []string{".txt"}}, []string{"/q/1.txt", "/r/4.txt"}, 5},
+		{xfs.FileQuery{Exts: []string{".txt"}}, []string{"/q/1.txt", "/q/3.txt", "/qx/5.TXT", "/r/4.txt"}, 9}, // the ext is lower case
+		{xfs.FileQuery{TimeMin: t2, TimeMax: t2}, []string{"/q/2.pdf", "/r/4.txt"}, 6},
+		{xfs.FileQuery{TimeMin: t3}, []string{"/q/3.txt"}, 3},
+		{xfs.FileQuery{LastID: "/q/3.txt"}, []string{"/qx/5.TXT", "/r/4.txt"}, 5},
+		{xfs.FileQuery{Orders: args.Orders{Order: "-size"}, Pager: args.Pager{Page: 2, Limit: 2}}, []string{"/q/2.pdf", "/q/1.txt"}, 11},
+		{xfs.FileQuery{Orders: args.Orders{Order: "time,-id"}, Pager: args.Pager{Limit: 3}}, []string{"/qx/5.TXT", "/q/1.txt", "/r/4.txt"}, 11},
+	}
+
+	for i, c := range cs {
+		files, err := x.FindFiles(&c.fq)
+		if err != nil {
+			t.Fatalf("#%d FindFiles() = %v", i, err)
+		}
+
+		ids := make([]string, len(files))
+		for j, f := range files {
+			ids[j] = f.ID
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(c.ids) {
+			t.Errorf("#%d FindFiles() = %v, want %v", i, ids, c.ids)
+		}
+
+		if c.fq.Limit == 0 {
+			if cnt, err := x.CountFiles(&c.fq); err != nil || cnt != len(c.ids) {
+				t.Errorf("#%d CountFiles() = %d, %v, want %d", i, cnt, err, len(c.ids))
+			}
+		}
+
+		if size, err := x.SumSize(&c.fq); err != nil || size != c.size {
+			t.Errorf("#%d SumSize() = %d, %v, want %d", i, size, err, c.size)
+		}
+	}
+}
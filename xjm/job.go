@@ -41,6 +41,8 @@ type Job struct {
 	CID       int64     `gorm:"column:cid;not null" json:"cid,omitempty"`
 	RID       int64     `gorm:"column:rid;not null" json:"rid,omitempty"`
 	Name      string    `gorm:"size:250;not null;index:idx_jobs_name" json:"name,omitempty"`
+	UID       int64     `gorm:"column:uid;not null;default:0" json:"uid,omitempty"`
+	CIP       string    `gorm:"column:cip;size:64;not null;default:''" json:"cip,omitempty"`
 	Status    string    `gorm:"size:1;not null" json:"status,omitempty"`
 	Locale    string    `gorm:"size:20;not null" json:"locale,omitempty"`
 	Param     string    `gorm:"not null" json:"param,omitempty"`
@@ -73,15 +73,25 @@ func (jw *JobLogWriter) flush(eb *log.EventBuffer) error {
 type JobBridgeLogger struct {
 	job    *Job
 	logger log.Logger
+	tenant string
 }
 
 func NewJobBridgeLogger(job *Job, logger log.Logger) *JobBridgeLogger {
-	return &JobBridgeLogger{job, logger}
+	return &JobBridgeLogger{job: job, logger: logger}
+}
+
+// NewTenantJobBridgeLogger create a JobBridgeLogger which prefixes the tenant to the job name
+func NewTenantJobBridgeLogger(tenant string, job *Job, logger log.Logger) *JobBridgeLogger {
+	return &JobBridgeLogger{job: job, logger: logger, tenant: tenant}
 }
 
 func (jbl *JobBridgeLogger) Write(le *log.Event) {
 	jle := *le
-	jle.Message = fmt.Sprintf("job %s#%d: %s", jbl.job.Name, jbl.job.ID, le.Message)
+	if jbl.tenant == "" {
+		jle.Message = fmt.Sprintf("job %s#%d: %s", jbl.job.Name, jbl.job.ID, le.Message)
+	} else {
+		jle.Message = fmt.Sprintf("job %s/%s#%d: %s", jbl.tenant, jbl.job.Name, jbl.job.ID, le.Message)
+	}
 	jbl.logger.Write(&jle)
 }
 
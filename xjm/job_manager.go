@@ -85,3 +85,24 @@ type JobManager interface {
 	// CleanOutdatedJobs delete outdated jobs
 	CleanOutdatedJobs(before time.Time) (int64, int64, error)
 }
+
+// JobAppendListener is called after a pending job is appended
+type JobAppendListener func(job *Job)
+
+// JobAppendNotifier is implemented by the JobManager which notifies the listener after a pending job is appended by AppendJob()
+type JobAppendNotifier interface {
+	// OnJobAppended set the listener to be called after a pending job is appended,
+	// it should be called before the JobManager is used.
+	OnJobAppended(listener JobAppendListener)
+}
+
+// JobLogPartitioner is implemented by the JobManager which stores the job logs in time partitions
+type JobLogPartitioner interface {
+	// CreateJobLogPartitions create the default job log partition and the job log partitions from the current month until the time
+	CreateJobLogPartitions(until time.Time) error
+
+	// DropJobLogPartitions drop the job log partitions before the month of the time,
+	// the partition which contains the logs of the not outdated jobs and the partitions after it are kept.
+	// returns the estimated count of the dropped logs
+	DropJobLogPartitions(before time.Time) (int64, error)
+}
@@ -12,21 +12,28 @@ type JobRunner struct {
 	xjm JobManager
 	jlw *JobLogWriter
 	log *log.Log
+	tnt string
 }
 
 // NewJobRunner create a JobRunner
 func NewJobRunner(job *Job, xjm JobManager, logger ...log.Logger) *JobRunner {
+	return NewTenantJobRunner("", job, xjm, logger...)
+}
+
+// NewTenantJobRunner create a JobRunner of the tenant's job
+func NewTenantJobRunner(tenant string, job *Job, xjm JobManager, logger ...log.Logger) *JobRunner {
 	jr := &JobRunner{
 		job: job,
 		xjm: xjm,
 		log: log.Clone(),
+		tnt: tenant,
 	}
 
 	jr.jlw = NewJobLogWriter(xjm, job.ID)
 
 	var lw log.Writer = jr.jlw
 	if len(logger) > 0 {
-		lw = log.NewMultiWriter(jr.jlw, NewJobBridgeLogger(job, logger[0]))
+		lw = log.NewMultiWriter(jr.jlw, NewTenantJobBridgeLogger(tenant, job, logger[0]))
 	}
 
 	jr.log.SetWriter(log.NewAsyncWriter(lw, 100))
@@ -45,6 +52,10 @@ func (jr *JobRunner) JobLogWriter() *JobLogWriter {
 	return jr.jlw
 }
 
+func (jr *JobRunner) Tenant() string {
+	return jr.tnt
+}
+
 func (jr *JobRunner) JobID() int64 {
 	return jr.job.ID
 }
@@ -0,0 +1,68 @@
+package xjm
+
+import (
+	"time"
+)
+
+// JobActor the user who operates the job
+type JobActor struct {
+	UID int64  `json:"uid,omitempty"` // user id
+	CIP string `json:"cip,omitempty"` // client ip
+}
+
+// JobEvent a audit record of the job status transition
+type JobEvent struct {
+	ID     int64     `gorm:"not null;primaryKey;autoIncrement" json:"id,omitempty"`
+	JID    int64     `gorm:"column:jid;not null;index:idx_job_events_jid" json:"jid,omitempty"`
+	Status string    `gorm:"size:1;not null" json:"status,omitempty"`
+	UID    int64     `gorm:"column:uid;not null" json:"uid,omitempty"`
+	CIP    string    `gorm:"column:cip;size:64;not null" json:"cip,omitempty"`
+	Reason string    `gorm:"not null" json:"reason,omitempty"`
+	Time   time.Time `gorm:"not null" json:"time,omitempty"`
+}
+
+func (je *JobEvent) String() string {
+	return toString(je)
+}
+
+// JobAuditor is implemented by the JobManager which records the job creator and the job status transition events
+type JobAuditor interface {
+	// AppendJobBy append a pendding job created by the actor
+	AppendJobBy(actor *JobActor, cid int64, name, locale, param string) (int64, error)
+
+	// AbortJobBy abort the job by the actor
+	AbortJobBy(actor *JobActor, jid int64, reason string) error
+
+	// CancelJobBy cancel the job by the actor
+	CancelJobBy(actor *JobActor, jid int64, reason string) error
+
+	// GetJobEvents get the status transition events of the job
+	GetJobEvents(jid int64) ([]*JobEvent, error)
+}
+
+// AppendJobBy append a pendding job created by the actor,
+// the actor is ignored if the JobManager does not implement JobAuditor.
+func AppendJobBy(jmr JobManager, actor *JobActor, cid int64, name, locale, param string) (int64, error) {
+	if ja, ok := jmr.(JobAuditor); ok && actor != nil {
+		return ja.AppendJobBy(actor, cid, name, locale, param)
+	}
+	return jmr.AppendJob(cid, name, locale, param)
+}
+
+// AbortJobBy abort the job by the actor,
+// the actor is ignored if the JobManager does not implement JobAuditor.
+func AbortJobBy(jmr JobManager, actor *JobActor, jid int64, reason string) error {
+	if ja, ok := jmr.(JobAuditor); ok && actor != nil {
+		return ja.AbortJobBy(actor, jid, reason)
+	}
+	return jmr.AbortJob(jid, reason)
+}
+
+// CancelJobBy cancel the job by the actor,
+// the actor is ignored if the JobManager does not implement JobAuditor.
+func CancelJobBy(jmr JobManager, actor *JobActor, jid int64, reason string) error {
+	if ja, ok := jmr.(JobAuditor); ok && actor != nil {
+		return ja.CancelJobBy(actor, jid, reason)
+	}
+	return jmr.CancelJob(jid, reason)
+}
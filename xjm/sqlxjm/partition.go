@@ -0,0 +1,188 @@
+package sqlxjm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pango/sqx"
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pangox/xjm"
+)
+
+// PartitionedJM create a sqlx job manager which stores the job logs in a monthly partitioned table (PostgreSQL only).
+// The log table must be created as a range partitioned table by the "time" column, for example:
+//
+//	CREATE TABLE job_logs (
+//		id      BIGSERIAL NOT NULL,
+//		jid     BIGINT NOT NULL,
+//		time    TIMESTAMPTZ NOT NULL,
+//		level   VARCHAR(1) NOT NULL,
+//		message TEXT NOT NULL,
+//		PRIMARY KEY (id, time)
+//	) PARTITION BY RANGE (time);
+//	CREATE INDEX idx_job_logs_jid ON job_logs (jid);
+//
+// The monthly partitions "job_logs_pYYYYMM" and the default partition "job_logs_default" are created by CreateJobLogPartitions(),
+// CleanOutdatedJobs() creates the partitions of the next JobLogPartitionMonths months,
+// and drops the partitions which only contain the logs of the outdated jobs before the outdated time.
+// The partition which contains the logs of a not outdated job and the default partition are kept, the logs of the outdated jobs in them are deleted.
+// NOTE: a monthly partition can not be created if the default partition contains the logs of the month,
+// so CleanOutdatedJobs() should be scheduled at least once a month.
+func PartitionedJM(db sqlx.Sqlx, jobTable, logTable string, eventTable ...string) xjm.JobManager {
+	sjm := JM(db, jobTable, logTable, eventTable...).(*sjm)
+	sjm.lp = true
+	return sjm
+}
+
+// JobLogPartitionMonths the count of the next months to create the job log partitions by CleanOutdatedJobs()
+var JobLogPartitionMonths = 2
+
+const jobLogPartitionFormat = "_p200601"
+
+// JobLogPartitionName returns the monthly partition name "{logTable}_pYYYYMM" of the time
+func JobLogPartitionName(logTable string, t time.Time) string {
+	return logTable + t.UTC().Format(jobLogPartitionFormat)
+}
+
+// JobLogDefaultPartitionName returns the default partition name "{logTable}_default"
+func JobLogDefaultPartitionName(logTable string) string {
+	return logTable + "_default"
+}
+
+// parseJobLogPartition returns the month of the monthly partition name "{logTable}_pYYYYMM"
+func parseJobLogPartition(logTable, name string) (time.Time, bool) {
+	sfx, ok := strings.CutPrefix(name, logTable)
+	if !ok || len(sfx) != len(jobLogPartitionFormat) {
+		return time.Time{}, false
+	}
+
+	m, err := time.Parse(jobLogPartitionFormat, sfx)
+	return m, err == nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (sjm *sjm) CreateJobLogPartitions(until time.Time) error {
+	if !sjm.lp {
+		return nil
+	}
+
+	sql := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s DEFAULT",
+		sjm.db.Quote(JobLogDefaultPartitionName(sjm.lt)),
+		sjm.db.Quote(sjm.lt),
+	)
+	if _, err := sjm.db.Exec(sql); err != nil {
+		return err
+	}
+
+	for m := monthStart(time.Now()); !m.After(until); m = m.AddDate(0, 1, 0) {
+		sql := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			sjm.db.Quote(JobLogPartitionName(sjm.lt, m)),
+			sjm.db.Quote(sjm.lt),
+			m.Format(time.RFC3339),
+			m.AddDate(0, 1, 0).Format(time.RFC3339),
+		)
+
+		if _, err := sjm.db.Exec(sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sjm *sjm) DropJobLogPartitions(before time.Time) (int64, error) {
+	logs, _, err := sjm.dropJobLogPartitions(before)
+	return logs, err
+}
+
+// dropJobLogPartitions drop the job log partitions before the month of `before`
+// which do not contain the logs of the not outdated jobs.
+// returns the estimated count of the dropped logs, and the start time of the first kept partition
+// (the logs before it are dropped).
+func (sjm *sjm) dropJobLogPartitions(before time.Time) (logs int64, kept time.Time, err error) {
+	kept = monthStart(before)
+	if !sjm.lp {
+		kept = time.Time{}
+		return
+	}
+
+	var pts []string
+	if pts, err = sjm.findJobLogPartitions(); err != nil {
+		return
+	}
+
+	schema := ""
+	if i := str.LastIndexByte(sjm.lt, '.'); i >= 0 {
+		schema = sjm.lt[:i+1]
+	}
+
+	// partition "_pYYYYMM" names are sorted, drop the partitions before the month of `before`
+	for _, pt := range pts {
+		pt = schema + pt
+
+		m, ok := parseJobLogPartition(sjm.lt, pt)
+		if !ok || !m.Before(kept) {
+			continue
+		}
+
+		var active bool
+		if active, err = sjm.hasActiveJobLogs(pt, before); err != nil {
+			return
+		}
+		if active {
+			kept = m
+			break
+		}
+
+		var cnt int64
+		if cnt, err = sjm.estimateRows(pt); err != nil {
+			return
+		}
+
+		if _, err = sjm.db.Exec("DROP TABLE " + sjm.db.Quote(pt)); err != nil {
+			return
+		}
+		logs += cnt
+	}
+	return
+}
+
+// hasActiveJobLogs returns true if the partition contains the logs of the jobs which are not outdated before the time
+func (sjm *sjm) hasActiveJobLogs(partition string, before time.Time) (bool, error) {
+	sqj := sjm.db.Builder()
+	sqj.Select("id").From(sjm.jt)
+	done, args := sqx.In("status", xjm.JobDoneStatus)
+	sqj.Where("(NOT "+done+" OR updated_at >= ?)", append(args, before)...)
+
+	sqb := sjm.db.Builder()
+	sqb.Select("jid").From(sjm.db.Quote(partition))
+	sqb.Where("jid IN ("+sqj.SQL()+")", sqj.Params()...)
+	sqb.Limit(1)
+	sql, args := sqb.Build()
+
+	var jids []int64
+	if err := sjm.db.Select(&jids, sql, args...); err != nil {
+		return false, err
+	}
+	return len(jids) > 0, nil
+}
+
+func (sjm *sjm) findJobLogPartitions() (pts []string, err error) {
+	sql := sjm.db.Rebind("SELECT c.relname FROM pg_catalog.pg_inherits i JOIN pg_catalog.pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = ?::regclass ORDER BY c.relname")
+	err = sjm.db.Select(&pts, sql, sjm.lt)
+	return
+}
+
+// estimateRows returns the estimated row count of the table from the planner statistics
+func (sjm *sjm) estimateRows(table string) (cnt int64, err error) {
+	sql := sjm.db.Rebind("SELECT GREATEST(reltuples, 0)::BIGINT FROM pg_catalog.pg_class WHERE oid = ?::regclass")
+	err = sjm.db.Get(&cnt, sql, table)
+	return
+}
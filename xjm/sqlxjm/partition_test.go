@@ -0,0 +1,149 @@
+package sqlxjm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xjm"
+)
+
+func TestParseJobLogPartition(t *testing.T) {
+	cs := []struct {
+		lt   string
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"job_logs", "job_logs_p202401", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"s.job_logs", "s.job_logs_p202412", time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), true},
+		{"job_logs", "job_logs_default", time.Time{}, false},
+		{"job_logs", "job_logs_p2024011", time.Time{}, false},
+		{"job_logs", "jobs_logs_p202401", time.Time{}, false},
+	}
+
+	for i, c := range cs {
+		m, ok := parseJobLogPartition(c.lt, c.name)
+		if ok != c.ok || !m.Equal(c.want) {
+			t.Errorf("[%d] parseJobLogPartition(%q, %q) = %v, %v, want %v, %v", i, c.lt, c.name, m, ok, c.want, c.ok)
+		}
+
+		if ok && JobLogPartitionName(c.lt, m) != c.name {
+			t.Errorf("[%d] JobLogPartitionName(%q, %v) = %q, want %q", i, c.lt, m, JobLogPartitionName(c.lt, m), c.name)
+		}
+	}
+}
+
+func TestHasActiveJobLogs(t *testing.T) {
+	db := testOpenDB(t, testJobTableDDL, testJobLogTableDDL, `CREATE TABLE job_logs_p202401 AS SELECT * FROM job_logs`)
+
+	sjm := JM(db, "jobs", "job_logs").(*sjm)
+
+	jid, err := sjm.AppendJob(0, "test", "en", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO job_logs_p202401 (id, jid, time, level, message) VALUES (1, ?, ?, 'I', 'test')", jid, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(time.Hour)
+
+	// pending job
+	if active, err := sjm.hasActiveJobLogs("job_logs_p202401", before); err != nil || !active {
+		t.Errorf("hasActiveJobLogs(pending) = %v, %v, want true", active, err)
+	}
+
+	if err := sjm.FinishJob(jid); err != nil {
+		t.Fatal(err)
+	}
+
+	// finished job, not outdated
+	if active, err := sjm.hasActiveJobLogs("job_logs_p202401", time.Now().Add(-time.Hour)); err != nil || !active {
+		t.Errorf("hasActiveJobLogs(finished) = %v, %v, want true", active, err)
+	}
+
+	// finished job, outdated
+	if active, err := sjm.hasActiveJobLogs("job_logs_p202401", before); err != nil || active {
+		t.Errorf("hasActiveJobLogs(outdated) = %v, %v, want false", active, err)
+	}
+}
+
+func TestCleanOutdatedJobs(t *testing.T) {
+	db := testOpenDB(t, testJobTableDDL, testJobLogTableDDL)
+
+	jmr := JM(db, "jobs", "job_logs")
+
+	var jids []int64
+	for range 2 {
+		jid, err := jmr.AppendJob(0, "test", "en", "{}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := jmr.AddJobLog(jid, time.Now(), xjm.JobLogLevelInfo, "test"); err != nil {
+			t.Fatal(err)
+		}
+		jids = append(jids, jid)
+	}
+
+	if err := jmr.FinishJob(jids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, logs, err := jmr.CleanOutdatedJobs(time.Now().Add(time.Hour))
+	if err != nil || jobs != 1 || logs != 1 {
+		t.Fatalf("CleanOutdatedJobs() = %d, %d, %v, want 1, 1", jobs, logs, err)
+	}
+
+	if _, err := jmr.GetJob(jids[0]); err != xjm.ErrJobMissing {
+		t.Errorf("GetJob(%d) = %v, want %v", jids[0], err, xjm.ErrJobMissing)
+	}
+	if cnt, err := jmr.CountJobLogs(jids[1]); err != nil || cnt != 1 {
+		t.Errorf("CountJobLogs(%d) = %d, %v, want 1", jids[1], cnt, err)
+	}
+}
+
+func TestDeleteJobLogsDefaultPartition(t *testing.T) {
+	db := testOpenDB(t, testJobTableDDL, testJobLogTableDDL, `CREATE TABLE job_logs_default AS SELECT * FROM job_logs`)
+
+	sjm := JM(db, "jobs", "job_logs").(*sjm)
+
+	jid, err := sjm.AppendJob(0, "test", "en", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sjm.FinishJob(jid); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := time.Now().AddDate(0, -1, 0)
+	old := kept.AddDate(0, -1, 0)
+
+	// the old log in the default partition, and the logs in the dropped/kept partitions
+	if _, err := db.Exec("INSERT INTO job_logs_default (id, jid, time, level, message) VALUES (1, ?, ?, 'I', 'default')", jid, old); err != nil {
+		t.Fatal(err)
+	}
+	for _, tm := range []time.Time{old, time.Now()} {
+		if err := sjm.AddJobLog(jid, tm, xjm.JobLogLevelInfo, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sqb := db.Builder()
+	sqb.Select("id").From("jobs").Where("id = ?", jid)
+
+	logs, err := sjm.deleteJobLogs(sqb, kept)
+	if err != nil || logs != 2 {
+		t.Fatalf("deleteJobLogs() = %d, %v, want 2", logs, err)
+	}
+
+	var cnt int
+	if err := db.Get(&cnt, "SELECT COUNT(*) FROM job_logs_default"); err != nil || cnt != 0 {
+		t.Errorf("COUNT(job_logs_default) = %d, %v, want 0", cnt, err)
+	}
+
+	// the log before kept is in the dropped partition
+	if cnt, err := sjm.CountJobLogs(jid); err != nil || cnt != 1 {
+		t.Errorf("CountJobLogs(%d) = %d, %v, want 1", jid, cnt, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/askasoft/pango/asg"
 	"github.com/askasoft/pango/sqx/sqlx"
 	"github.com/askasoft/pangox/xjm"
 )
@@ -12,13 +13,23 @@ type sjm struct {
 	db sqlx.Sqlx
 	jt string // job table
 	lt string // log table
+	et string // event table
+	lp bool   // log table is partitioned
+
+	onAppended xjm.JobAppendListener
 }
 
-func JM(db sqlx.Sqlx, jobTable, logTable string) xjm.JobManager {
+// JM create a sqlx job manager.
+// eventTable: the job event table to record the job creator and the job status transitions (optional).
+// If the eventTable is specified, the returned job manager implements xjm.JobAuditor,
+// the job table must have the "uid" and "cip" columns, and the job and the job event are updated in one transaction
+// (if the db is a *sqlx.DB).
+func JM(db sqlx.Sqlx, jobTable, logTable string, eventTable ...string) xjm.JobManager {
 	return &sjm{
 		db: db,
 		jt: jobTable,
 		lt: logTable,
+		et: asg.First(eventTable),
 	}
 }
 
@@ -165,105 +176,162 @@ func (sjm *sjm) IterJobs(it func(*xjm.Job) error, name string, start, limit int,
 }
 
 func (sjm *sjm) AppendJob(cid int64, name, locale, param string) (int64, error) {
+	return sjm.AppendJobBy(nil, cid, name, locale, param)
+}
+
+// AppendJobBy implements xjm.JobAuditor
+func (sjm *sjm) AppendJobBy(actor *xjm.JobActor, cid int64, name, locale, param string) (jid int64, err error) {
 	now := time.Now()
+	ja := jobActor(actor)
+
+	err = sjm.transaction(func(db sqlx.Sqlx) error {
+		sqb := db.Builder()
+		sqb.Insert(sjm.jt)
+		sqb.Setc("cid", cid)
+		sqb.Setc("rid", 0)
+		sqb.Setc("name", name)
+		if sjm.et != "" {
+			sqb.Setc("uid", ja.UID)
+			sqb.Setc("cip", ja.CIP)
+		}
+		sqb.Setc("status", xjm.JobStatusPending)
+		sqb.Setc("locale", locale)
+		sqb.Setc("param", param)
+		sqb.Setc("state", "")
+		sqb.Setc("result", "")
+		sqb.Setc("error", "")
+		sqb.Setc("created_at", now)
+		sqb.Setc("updated_at", now)
+
+		if !db.SupportLastInsertID() {
+			sqb.Returns("id")
+		}
 
-	sqb := sjm.db.Builder()
-	sqb.Insert(sjm.jt)
-	sqb.Setc("cid", cid)
-	sqb.Setc("rid", 0)
-	sqb.Setc("name", name)
-	sqb.Setc("status", xjm.JobStatusPending)
-	sqb.Setc("locale", locale)
-	sqb.Setc("param", param)
-	sqb.Setc("state", "")
-	sqb.Setc("result", "")
-	sqb.Setc("error", "")
-	sqb.Setc("created_at", now)
-	sqb.Setc("updated_at", now)
+		sql, args := sqb.Build()
 
-	if !sjm.db.SupportLastInsertID() {
-		sqb.Returns("id")
-	}
+		var err error
+		if jid, err = db.Create(sql, args...); err != nil {
+			return err
+		}
+		return sjm.addJobEvent(db, jid, xjm.JobStatusPending, "", ja)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if sjm.onAppended != nil {
+		sjm.onAppended(&xjm.Job{
+			ID:        jid,
+			CID:       cid,
+			Name:      name,
+			UID:       ja.UID,
+			CIP:       ja.CIP,
+			Status:    xjm.JobStatusPending,
+			Locale:    locale,
+			Param:     param,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+	}
+	return jid, nil
+}
 
-	sql, args := sqb.Build()
-	return sjm.db.Create(sql, args...)
+// OnJobAppended implements xjm.JobAppendNotifier
+func (sjm *sjm) OnJobAppended(listener xjm.JobAppendListener) {
+	sjm.onAppended = listener
 }
 
 func (sjm *sjm) AbortJob(jid int64, reason string) error {
-	return sjm.abortCancelJob(jid, xjm.JobStatusAborted, reason)
+	return sjm.AbortJobBy(nil, jid, reason)
+}
+
+// AbortJobBy implements xjm.JobAuditor
+func (sjm *sjm) AbortJobBy(actor *xjm.JobActor, jid int64, reason string) error {
+	return sjm.abortCancelJob(jid, xjm.JobStatusAborted, reason, jobActor(actor))
 }
 
 func (sjm *sjm) CancelJob(jid int64, reason string) error {
-	return sjm.abortCancelJob(jid, xjm.JobStatusCanceled, reason)
+	return sjm.CancelJobBy(nil, jid, reason)
 }
 
-func (sjm *sjm) abortCancelJob(jid int64, status, reason string) error {
-	sqb := sjm.db.Builder()
+// CancelJobBy implements xjm.JobAuditor
+func (sjm *sjm) CancelJobBy(actor *xjm.JobActor, jid int64, reason string) error {
+	return sjm.abortCancelJob(jid, xjm.JobStatusCanceled, reason, jobActor(actor))
+}
 
-	sqb.Update(sjm.jt)
-	sqb.Setc("status", status)
-	sqb.Setc("error", reason)
-	sqb.Setc("updated_at", time.Now())
-	sqb.Where("id = ?", jid)
-	sqb.In("status", xjm.JobUndoneStatus)
+func (sjm *sjm) abortCancelJob(jid int64, status, reason string, ja *xjm.JobActor) error {
+	return sjm.transaction(func(db sqlx.Sqlx) error {
+		sqb := db.Builder()
 
-	sql, args := sqb.Build()
+		sqb.Update(sjm.jt)
+		sqb.Setc("status", status)
+		sqb.Setc("error", reason)
+		sqb.Setc("updated_at", time.Now())
+		sqb.Where("id = ?", jid)
+		sqb.In("status", xjm.JobUndoneStatus)
 
-	cnt, err := sjm.db.Update(sql, args...)
-	if err != nil {
-		return err
-	}
+		sql, args := sqb.Build()
 
-	if cnt != 1 {
-		return xjm.ErrJobMissing
-	}
-	return nil
+		cnt, err := db.Update(sql, args...)
+		if err != nil {
+			return err
+		}
+
+		if cnt != 1 {
+			return xjm.ErrJobMissing
+		}
+		return sjm.addJobEvent(db, jid, status, reason, ja)
+	})
 }
 
 func (sjm *sjm) FinishJob(jid int64) error {
-	sqb := sjm.db.Builder()
+	return sjm.transaction(func(db sqlx.Sqlx) error {
+		sqb := db.Builder()
 
-	sqb.Update(sjm.jt)
-	sqb.Setc("status", xjm.JobStatusFinished)
-	sqb.Setc("error", "")
-	sqb.Setc("updated_at", time.Now())
-	sqb.Where("id = ?", jid)
+		sqb.Update(sjm.jt)
+		sqb.Setc("status", xjm.JobStatusFinished)
+		sqb.Setc("error", "")
+		sqb.Setc("updated_at", time.Now())
+		sqb.Where("id = ?", jid)
 
-	sql, args := sqb.Build()
+		sql, args := sqb.Build()
 
-	cnt, err := sjm.db.Update(sql, args...)
-	if err != nil {
-		return err
-	}
+		cnt, err := db.Update(sql, args...)
+		if err != nil {
+			return err
+		}
 
-	if cnt != 1 {
-		return xjm.ErrJobMissing
-	}
-	return nil
+		if cnt != 1 {
+			return xjm.ErrJobMissing
+		}
+		return sjm.addJobEvent(db, jid, xjm.JobStatusFinished, "", nil)
+	})
 }
 
 func (sjm *sjm) CheckoutJob(jid, rid int64) error {
-	sqb := sjm.db.Builder()
+	return sjm.transaction(func(db sqlx.Sqlx) error {
+		sqb := db.Builder()
 
-	sqb.Update(sjm.jt)
-	sqb.Setc("rid", rid)
-	sqb.Setc("status", xjm.JobStatusRunning)
-	sqb.Setc("error", "")
-	sqb.Setc("updated_at", time.Now())
-	sqb.Where("id = ?", jid)
-	sqb.Where("status = ?", xjm.JobStatusPending)
+		sqb.Update(sjm.jt)
+		sqb.Setc("rid", rid)
+		sqb.Setc("status", xjm.JobStatusRunning)
+		sqb.Setc("error", "")
+		sqb.Setc("updated_at", time.Now())
+		sqb.Where("id = ?", jid)
+		sqb.Where("status = ?", xjm.JobStatusPending)
 
-	sql, args := sqb.Build()
+		sql, args := sqb.Build()
 
-	cnt, err := sjm.db.Update(sql, args...)
-	if err != nil {
-		return err
-	}
+		cnt, err := db.Update(sql, args...)
+		if err != nil {
+			return err
+		}
 
-	if cnt != 1 {
-		return xjm.ErrJobCheckout
-	}
-	return nil
+		if cnt != 1 {
+			return xjm.ErrJobCheckout
+		}
+		return sjm.addJobEvent(db, jid, xjm.JobStatusRunning, "", nil)
+	})
 }
 
 func (sjm *sjm) PinJob(jid, rid int64) error {
@@ -333,19 +401,59 @@ func (sjm *sjm) AddJobResult(jid, rid int64, result string) error {
 }
 
 func (sjm *sjm) ReappendJobs(before time.Time) (int64, error) {
-	sqb := sjm.db.Builder()
+	if sjm.et == "" {
+		return sjm.reappendJobs(sjm.db, before)
+	}
+
+	sqa := sjm.db.Builder()
+	sqa.Select("id").From(sjm.jt)
+	sqa.Where("status = ?", xjm.JobStatusRunning)
+	sqa.Where("updated_at < ?", before)
+	sqa.Order("id")
+
+	var jids []int64
+	sql, args := sqa.Build()
+	if err := sjm.db.Select(&jids, sql, args...); err != nil {
+		return 0, err
+	}
+
+	// reappend the jobs one by one, and record the event only if the job is updated by this call,
+	// the job may be reappended or pinned by the other worker after the select.
+	var total int64
+	for _, jid := range jids {
+		err := sjm.transaction(func(db sqlx.Sqlx) error {
+			cnt, err := sjm.reappendJobs(db, before, jid)
+			if err != nil || cnt == 0 {
+				return err
+			}
+
+			total += cnt
+			return sjm.addJobEvent(db, jid, xjm.JobStatusPending, "reappend", nil)
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (sjm *sjm) reappendJobs(db sqlx.Sqlx, before time.Time, jids ...int64) (int64, error) {
+	sqb := db.Builder()
 
 	sqb.Update(sjm.jt)
 	sqb.Setc("rid", 0)
 	sqb.Setc("status", xjm.JobStatusPending)
 	sqb.Setc("error", "")
 	sqb.Setc("updated_at", time.Now())
+	if len(jids) > 0 {
+		sqb.In("id", jids)
+	}
 	sqb.Where("status = ?", xjm.JobStatusRunning)
 	sqb.Where("updated_at < ?", before)
 
 	sql, args := sqb.Build()
 
-	return sjm.db.Update(sql, args...)
+	return db.Update(sql, args...)
 }
 
 func (sjm *sjm) StartJobs(limit int, start func(*xjm.Job)) error {
@@ -389,6 +497,17 @@ func (sjm *sjm) DeleteJobs(jids ...int64) (jobs int64, logs int64, err error) {
 		return
 	}
 
+	if sjm.et != "" {
+		sqe := sjm.db.Builder()
+		sqe.Delete(sjm.et)
+		sqe.In("jid", jids)
+		sql, args = sqe.Build()
+
+		if _, err = sjm.db.Update(sql, args...); err != nil {
+			return
+		}
+	}
+
 	sqb := sjm.db.Builder()
 	sqb.Delete(sjm.jt)
 	sqb.In("id", jids)
@@ -404,18 +523,121 @@ func (sjm *sjm) CleanOutdatedJobs(before time.Time) (jobs int64, logs int64, err
 	sqb.Where("updated_at < ?", before)
 	sqb.In("status", xjm.JobDoneStatus)
 
+	var kept time.Time
+	if sjm.lp {
+		if err = sjm.CreateJobLogPartitions(time.Now().AddDate(0, JobLogPartitionMonths, 0)); err != nil {
+			return
+		}
+		if logs, kept, err = sjm.dropJobLogPartitions(before); err != nil {
+			return
+		}
+	}
+
+	cnt, err := sjm.deleteJobLogs(sqb, kept)
+	if err != nil {
+		return
+	}
+	logs += cnt
+
+	if sjm.et != "" {
+		sqe := sjm.db.Builder()
+		sqe.Delete(sjm.et)
+		sqe.Where("jid IN ("+sqb.SQL()+")", sqb.Params()...)
+		sql, args := sqe.Build()
+
+		if _, err = sjm.db.Update(sql, args...); err != nil {
+			return
+		}
+	}
+
+	sqb.Delete(sjm.jt)
+	sql, args := sqb.Build()
+
+	jobs, err = sjm.db.Update(sql, args...)
+	return
+}
+
+// deleteJobLogs delete the logs of the jobs selected by sqb.
+// If kept is not zero (the partitions before it are dropped), the logs before kept are skipped,
+// and the logs in the default partition (which is never dropped) are deleted without the time condition.
+func (sjm *sjm) deleteJobLogs(sqb *sqlx.Builder, kept time.Time) (int64, error) {
 	sqa := sjm.db.Builder()
 	sqa.Delete(sjm.lt)
 	sqa.Where("jid IN ("+sqb.SQL()+")", sqb.Params()...)
+	if !kept.IsZero() {
+		// prune the dropped partitions
+		sqa.Gte("time", kept)
+	}
 	sql, args := sqa.Build()
 
-	if logs, err = sjm.db.Update(sql, args...); err != nil {
+	logs, err := sjm.db.Update(sql, args...)
+	if err != nil || kept.IsZero() {
+		return logs, err
+	}
+
+	sqd := sjm.db.Builder()
+	sqd.Delete(JobLogDefaultPartitionName(sjm.lt))
+	sqd.Where("jid IN ("+sqb.SQL()+")", sqb.Params()...)
+	sql, args = sqd.Build()
+
+	cnt, err := sjm.db.Update(sql, args...)
+	return logs + cnt, err
+}
+
+func jobActor(actor *xjm.JobActor) *xjm.JobActor {
+	if actor != nil {
+		return actor
+	}
+	return &xjm.JobActor{}
+}
+
+// GetJobEvents implements xjm.JobAuditor
+func (sjm *sjm) GetJobEvents(jid int64) (jes []*xjm.JobEvent, err error) {
+	if sjm.et == "" {
 		return
 	}
 
-	sqb.Delete(sjm.jt)
-	sql, args = sqb.Build()
+	sqb := sjm.db.Builder()
+	sqb.Select().From(sjm.et).Where("jid = ?", jid)
+	sqb.Order("id")
+	sql, args := sqb.Build()
 
-	jobs, err = sjm.db.Update(sql, args...)
+	err = sjm.db.Select(&jes, sql, args...)
 	return
 }
+
+// transaction run the fn in a transaction if the job events are recorded and the db is a *sqlx.DB,
+// so the job and the job event are updated atomically.
+func (sjm *sjm) transaction(fn func(db sqlx.Sqlx) error) error {
+	if sjm.et != "" {
+		if db, ok := sjm.db.(*sqlx.DB); ok {
+			return db.Transaction(func(tx *sqlx.Tx) error {
+				return fn(tx)
+			})
+		}
+	}
+	return fn(sjm.db)
+}
+
+func (sjm *sjm) addJobEvent(db sqlx.Sqlx, jid int64, status, reason string, ja *xjm.JobActor) error {
+	if sjm.et == "" {
+		return nil
+	}
+
+	if ja == nil {
+		ja = &xjm.JobActor{}
+	}
+
+	sqb := db.Builder()
+	sqb.Insert(sjm.et)
+	sqb.Setc("jid", jid)
+	sqb.Setc("status", status)
+	sqb.Setc("uid", ja.UID)
+	sqb.Setc("cip", ja.CIP)
+	sqb.Setc("reason", reason)
+	sqb.Setc("time", time.Now())
+	sql, args := sqb.Build()
+
+	_, err := db.Exec(sql, args...)
+	return err
+}
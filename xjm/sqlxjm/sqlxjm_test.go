@@ -0,0 +1,225 @@
+package sqlxjm
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pangox/xjm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	testJobTableDDL = `CREATE TABLE jobs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	cid        INTEGER NOT NULL,
+	rid        INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	locale     TEXT NOT NULL,
+	param      TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	result     TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`
+
+	testJobActorDDL = `ALTER TABLE jobs ADD COLUMN uid INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN cip TEXT NOT NULL DEFAULT ''`
+
+	testJobLogTableDDL = `CREATE TABLE job_logs (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	jid     INTEGER NOT NULL,
+	time    TIMESTAMP NOT NULL,
+	level   TEXT NOT NULL,
+	message TEXT NOT NULL
+)`
+
+	testJobEventTableDDL = `CREATE TABLE job_events (
+	id     INTEGER PRIMARY KEY AUTOINCREMENT,
+	jid    INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	uid    INTEGER NOT NULL,
+	cip    TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	time   TIMESTAMP NOT NULL
+)`
+)
+
+func testOpenDB(t *testing.T, ddls ...string) *sqlx.DB {
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	for _, ddl := range ddls {
+		if _, err := db.Exec(ddl); err != nil {
+			t.Fatalf("%v\n%s", err, ddl)
+		}
+	}
+	return db
+}
+
+func TestJobManagerWithoutEvents(t *testing.T) {
+	// the job table without the uid/cip columns
+	db := testOpenDB(t, testJobTableDDL, testJobLogTableDDL)
+
+	jmr := JM(db, "jobs", "job_logs")
+
+	jid, err := xjm.AppendJobBy(jmr, &xjm.JobActor{UID: 1, CIP: "127.0.0.1"}, 0, "test", "en", "{}")
+	if err != nil {
+		t.Fatalf("AppendJobBy() = %v", err)
+	}
+
+	if err := jmr.CancelJob(jid, "cancel"); err != nil {
+		t.Fatalf("CancelJob() = %v", err)
+	}
+
+	job, err := jmr.GetJob(jid)
+	if err != nil {
+		t.Fatalf("GetJob() = %v", err)
+	}
+	if job.Status != xjm.JobStatusCanceled || job.Error != "cancel" {
+		t.Errorf("GetJob() = %v", job)
+	}
+
+	jes, err := jmr.(xjm.JobAuditor).GetJobEvents(jid)
+	if err != nil || len(jes) != 0 {
+		t.Errorf("GetJobEvents() = %v, %v", jes, err)
+	}
+}
+
+func TestJobManagerEvents(t *testing.T) {
+	db := testOpenDB(t, testJobTableDDL, testJobActorDDL, testJobLogTableDDL, testJobEventTableDDL)
+
+	jmr := JM(db, "jobs", "job_logs", "job_events")
+	ja := &xjm.JobActor{UID: 1, CIP: "127.0.0.1"}
+
+	jid, err := xjm.AppendJobBy(jmr, ja, 0, "test", "en", "{}")
+	if err != nil {
+		t.Fatalf("AppendJobBy() = %v", err)
+	}
+
+	job, err := jmr.GetJob(jid)
+	if err != nil {
+		t.Fatalf("GetJob() = %v", err)
+	}
+	if job.UID != ja.UID || job.CIP != ja.CIP {
+		t.Errorf("GetJob() = %v, want uid/cip %v", job, ja)
+	}
+
+	if err := jmr.CheckoutJob(jid, 9); err != nil {
+		t.Fatalf("CheckoutJob() = %v", err)
+	}
+	if err := xjm.AbortJobBy(jmr, &xjm.JobActor{UID: 2, CIP: "::1"}, jid, "abort"); err != nil {
+		t.Fatalf("AbortJobBy() = %v", err)
+	}
+	if err := jmr.CancelJob(jid, "cancel"); !errors.Is(err, xjm.ErrJobMissing) {
+		t.Fatalf("CancelJob() = %v, want %v", err, xjm.ErrJobMissing)
+	}
+
+	jes, err := jmr.(xjm.JobAuditor).GetJobEvents(jid)
+	if err != nil {
+		t.Fatalf("GetJobEvents() = %v", err)
+	}
+
+	want := []xjm.JobEvent{
+		{JID: jid, Status: xjm.JobStatusPending, UID: 1, CIP: "127.0.0.1"},
+		{JID: jid, Status: xjm.JobStatusRunning},
+		{JID: jid, Status: xjm.JobStatusAborted, UID: 2, CIP: "::1", Reason: "abort"},
+	}
+	if len(jes) != len(want) {
+		t.Fatalf("GetJobEvents() = %v, want %d events", jes, len(want))
+	}
+	for i, je := range jes {
+		w := want[i]
+		if je.JID != w.JID || je.Status != w.Status || je.UID != w.UID || je.CIP != w.CIP || je.Reason != w.Reason || je.Time.IsZero() {
+			t.Errorf("[%d] GetJobEvents() = %v, want %v", i, je, &w)
+		}
+	}
+
+	jobs, logs, err := jmr.DeleteJobs(jid)
+	if err != nil || jobs != 1 || logs != 0 {
+		t.Fatalf("DeleteJobs() = %d, %d, %v", jobs, logs, err)
+	}
+	if jes, err = jmr.(xjm.JobAuditor).GetJobEvents(jid); err != nil || len(jes) != 0 {
+		t.Errorf("GetJobEvents() after DeleteJobs() = %v, %v", jes, err)
+	}
+}
+
+func TestJobManagerAppendRollback(t *testing.T) {
+	// the event table is missing, so the event insert fails
+	db := testOpenDB(t, testJobTableDDL, testJobActorDDL, testJobLogTableDDL)
+
+	jmr := JM(db, "jobs", "job_logs", "job_events")
+
+	if _, err := jmr.AppendJob(0, "test", "en", "{}"); err == nil {
+		t.Fatal("AppendJob() = nil, want error")
+	}
+
+	jobs, err := jmr.FindJobs("", 0, 0, true)
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("FindJobs() = %v, %v, want the job insert rolled back", jobs, err)
+	}
+}
+
+func TestJobManagerReappendJobs(t *testing.T) {
+	db := testOpenDB(t, testJobTableDDL, testJobActorDDL, testJobLogTableDDL, testJobEventTableDDL)
+
+	jmr := JM(db, "jobs", "job_logs", "job_events")
+
+	var jids []int64
+	for range 3 {
+		jid, err := jmr.AppendJob(0, "test", "en", "{}")
+		if err != nil {
+			t.Fatalf("AppendJob() = %v", err)
+		}
+		if err := jmr.CheckoutJob(jid, 1); err != nil {
+			t.Fatalf("CheckoutJob() = %v", err)
+		}
+		jids = append(jids, jid)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	// the job is not interrupted
+	if err := jmr.PinJob(jids[2], 1); err != nil {
+		t.Fatalf("PinJob() = %v", err)
+	}
+
+	cnt, err := jmr.ReappendJobs(before)
+	if err != nil || cnt != 2 {
+		t.Fatalf("ReappendJobs() = %d, %v, want 2", cnt, err)
+	}
+
+	// the reappended jobs are not reappended again
+	if cnt, err = jmr.ReappendJobs(before); err != nil || cnt != 0 {
+		t.Fatalf("ReappendJobs() = %d, %v, want 0", cnt, err)
+	}
+
+	for i, jid := range jids {
+		jes, err := jmr.(xjm.JobAuditor).GetJobEvents(jid)
+		if err != nil {
+			t.Fatalf("GetJobEvents(%d) = %v", jid, err)
+		}
+
+		want := 2
+		if i < 2 {
+			want = 3
+			if je := jes[len(jes)-1]; je.Status != xjm.JobStatusPending || je.Reason != "reappend" {
+				t.Errorf("GetJobEvents(%d) = %v, want reappend event", jid, je)
+			}
+		}
+		if len(jes) != want {
+			t.Errorf("GetJobEvents(%d) = %d events, want %d", jid, len(jes), want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package xfsus
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pango/xin"
+	"github.com/askasoft/pangox/xfs"
+)
+
+// ContentDisposition returns the Content-Disposition header value of the disposition type ("inline" or "attachment")
+// with the ASCII fallback "filename" parameter and the RFC 5987 encoded "filename*" parameter of the UTF-8 filename.
+func ContentDisposition(disposition, filename string) string {
+	if filename == "" {
+		return disposition
+	}
+
+	var fb strings.Builder
+	for _, r := range filename {
+		if r < 0x20 || r >= 0x7F || r == '"' || r == '\\' {
+			fb.WriteByte('_')
+		} else {
+			fb.WriteRune(r)
+		}
+	}
+
+	return disposition + `; filename="` + fb.String() + `"; filename*=UTF-8''` + encodeRFC5987(filename)
+}
+
+// encodeRFC5987 percent encodes the string except the RFC 5987 attr-char
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0xF])
+		}
+	}
+	return sb.String()
+}
+
+// FileHandler serves the xfs files by xfs.ServeFileContent(), which supports
+// the Range/If-Range requests without loading the entire file data,
+// the If-None-Match (File.Hash) and If-Modified-Since (File.Time) conditional requests.
+// The Content-Disposition header is set with the original File.Name.
+type FileHandler struct {
+	XFS xfs.XFS
+
+	// Disposition the Content-Disposition type "inline" or "attachment", default "attachment".
+	// The request with the "download" query parameter is always served as "attachment",
+	// and the file of the active or unknown MIME type is always served as "attachment" (see xfs.Inlineable).
+	Disposition string
+
+	// CacheControl the Cache-Control header value, default "private, no-cache".
+	// Set to empty to not set the Cache-Control header.
+	CacheControl string
+
+	// FileID returns the file id of the request, default is the path parameter "id" (for example "/files/*id")
+	FileID func(c *xin.Context) string
+}
+
+// NewFileHandler create a FileHandler
+func NewFileHandler(fsys xfs.XFS) *FileHandler {
+	return &FileHandler{
+		XFS:          fsys,
+		Disposition:  "attachment",
+		CacheControl: "private, no-cache",
+	}
+}
+
+// Handle serves the xfs file of the request
+func (fh *FileHandler) Handle(c *xin.Context) {
+	id := c.Param("id")
+	if fh.FileID != nil {
+		id = fh.FileID(c)
+	}
+
+	fh.serveFile(c.Writer, c.Request, id, "")
+}
+
+// serveFile serves the xfs file id with the Content-Disposition of the filename (default File.Name).
+// The error detail is logged and not replied to the client.
+func (fh *FileHandler) serveFile(w http.ResponseWriter, r *http.Request, id, filename string) {
+	f, err := fh.XFS.FindFile(id)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+
+		log.Errorf("xfsus: failed to find file %q: %v", id, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	disposition := fh.Disposition
+	if disposition != "inline" || r.URL.Query().Has("download") || !xfs.Inlineable(f) {
+		disposition = "attachment"
+	}
+
+	if filename == "" {
+		filename = f.Name
+	}
+
+	w.Header().Set("Content-Disposition", ContentDisposition(disposition, filename))
+	if fh.CacheControl != "" {
+		w.Header().Set("Cache-Control", fh.CacheControl)
+	}
+
+	xfs.ServeFileContent(w, r, fh.XFS, f)
+}
+
+// Route add the GET/HEAD handlers of the path "/*id" to the router group
+func (fh *FileHandler) Route(rg xin.IRoutes) {
+	rg.GET("/*id", fh.Handle)
+	rg.HEAD("/*id", fh.Handle)
+}
+
+// ServeFile serves the xfs file as the attachment with the Content-Disposition of the original File.Name
+func ServeFile(c *xin.Context, fsys xfs.XFS, id string) {
+	NewFileHandler(fsys).serveFile(c.Writer, c.Request, id, "")
+}
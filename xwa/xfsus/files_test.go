@@ -0,0 +1,115 @@
+package xfsus
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+)
+
+func TestContentDisposition(t *testing.T) {
+	cs := []struct {
+		disp string
+		name string
+		want string
+	}{
+		{"inline", "", "inline"},
+		{"inline", "a b.txt", `inline; filename="a b.txt"; filename*=UTF-8''a%20b.txt`},
+		{"attachment", `x"y\z.txt`, `attachment; filename="x_y_z.txt"; filename*=UTF-8''x%22y%5Cz.txt`},
+		{"attachment", "資料.pdf", `attachment; filename="__.pdf"; filename*=UTF-8''%E8%B3%87%E6%96%99.pdf`},
+	}
+
+	for i, c := range cs {
+		a := ContentDisposition(c.disp, c.name)
+		if a != c.want {
+			t.Errorf("#%d ContentDisposition(%q, %q) = %q, want %q", i, c.disp, c.name, a, c.want)
+		}
+	}
+}
+
+// failFS fails to find the files
+type failFS struct {
+	xfs.XFS
+}
+
+func (ffs failFS) FindFile(id string) (*xfs.File, error) {
+	return nil, errors.New("secret database error")
+}
+
+func TestFileHandler(t *testing.T) {
+	mfs := memxfs.FS()
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := mfs.SaveFile("/a.txt", "a.txt", mtime, []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.SaveFile("/x.html", "x.html", mtime, []byte("<html><script>alert(1)</script></html>")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := mfs.FindFile("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := []struct {
+		name    string
+		xfs     xfs.XFS
+		url     string
+		headers map[string]string
+		status  int
+		body    string
+		disp    string
+	}{
+		{"inline", mfs, "/a.txt", nil, http.StatusOK, "0123456789", "inline"},
+		{"download", mfs, "/a.txt?download", nil, http.StatusOK, "0123456789", "attachment"},
+		{"active", mfs, "/x.html", nil, http.StatusOK, "", "attachment"},
+		{"range", mfs, "/a.txt", map[string]string{"Range": "bytes=2-4"}, http.StatusPartialContent, "234", "inline"},
+		{"if-range", mfs, "/a.txt", map[string]string{"Range": "bytes=2-4", "If-Range": f.ETag()}, http.StatusPartialContent, "234", "inline"},
+		{"if-range changed", mfs, "/a.txt", map[string]string{"Range": "bytes=2-4", "If-Range": `"changed"`}, http.StatusOK, "0123456789", "inline"},
+		{"if-none-match", mfs, "/a.txt", map[string]string{"If-None-Match": f.ETag()}, http.StatusNotModified, "", "inline"},
+		{"if-modified-since", mfs, "/a.txt", map[string]string{"If-Modified-Since": mtime.Format(http.TimeFormat)}, http.StatusNotModified, "", "inline"},
+		{"modified", mfs, "/a.txt", map[string]string{"If-Modified-Since": mtime.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK, "0123456789", "inline"},
+		{"not found", mfs, "/b.txt", nil, http.StatusNotFound, "", ""},
+		{"error", failFS{mfs}, "/a.txt", nil, http.StatusInternalServerError, "", ""},
+	}
+
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			fh := NewFileHandler(c.xfs)
+			fh.Disposition = "inline"
+
+			req := httptest.NewRequest(http.MethodGet, c.url, nil)
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+
+			fh.serveFile(rec, req, req.URL.Path, "")
+
+			if rec.Code != c.status {
+				t.Fatalf("status = %d, want %d", rec.Code, c.status)
+			}
+			if c.body != "" && rec.Body.String() != c.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), c.body)
+			}
+			if strings.Contains(rec.Body.String(), "secret") {
+				t.Errorf("body = %q, the error detail is leaked", rec.Body.String())
+			}
+
+			if c.disp != "" {
+				if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, c.disp+";") {
+					t.Errorf("Content-Disposition = %q, want %q", cd, c.disp)
+				}
+				if cc := rec.Header().Get("Cache-Control"); cc != "private, no-cache" {
+					t.Errorf("Cache-Control = %q", cc)
+				}
+			}
+		})
+	}
+}
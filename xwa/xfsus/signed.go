@@ -0,0 +1,39 @@
+package xfsus
+
+import (
+	"time"
+
+	"github.com/askasoft/pango/xin"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xwa"
+	"github.com/askasoft/pangox/xwa/xmwas"
+)
+
+// SignFileURL returns the signed url of the xfs file which expires after the duration, signed with xwa.Secret.
+// See xfs.SignURL() for details.
+func SignFileURL(base, id string, expires time.Duration, filename ...string) string {
+	return xfs.SignURL(xwa.Secret, base, id, time.Now().Add(expires), filename...)
+}
+
+// SignedFileHandler returns a xin handler which validates the signed url with xwa.Secret,
+// and serves the xfs file which id is the path parameter "id" (for example "/files/*id").
+// The Range and conditional requests are supported by xfs.ServeFileContent().
+// The file is served as "attachment" with the signed filename, or as "inline" if the file is inlineable (see FileHandler).
+func SignedFileHandler(fsys xfs.XFS) xin.HandlerFunc {
+	return func(c *xin.Context) {
+		id := c.Param("id")
+
+		fn, err := xfs.VerifySignedURL(xwa.Secret, id, c.Request.URL.Query())
+		if err != nil {
+			xmwas.InvalidToken(c)
+			return
+		}
+
+		// the signed filename is served as "attachment", see ContentDisposition()
+		fh := &FileHandler{XFS: fsys, Disposition: "inline", CacheControl: "private"}
+		if fn != "" {
+			fh.Disposition = "attachment"
+		}
+		fh.serveFile(c.Writer, c.Request, id, fn)
+	}
+}
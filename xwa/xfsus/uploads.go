@@ -0,0 +1,228 @@
+package xfsus
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pango/xin"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xwa/xmwas"
+)
+
+// TusResumable the supported version of the tus resumable upload protocol
+const TusResumable = "1.0.0"
+
+// UploadHandler implements a tus (https://tus.io/protocols/resumable-upload) like resumable upload protocol.
+//
+//	POST   /uploads                create a upload session (Upload-Length, Upload-Metadata)
+//	HEAD   /uploads/:id            query the upload offset
+//	PATCH  /uploads/:id            append a chunk at the Upload-Offset
+//	POST   /uploads/:id/finalize   finalize the completed upload session into a regular xfs file
+//	DELETE /uploads/:id            cancel the upload session
+type UploadHandler struct {
+	Uploads *xfs.Uploads
+
+	// MaxSize the maximum upload length, 0 means unlimited
+	MaxSize int64
+
+	// FileID returns the file id of the finalized file, default is "/" + upload id + "/" + filename (upload id if no filename)
+	FileID func(c *xin.Context, u *xfs.Upload) string
+
+	// FileTag the tag of the finalized file
+	FileTag string
+}
+
+// NewUploadHandler create a UploadHandler
+func NewUploadHandler(ups *xfs.Uploads, maxSize int64) *UploadHandler {
+	return &UploadHandler{Uploads: ups, MaxSize: maxSize}
+}
+
+// parseUploadMetadata parse the Upload-Metadata header: "key base64(value),key2 base64(value2)"
+func parseUploadMetadata(s string) map[string]string {
+	md := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), " ")
+		if k == "" {
+			continue
+		}
+
+		bs, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		md[k] = string(bs)
+	}
+	return md
+}
+
+func (uh *UploadHandler) abort(c *xin.Context, err error) {
+	switch {
+	case xmwas.QuotaExceeded(c, err):
+	case errors.Is(err, fs.ErrNotExist):
+		c.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, xfs.ErrUploadOffset):
+		c.AbortWithStatus(http.StatusConflict)
+	case errors.Is(err, xfs.ErrUploadLength):
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+	case errors.Is(err, xfs.ErrUploadIncomplete):
+		c.AbortWithStatus(http.StatusConflict)
+	case errors.Is(err, xfs.ErrUploadLocked):
+		c.AbortWithStatus(http.StatusLocked)
+	default:
+		c.AddError(err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// Create create a upload session
+func (uh *UploadHandler) Create(c *xin.Context) {
+	c.Header("Tus-Resumable", TusResumable)
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if uh.MaxSize > 0 && length > uh.MaxSize {
+		xmwas.BodyTooLarge(c)
+		return
+	}
+
+	md := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+
+	u, err := uh.Uploads.Create(md["filename"], length, md)
+	if err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	c.Header("Location", path.Join(c.Request.URL.Path, u.ID))
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+// Head returns the offset of the upload session
+func (uh *UploadHandler) Head(c *xin.Context) {
+	c.Header("Tus-Resumable", TusResumable)
+	c.Header("Cache-Control", "no-store")
+
+	u, err := uh.Uploads.Get(c.Param("id"))
+	if err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// Patch append the request body at the Upload-Offset of the upload session
+func (uh *UploadHandler) Patch(c *xin.Context) {
+	c.Header("Tus-Resumable", TusResumable)
+
+	if c.GetHeader("Content-Type") != "application/offset+octet-stream" {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	u, err := uh.Uploads.Append(c.Param("id"), offset, c.Request.Body)
+	if u != nil {
+		c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	}
+	if err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Finalize save the completed upload session to a regular xfs file, and responds the file as json.
+func (uh *UploadHandler) Finalize(c *xin.Context) {
+	c.Header("Tus-Resumable", TusResumable)
+
+	sid := c.Param("id")
+
+	u, err := uh.Uploads.Get(sid)
+	if err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	id := uploadFileID(u)
+	if uh.FileID != nil {
+		id = uh.FileID(c, u)
+	}
+
+	f, err := uh.Uploads.Finalize(sid, id, uh.FileTag)
+	if err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, xfs.FileResult{File: f})
+}
+
+// uploadFileID returns the default file id "/" + upload id + "/" + filename of the upload session,
+// the upload id is used as the filename if the session has no filename.
+func uploadFileID(u *xfs.Upload) string {
+	name := path.Base(path.Clean("/" + u.Filename))
+	if name == "/" {
+		name = u.ID
+	}
+	return "/" + u.ID + "/" + name
+}
+
+// Delete cancel the upload session
+func (uh *UploadHandler) Delete(c *xin.Context) {
+	c.Header("Tus-Resumable", TusResumable)
+
+	if err := uh.Uploads.Cancel(c.Param("id")); err != nil {
+		uh.abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Route add the upload handlers to the router group
+func (uh *UploadHandler) Route(rg xin.IRoutes) {
+	rg.POST("", uh.Create)
+	rg.HEAD("/:id", uh.Head)
+	rg.PATCH("/:id", uh.Patch)
+	rg.POST("/:id/finalize", uh.Finalize)
+	rg.DELETE("/:id", uh.Delete)
+}
+
+// CleanOutdatedUploads delete the upload sessions which are not updated in the `expiry` duration before now.
+// The time is computed on each call, so it can be registered as a scheduled task by xschs.Register().
+func CleanOutdatedUploads(ups *xfs.Uploads, expiry time.Duration, loggers ...log.Logger) {
+	logger := getLogger(loggers...)
+
+	before := time.Now().Add(-expiry)
+
+	logger.Debugf("CleanOutdatedUploads('%s', '%s')", ups.Prefix, before.Format(time.RFC3339))
+
+	cnt, err := ups.CleanOutdated(before)
+	if err != nil {
+		logger.Errorf("CleanOutdatedUploads('%s', '%s') failed: %v", ups.Prefix, before.Format(time.RFC3339), err)
+		return
+	}
+
+	logger.Infof("CleanOutdatedUploads('%s', '%s'): %d", ups.Prefix, before.Format(time.RFC3339), cnt)
+}
@@ -0,0 +1,28 @@
+package xfsus
+
+import (
+	"testing"
+
+	"github.com/askasoft/pangox/xfs"
+)
+
+func TestUploadFileID(t *testing.T) {
+	cs := []struct {
+		name string
+		want string
+	}{
+		{"a.txt", "/u1/a.txt"},
+		{"../x/b.txt", "/u1/b.txt"},
+		{"", "/u1/u1"},
+		{"/", "/u1/u1"},
+		{"..", "/u1/u1"},
+		{"d/", "/u1/d"},
+		{"d/.", "/u1/d"},
+	}
+
+	for i, c := range cs {
+		if a := uploadFileID(&xfs.Upload{ID: "u1", Filename: c.name}); a != c.want {
+			t.Errorf("#%d uploadFileID(%q) = %q, want %q", i, c.name, a, c.want)
+		}
+	}
+}
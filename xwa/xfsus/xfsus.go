@@ -8,6 +8,8 @@ import (
 
 	"github.com/askasoft/pango/fsu"
 	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xwa/xerrs"
 )
 
 func getLogger(loggers ...log.Logger) log.Logger {
@@ -17,6 +19,16 @@ func getLogger(loggers ...log.Logger) log.Logger {
 	return log.Default()
 }
 
+// MIMEError converts the xfs.MIMEError to a xerrs.LocaleError "error.request.mime" with the detected MIME type.
+// Returns err if err is not a xfs.MIMEError.
+func MIMEError(err error) error {
+	var me *xfs.MIMEError
+	if errors.As(err, &me) {
+		return xerrs.NewLocaleError("error.request.mime", me.MIME)
+	}
+	return err
+}
+
 func CleanOutdatedLocalFiles(dir string, before time.Time, loggers ...log.Logger) {
 	logger := getLogger(loggers...)
 
@@ -67,3 +79,21 @@ func CleanOutdatedLocalFiles(dir string, before time.Time, loggers ...log.Logger
 		}
 	}
 }
+
+// PurgeTrash permanently delete the files which are deleted (moved to the trash bin) before the retention duration.
+// It can be registered as a scheduled task by xschs.Register().
+func PurgeTrash(tfs xfs.TrashFS, retention time.Duration, loggers ...log.Logger) {
+	logger := getLogger(loggers...)
+
+	before := time.Now().Add(-retention)
+
+	logger.Debugf("PurgeTrash('%s')", before.Format(time.RFC3339))
+
+	cnt, err := tfs.PurgeBefore(before)
+	if err != nil {
+		logger.Errorf("PurgeTrash('%s') failed: %v", before.Format(time.RFC3339), err)
+		return
+	}
+
+	logger.Infof("PurgeTrash('%s'): %d", before.Format(time.RFC3339), cnt)
+}
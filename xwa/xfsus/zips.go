@@ -0,0 +1,277 @@
+package xfsus
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pango/xin"
+	"github.com/askasoft/pango/xin/taglib/args"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xwa"
+	"golang.org/x/text/encoding/japanese"
+)
+
+var (
+	// ErrZipSlip indicates the zip entry path is absolute or contains "..", which may escape the target directory
+	ErrZipSlip = errors.New("xfsus: illegal zip entry path")
+
+	// ErrZipTooLarge indicates the entry count or the total uncompressed size of the zip archive exceeds the limit,
+	// see ZipMaxEntries and ZipMaxSize
+	ErrZipTooLarge = errors.New("xfsus: too large zip archive")
+)
+
+var (
+	// ZipBatchSize the count of the files to find in a batch by WriteZip()
+	ZipBatchSize = 1000
+
+	// ZipMaxEntries the maximum count of the entries of the zip archive to import, 0 means unlimited
+	ZipMaxEntries = 10000
+
+	// ZipMaxSize the maximum total uncompressed size of the zip archive to import, 0 means unlimited
+	ZipMaxSize int64 = 1 << 30
+)
+
+// WriteZip writes the files found by the query as a zip archive to w, returns the count of the written files.
+// The files are found in batches by the keyset pagination (FileQuery.LastID) ordered by id,
+// and the file data is streamed to w without buffering the entire archive.
+// The zip entry name is the file id without the query prefix (or the leading "/" if the prefix is empty).
+// The Pager and Orders of the query are ignored.
+func WriteZip(w io.Writer, fsys xfs.XFS, fq *xfs.FileQuery) (int, error) {
+	q := *fq
+	q.Pager = args.Pager{Limit: ZipBatchSize}
+	q.Orders = args.Orders{Order: "id"}
+
+	zw := zip.NewWriter(w)
+
+	cnt := 0
+	for {
+		files, err := fsys.FindFiles(&q)
+		if err != nil {
+			return cnt, err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, f := range files {
+			if err := writeZipEntry(zw, fsys, f, zipEntryName(f.ID, fq.Prefix)); err != nil {
+				return cnt, err
+			}
+			cnt++
+		}
+
+		q.LastID = files[len(files)-1].ID
+	}
+
+	return cnt, zw.Close()
+}
+
+func zipEntryName(id, prefix string) string {
+	name := strings.TrimPrefix(id, prefix)
+	name = strings.TrimLeft(name, "/")
+	if name == "" {
+		name = path.Base(id)
+	}
+	return name
+}
+
+func writeZipEntry(zw *zip.Writer, fsys xfs.XFS, f *xfs.File, name string) error {
+	zh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: f.Time,
+	}
+
+	w, err := zw.CreateHeader(zh)
+	if err != nil {
+		return err
+	}
+
+	r, err := fsys.OpenReader(f.ID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// ZipPrefix writes the files which id starts with the prefix as a zip archive to w
+func ZipPrefix(w io.Writer, fsys xfs.XFS, prefix string) (int, error) {
+	return WriteZip(w, fsys, &xfs.FileQuery{Prefix: prefix})
+}
+
+// ZipTagged writes the files with the tag as a zip archive to w
+func ZipTagged(w io.Writer, fsys xfs.XFS, tag string) (int, error) {
+	return WriteZip(w, fsys, &xfs.FileQuery{Tag: tag})
+}
+
+// ServeZip streams the files found by the query as a zip attachment with the filename to the response.
+// Since the response is streamed, the error after the response is started can only be logged.
+func ServeZip(c *xin.Context, fsys xfs.XFS, fq *xfs.FileQuery, filename string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", ContentDisposition("attachment", filename))
+	c.Header("Cache-Control", "private, no-store")
+	c.Status(http.StatusOK)
+
+	if _, err := WriteZip(c.Writer, fsys, fq); err != nil {
+		c.AddError(err)
+		c.Abort()
+	}
+}
+
+// ZipEntryPath returns the decoded and validated path of the zip entry.
+// The entry name which is not encoded in UTF-8 (zip.File.NonUTF8) is decoded as Shift-JIS
+// (for example the zip created by Windows Explorer in Japanese), the "\" path separator is converted to "/".
+// Returns ErrZipSlip if the path is absolute or contains "..".
+func ZipEntryPath(zf *zip.File) (string, error) {
+	name := zf.Name
+	if zf.NonUTF8 {
+		if s, err := japanese.ShiftJIS.NewDecoder().String(name); err == nil {
+			name = s
+		}
+	}
+
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", ErrZipSlip
+	}
+
+	for _, s := range strings.Split(name, "/") {
+		if s == ".." {
+			return "", ErrZipSlip
+		}
+	}
+	return name, nil
+}
+
+// ImportZip saves the files of the zip archive to the xfs under the directory dir, returns the saved files.
+// The file id is built by xwa.MakeFilePath() with the sanitized sub directories of the zip entry path.
+// Each entry is spooled to a temporary file, and saved by ma.SaveFileReadSeeker() (or xfs.SaveFileReadSeeker() if ma is nil),
+// so the MIME type is checked by the allowlist and the data is scanned if the xfs is a ScannedSaver (see xfs.ScanFS),
+// the same as the uploaded file.
+// The directory entries and the "__MACOSX/" entries are skipped.
+// Returns ErrZipTooLarge before any file is saved if the archive exceeds ZipMaxEntries or ZipMaxSize.
+func ImportZip(fsys xfs.XFS, ma xfs.MIMEAllowlist, dir string, zr *zip.Reader, tag ...string) ([]*xfs.File, error) {
+	if err := checkZipLimits(zr); err != nil {
+		return nil, err
+	}
+
+	var files []*xfs.File
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+
+		name, err := ZipEntryPath(zf)
+		if err != nil {
+			return files, err
+		}
+
+		if strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+
+		f, err := importZipFile(fsys, ma, dir, name, zf, tag...)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// checkZipLimits check the entry count and the total uncompressed size of the zip archive.
+// The uncompressed size declared in the entry header is trustworthy,
+// since the zip.File reader returns zip.ErrFormat if the decompressed data exceeds it.
+func checkZipLimits(zr *zip.Reader) error {
+	if ZipMaxEntries > 0 && len(zr.File) > ZipMaxEntries {
+		return fmt.Errorf("%w: %d entries > %d", ErrZipTooLarge, len(zr.File), ZipMaxEntries)
+	}
+
+	if ZipMaxSize > 0 {
+		var size uint64
+		for _, zf := range zr.File {
+			size += zf.UncompressedSize64
+			if size > uint64(ZipMaxSize) {
+				return fmt.Errorf("%w: uncompressed size > %d", ErrZipTooLarge, ZipMaxSize)
+			}
+		}
+	}
+	return nil
+}
+
+func importZipFile(fsys xfs.XFS, ma xfs.MIMEAllowlist, dir, name string, zf *zip.File, tag ...string) (*xfs.File, error) {
+	sub, base := path.Split(name)
+
+	fdir := strings.TrimSuffix(dir, "/")
+	for _, s := range strings.Split(sub, "/") {
+		s = str.RemoveAny(s, `\/:*?"<>|`)
+		if s != "" && s != "." {
+			fdir += "/" + s
+		}
+	}
+
+	tmp, err := spoolZipFile(zf)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	id := xwa.MakeFilePath(fdir, base)
+	if ma == nil {
+		return xfs.SaveFileReadSeeker(fsys, id, base, zf.Modified, tmp, tag...)
+	}
+	return ma.SaveFileReadSeeker(fsys, id, base, zf.Modified, tmp, tag...)
+}
+
+// spoolZipFile copy the decompressed data of the zip entry to a temporary file, which is seeked to the start.
+func spoolZipFile(zf *zip.File) (*os.File, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "xfsus-zip-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = io.Copy(tmp, r); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// ImportUploadedZip saves the files of the uploaded zip archive to the xfs under the directory dir.
+// See ImportZip() for details.
+func ImportUploadedZip(fsys xfs.XFS, ma xfs.MIMEAllowlist, dir string, file *multipart.FileHeader, tag ...string) ([]*xfs.File, error) {
+	fr, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	zr, err := zip.NewReader(fr, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	return ImportZip(fsys, ma, dir, zr, tag...)
+}
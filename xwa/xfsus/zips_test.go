@@ -0,0 +1,224 @@
+package xfsus
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestWriteZipImportZip(t *testing.T) {
+	src := dirxfs.FS(t.TempDir())
+
+	for _, id := range []string{"/p/a.txt", "/p/d/b.txt", "/p/c.txt", "/q/x.txt"} {
+		if _, err := src.SaveFile(id, id, time.Now(), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ZipBatchSize = 2
+	defer func() { ZipBatchSize = 1000 }()
+
+	// the pager of the query is ignored
+	fq := &xfs.FileQuery{Prefix: "/p/"}
+	fq.Page, fq.Limit = 2, 1
+
+	buf := &bytes.Buffer{}
+	cnt, err := WriteZip(buf, src, fq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 3 {
+		t.Fatalf("WriteZip() = %d, want 3", cnt)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, zf.Name)
+	}
+	if want := "[a.txt c.txt d/b.txt]"; want != fmt.Sprint(names) {
+		t.Fatalf("zip entries = %v, want %v", names, want)
+	}
+
+	dst := dirxfs.FS(t.TempDir())
+	files, err := ImportZip(dst, nil, "/imp", zr, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("ImportZip() = %d files, want 3", len(files))
+	}
+
+	data, err := dst.ReadFile("/imp/d/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "/p/d/b.txt" {
+		t.Errorf("ReadFile() = %q", data)
+	}
+}
+
+func TestZipEntryPath(t *testing.T) {
+	sjis, err := japanese.ShiftJIS.NewEncoder().String("資料/テスト.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs := []struct {
+		name    string
+		nonUTF8 bool
+		want    string
+		err     error
+	}{
+		{"a/b.txt", false, "a/b.txt", nil},
+		{"資料/テスト.txt", false, "資料/テスト.txt", nil},
+		{sjis, true, "資料/テスト.txt", nil},
+		{`a\b.txt`, false, "a/b.txt", nil},
+		{"../evil.txt", false, "", ErrZipSlip},
+		{"a/../../evil.txt", false, "", ErrZipSlip},
+		{"/etc/passwd", false, "", ErrZipSlip},
+		{`C:\evil.txt`, false, "", ErrZipSlip},
+	}
+
+	for i, c := range cs {
+		a, err := ZipEntryPath(&zip.File{FileHeader: zip.FileHeader{Name: c.name, NonUTF8: c.nonUTF8}})
+		if a != c.want || !errors.Is(err, c.err) {
+			t.Errorf("#%d ZipEntryPath(%q) = (%q, %v), want (%q, %v)", i, c.name, a, err, c.want, c.err)
+		}
+	}
+}
+
+func TestImportZipLimits(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for i := range 3 {
+		w, err := zw.Create(fmt.Sprintf("%d.txt", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte("a"), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(n int, size int64) { ZipMaxEntries, ZipMaxSize = n, size }(ZipMaxEntries, ZipMaxSize)
+
+	cs := []struct {
+		entries int
+		size    int64
+		err     error
+	}{
+		{2, 0, ErrZipTooLarge},
+		{0, 299, ErrZipTooLarge},
+		{3, 300, nil},
+	}
+
+	for i, c := range cs {
+		ZipMaxEntries, ZipMaxSize = c.entries, c.size
+
+		dst := dirxfs.FS(t.TempDir())
+		files, err := ImportZip(dst, nil, "/imp", zr)
+		if !errors.Is(err, c.err) {
+			t.Errorf("#%d ImportZip() = %v, want %v", i, err, c.err)
+		}
+
+		if n, _ := dst.CountFiles(&xfs.FileQuery{}); n != len(files) || (c.err != nil && n != 0) {
+			t.Errorf("#%d CountFiles() = %d, saved %d", i, n, len(files))
+		}
+	}
+}
+
+type testScanner struct{}
+
+func (ts testScanner) Name() string {
+	return "test"
+}
+
+func (ts testScanner) Scan(ctx context.Context, r io.Reader) (*xfs.ScanResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &xfs.ScanResult{Infected: bytes.Contains(data, []byte("EICAR")), Signature: "Eicar"}, nil
+}
+
+func testZipReader(t *testing.T, entries ...string) *zip.Reader {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for i := 0; i < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestImportZipScanMIME(t *testing.T) {
+	sfs := xfs.NewScanFS(dirxfs.FS(t.TempDir()), dirxfs.FS(t.TempDir()), testScanner{})
+
+	// the MIME type is checked by the allowlist
+	zr := testZipReader(t, "a.txt", "hello", "b.html", "<html><script>alert(1)</script></html>")
+	files, err := ImportZip(sfs, xfs.MIMEAllowlist{"text/plain"}, "/mime", zr)
+	var me *xfs.MIMEError
+	if !errors.As(err, &me) || len(files) != 1 {
+		t.Fatalf("ImportZip(mime) = %d, %v, want %v", len(files), err, xfs.ErrMIMENotAllowed)
+	}
+	if _, err := sfs.FindFile("/mime/b.html"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FindFile(/mime/b.html) = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	// the data is scanned by the ScanFS
+	zr = testZipReader(t, "a.txt", "hello", "c.txt", "hello EICAR")
+	files, err = ImportZip(sfs, nil, "/scan", zr)
+	if !errors.Is(err, xfs.ErrInfected) || len(files) != 1 {
+		t.Fatalf("ImportZip(scan) = %d, %v, want %v", len(files), err, xfs.ErrInfected)
+	}
+
+	sr, err := sfs.ScanResult("/scan/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sr.Infected {
+		t.Errorf("ScanResult(/scan/c.txt) = %+v", sr)
+	}
+
+	// the ScanFS wrapped by a XFS wrapper is not bypassed
+	zr = testZipReader(t, "a.txt", "hello")
+	if _, err := ImportZip(xfs.NewCompressFS(sfs), nil, "/wrap", zr); !errors.Is(err, xfs.ErrScanWrapped) {
+		t.Errorf("ImportZip(wrapped) = %v, want %v", err, xfs.ErrScanWrapped)
+	}
+}
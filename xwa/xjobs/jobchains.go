@@ -59,12 +59,18 @@ func JobChainInitStates(jns ...string) []*JobRunState {
 	return states
 }
 
-func JobChainAbort(xjc xjm.JobChainer, tjm xjm.JobManager, jc *xjm.JobChain, reason string) error {
-	return jobChainAbortCancel(xjc, tjm, jc, xjm.JobStatusAborted, reason, tjm.AbortJob)
+func JobChainAbort(xjc xjm.JobChainer, tjm xjm.JobManager, jc *xjm.JobChain, reason string, actor ...*xjm.JobActor) error {
+	abort := func(jid int64, reason string) error {
+		return xjm.AbortJobBy(tjm, asg.First(actor), jid, reason)
+	}
+	return jobChainAbortCancel(xjc, tjm, jc, xjm.JobStatusAborted, reason, abort)
 }
 
-func JobChainCancel(xjc xjm.JobChainer, tjm xjm.JobManager, jc *xjm.JobChain, reason string) error {
-	return jobChainAbortCancel(xjc, tjm, jc, xjm.JobStatusCanceled, reason, tjm.CancelJob)
+func JobChainCancel(xjc xjm.JobChainer, tjm xjm.JobManager, jc *xjm.JobChain, reason string, actor ...*xjm.JobActor) error {
+	cancel := func(jid int64, reason string) error {
+		return xjm.CancelJobBy(tjm, asg.First(actor), jid, reason)
+	}
+	return jobChainAbortCancel(xjc, tjm, jc, xjm.JobStatusCanceled, reason, cancel)
 }
 
 func jobChainAbortCancel(xjc xjm.JobChainer, tjm xjm.JobManager, jc *xjm.JobChain, status, reason string, funcAbortCancel func(int64, string) error) error {
@@ -0,0 +1,142 @@
+package xjobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/asg"
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xjm"
+)
+
+type JobEventType string
+
+const (
+	JobEventAppended      JobEventType = "appended"
+	JobEventStarted       JobEventType = "started"
+	JobEventStateChanged  JobEventType = "state_changed"
+	JobEventFinished      JobEventType = "finished"
+	JobEventAborted       JobEventType = "aborted"
+	JobEventCanceled      JobEventType = "canceled"
+	JobEventChainFinished JobEventType = "chain_finished"
+)
+
+type JobEvent struct {
+	Type   JobEventType `json:"type"`
+	Time   time.Time    `json:"time"`
+	Tenant string       `json:"tenant,omitempty"`
+	JID    int64        `json:"jid,omitempty"`
+	CID    int64        `json:"cid,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Locale string       `json:"locale,omitempty"`
+	Status string       `json:"status,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	State  *JobState    `json:"state,omitempty"`
+}
+
+func (je *JobEvent) String() string {
+	return fmt.Sprintf("%s %s#%d", je.Type, je.Name, je.JID)
+}
+
+// JobEventHandler handle a published job event
+type JobEventHandler func(je *JobEvent)
+
+// JobEventBus dispatch job events to the subscribed handlers
+type JobEventBus struct {
+	mu sync.RWMutex
+	hs []JobEventHandler
+	ts [][]JobEventType
+
+	Logger log.Logger
+}
+
+func NewJobEventBus() *JobEventBus {
+	return &JobEventBus{}
+}
+
+// Subscribe add a handler for the specified event types.
+// If types is omitted, the handler receives all events.
+func (jeb *JobEventBus) Subscribe(h JobEventHandler, types ...JobEventType) {
+	jeb.mu.Lock()
+	defer jeb.mu.Unlock()
+
+	jeb.hs = append(jeb.hs, h)
+	jeb.ts = append(jeb.ts, types)
+}
+
+// Publish dispatch the event to the subscribed handlers synchronously.
+// The handlers are called without holding the lock, so a handler can call Subscribe().
+// A panic in a handler is logged and does not affect the other handlers.
+func (jeb *JobEventBus) Publish(je *JobEvent) {
+	if je.Time.IsZero() {
+		je.Time = time.Now()
+	}
+
+	for _, h := range jeb.handlers(je.Type) {
+		jeb.safeHandle(h, je)
+	}
+}
+
+// handlers returns a copy of the handlers which subscribe the event type
+func (jeb *JobEventBus) handlers(t JobEventType) []JobEventHandler {
+	jeb.mu.RLock()
+	defer jeb.mu.RUnlock()
+
+	var hs []JobEventHandler
+	for i, h := range jeb.hs {
+		if ts := jeb.ts[i]; len(ts) > 0 && !asg.Contains(ts, t) {
+			continue
+		}
+		hs = append(hs, h)
+	}
+	return hs
+}
+
+func (jeb *JobEventBus) safeHandle(h JobEventHandler, je *JobEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger := jeb.Logger
+			if logger == nil {
+				logger = log.GetLogger("JOB")
+			}
+			logger.Errorf("job event %s handler panic: %v", je, r)
+		}
+	}()
+
+	h(je)
+}
+
+// JEB global job event bus
+var JEB = NewJobEventBus()
+
+// NewJobEvent create a job event of the job
+func NewJobEvent(t JobEventType, job *xjm.Job) *JobEvent {
+	return &JobEvent{
+		Type:   t,
+		JID:    job.ID,
+		CID:    job.CID,
+		Name:   job.Name,
+		Locale: job.Locale,
+		Status: job.Status,
+		Error:  job.Error,
+	}
+}
+
+// Observe publish a JobEventAppended event when a job is appended by the JobManager.
+// The tenant is set to the published events (optional).
+// Returns false if the JobManager does not implement xjm.JobAppendNotifier.
+func (jeb *JobEventBus) Observe(jmr xjm.JobManager, tenant ...string) bool {
+	jan, ok := jmr.(xjm.JobAppendNotifier)
+	if !ok {
+		return false
+	}
+
+	tn := asg.First(tenant)
+	jan.OnJobAppended(func(job *xjm.Job) {
+		je := NewJobEvent(JobEventAppended, job)
+		je.Tenant = tn
+		jeb.Publish(je)
+	})
+	return true
+}
@@ -0,0 +1,172 @@
+package xjobs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xjm"
+)
+
+func TestJobEventBusPublish(t *testing.T) {
+	jeb := NewJobEventBus()
+
+	var all, fin []JobEventType
+	jeb.Subscribe(func(je *JobEvent) { all = append(all, je.Type) })
+	jeb.Subscribe(func(je *JobEvent) { fin = append(fin, je.Type) }, JobEventFinished, JobEventChainFinished)
+	jeb.Subscribe(func(je *JobEvent) { panic("handler panic") })
+
+	for _, et := range []JobEventType{JobEventStarted, JobEventFinished, JobEventChainFinished} {
+		jeb.Publish(&JobEvent{Type: et, JID: 1, Name: "test"})
+	}
+
+	if len(all) != 3 {
+		t.Errorf("all = %v, want 3 events", all)
+	}
+	if len(fin) != 2 || fin[0] != JobEventFinished || fin[1] != JobEventChainFinished {
+		t.Errorf("fin = %v, want [finished chain_finished]", fin)
+	}
+}
+
+func TestJobEventBusSubscribeInHandler(t *testing.T) {
+	jeb := NewJobEventBus()
+
+	var cnt int
+	jeb.Subscribe(func(je *JobEvent) {
+		jeb.Subscribe(func(je *JobEvent) { cnt++ })
+	}, JobEventStarted)
+
+	done := make(chan struct{})
+	go func() {
+		jeb.Publish(&JobEvent{Type: JobEventStarted})
+		jeb.Publish(&JobEvent{Type: JobEventFinished})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() deadlock")
+	}
+	if cnt != 1 {
+		t.Errorf("cnt = %d, want 1", cnt)
+	}
+}
+
+type testAppendNotifier struct {
+	xjm.JobManager
+	listener xjm.JobAppendListener
+}
+
+func (tan *testAppendNotifier) OnJobAppended(listener xjm.JobAppendListener) {
+	tan.listener = listener
+}
+
+func TestJobEventBusObserve(t *testing.T) {
+	jeb := NewJobEventBus()
+
+	var evts []*JobEvent
+	jeb.Subscribe(func(je *JobEvent) { evts = append(evts, je) }, JobEventAppended)
+
+	tan := &testAppendNotifier{}
+	if !jeb.Observe(tan, "t1") {
+		t.Fatal("Observe() = false")
+	}
+
+	tan.listener(&xjm.Job{ID: 3, Name: "test", Status: xjm.JobStatusPending})
+	if len(evts) != 1 || evts[0].JID != 3 || evts[0].Tenant != "t1" || evts[0].Type != JobEventAppended {
+		t.Errorf("events = %v", evts)
+	}
+}
+
+func TestJobWebhookHandle(t *testing.T) {
+	recv := make(chan string, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recv <- r.Header.Get(JobWebhookHeaderEvent)
+	}))
+	defer srv.Close()
+
+	jwh := NewJobWebhook(srv.URL)
+	jwh.Workers = 2
+	defer jwh.Close()
+
+	jwh.Handle(&JobEvent{Type: JobEventStarted, JID: 1})
+	jwh.Handle(&JobEvent{Type: JobEventFinished, JID: 1})
+
+	for range 2 {
+		select {
+		case <-recv:
+		case <-time.After(5 * time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+}
+
+func TestJobWebhookCloseTwice(t *testing.T) {
+	jwh := NewJobWebhook("http://localhost")
+	jwh.Close()
+	jwh.Close()
+}
+
+func TestJobWebhookDeliver(t *testing.T) {
+	var cnt atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if !JobWebhookVerify("secret", r.Header.Get(JobWebhookHeaderTimestamp), r.Header.Get(JobWebhookHeaderSignature), body) {
+			t.Errorf("invalid signature %q", r.Header.Get(JobWebhookHeaderSignature))
+		}
+		if r.Header.Get(JobWebhookHeaderEvent) != string(JobEventAborted) {
+			t.Errorf("event = %q", r.Header.Get(JobWebhookHeaderEvent))
+		}
+
+		je := &JobEvent{}
+		if err := json.Unmarshal(body, je); err != nil || je.JID != 12 || je.Error != "failed" {
+			t.Errorf("body = %s, err = %v", body, err)
+		}
+
+		if cnt.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	jwh := NewJobWebhook(srv.URL)
+	jwh.Secret = "secret"
+	jwh.RetryDelay = time.Millisecond
+	defer jwh.Close()
+
+	if err := jwh.Deliver(&JobEvent{Type: JobEventAborted, JID: 12, Error: "failed"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := cnt.Load(); n != 3 {
+		t.Errorf("requests = %d, want 3", n)
+	}
+}
+
+func TestJobWebhookDeliverClientError(t *testing.T) {
+	var cnt atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cnt.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	jwh := NewJobWebhook(srv.URL)
+	jwh.RetryDelay = time.Millisecond
+	defer jwh.Close()
+
+	if err := jwh.Deliver(&JobEvent{Type: JobEventFinished, JID: 1}); err == nil {
+		t.Error("expected error, got nil")
+	}
+	if n := cnt.Load(); n != 1 {
+		t.Errorf("requests = %d, want 1", n)
+	}
+}
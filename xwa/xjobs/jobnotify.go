@@ -0,0 +1,148 @@
+package xjobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/ini"
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pango/num"
+	"github.com/askasoft/pango/str"
+	"github.com/askasoft/pangox/xjm"
+	"github.com/askasoft/pangox/xwa/xmail"
+)
+
+type INotifyArg interface {
+	GetNotifyTo() string
+	SetNotifyTo(to string)
+}
+
+// NotifyArg the opt-in notification recipient stored in the job param
+type NotifyArg struct {
+	NotifyTo string `json:"notify_to,omitempty" form:"notify_to,strip" validate:"omitempty,email"`
+}
+
+func (na *NotifyArg) GetNotifyTo() string {
+	return na.NotifyTo
+}
+
+func (na *NotifyArg) SetNotifyTo(to string) {
+	na.NotifyTo = to
+}
+
+// JobNotice the data to render the job notification email template
+type JobNotice struct {
+	ID       int64         `json:"id"`
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	State    JobState      `json:"state"`
+	Link     string        `json:"link,omitempty"`
+}
+
+// NewJobNotice create a job notice of the job.
+// The start and end should be measured by the same (local) clock,
+// if start is zero, the job's created_at and updated_at (database clock) are used.
+// The link is built from the "[job] notifyLink" setting, "{id}" and "{name}" are replaced by the job's id and name.
+func NewJobNotice(job *xjm.Job, start, end time.Time) *JobNotice {
+	if start.IsZero() || end.IsZero() {
+		start, end = job.CreatedAt, job.UpdatedAt
+	}
+
+	jn := &JobNotice{
+		ID:       job.ID,
+		Name:     job.Name,
+		Status:   job.Status,
+		Error:    job.Error,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start).Truncate(time.Second),
+	}
+
+	// the encoded state may be a extended JobState (JobStateLx, JobStateLix...),
+	// ignore the decode error to send the notification anyway.
+	_ = xjm.Decode(job.State, &jn.State)
+
+	if link := ini.GetString("job", "notifyLink"); link != "" {
+		jn.Link = str.NewReplacer("{id}", num.Ltoa(job.ID), "{name}", job.Name).Replace(link)
+	}
+
+	return jn
+}
+
+// JobNotifyQueueSize the queue size of the notification emails to send asynchronously
+var JobNotifyQueueSize = 100
+
+// sendTemplateEmail render the template and send the email, replaced by the tests
+var sendTemplateEmail = xmail.SendTemplateHTMLEmail
+
+type jobNotify struct {
+	jmr xjm.JobManager
+	job *xjm.Job
+	to  string
+	jn  *JobNotice
+}
+
+var (
+	jnOnce  sync.Once
+	jnQueue chan *jobNotify
+)
+
+// notify queue the notification email to the NotifyTo recipient to send asynchronously.
+func (jr *JobRunner) notify() {
+	if jr.NotifyTo == "" {
+		return
+	}
+
+	joblog := jr.Log().GetLogger("JOB")
+
+	job, err := jr.GetJob()
+	if err != nil {
+		joblog.Errorf("Failed to send notification email to %q: %v", jr.NotifyTo, err)
+		return
+	}
+
+	jnOnce.Do(func() {
+		jnQueue = make(chan *jobNotify, max(JobNotifyQueueSize, 1))
+		go sendJobNotifies()
+	})
+
+	jn := &jobNotify{
+		jmr: jr.XJM(),
+		job: job,
+		to:  jr.NotifyTo,
+		jn:  NewJobNotice(job, jr.started, time.Now()),
+	}
+
+	select {
+	case jnQueue <- jn:
+	default:
+		joblog.Errorf("Failed to send notification email to %q: queue is full", jr.NotifyTo)
+	}
+}
+
+func sendJobNotifies() {
+	for jn := range jnQueue {
+		jn.send()
+	}
+}
+
+// send send the notification email by the "[job] notifyTemplate" template,
+// and record the delivery result to the job log.
+// The job log is added by the job manager, because the job runner's log may be closed.
+func (jn *jobNotify) send() {
+	level, msg := xjm.JobLogLevelInfo, fmt.Sprintf("Sent notification email to %q", jn.to)
+
+	tpl := ini.GetString("job", "notifyTemplate", "email/job_notify")
+	if err := sendTemplateEmail(jn.job.Locale, tpl, jn.to, jn.jn); err != nil {
+		level, msg = xjm.JobLogLevelError, fmt.Sprintf("Failed to send notification email to %q: %v", jn.to, err)
+	}
+
+	if err := jn.jmr.AddJobLog(jn.job.ID, time.Now(), level, msg); err != nil {
+		log.GetLogger("JOB").Errorf("Failed to add job #%d log %q: %v", jn.job.ID, msg, err)
+	}
+}
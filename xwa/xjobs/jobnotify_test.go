@@ -0,0 +1,141 @@
+package xjobs
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xjm"
+)
+
+const testNotifyTemplate = `<s>Job #{{.ID}} {{.Name}} {{.Status}}</s>
+{{.Error}} {{.State.Success}}/{{.State.Step}} {{.Duration}}`
+
+type testNotice struct {
+	to   string
+	body string
+}
+
+// testNotifySender render the testNotifyTemplate, and fails for the "fail@" recipients
+func testNotifySender(t *testing.T) chan *testNotice {
+	ch := make(chan *testNotice, 10)
+	tpl := template.Must(template.New("notify").Parse(testNotifyTemplate))
+
+	old := sendTemplateEmail
+	sendTemplateEmail = func(locale, tplName, toAddr string, data any) error {
+		var sb strings.Builder
+		if err := tpl.Execute(&sb, data); err != nil {
+			return err
+		}
+
+		ch <- &testNotice{to: toAddr, body: sb.String()}
+		if strings.HasPrefix(toAddr, "fail@") {
+			return errors.New("smtp failed")
+		}
+		return nil
+	}
+	t.Cleanup(func() { sendTemplateEmail = old })
+
+	return ch
+}
+
+func testWaitNotice(t *testing.T, ch chan *testNotice) *testNotice {
+	t.Helper()
+
+	select {
+	case tn := <-ch:
+		return tn
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification email not sent")
+		return nil
+	}
+}
+
+// testWaitJobLog wait the job log which message starts with the prefix
+func testWaitJobLog(t *testing.T, jmr xjm.JobManager, jid int64, level, prefix string) {
+	t.Helper()
+
+	for range 50 {
+		jls, err := jmr.GetJobLogs(jid, 0, 0, true, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, jl := range jls {
+			if jl.Level == level && strings.HasPrefix(jl.Message, prefix) {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("job #%d log [%s] %q not found", jid, level, prefix)
+}
+
+func testNotifyJobRunner(t *testing.T, jmr xjm.JobManager, name, to string) *JobRunner {
+	jid, err := jmr.AppendJob(0, name, "en", xjm.MustEncode(&NotifyArg{NotifyTo: to}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := jmr.GetJob(jid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jr := NewJobRunner(job, nil, jmr)
+	jr.JEB = nil
+	xjm.MustDecode(job.Param, &jr.NotifyArg)
+
+	if err := jr.Checkout(); err != nil {
+		t.Fatal(err)
+	}
+	return jr
+}
+
+func TestJobNotifyFinish(t *testing.T) {
+	ch := testNotifySender(t)
+	jmr := testJobManager(t)
+
+	jr := testNotifyJobRunner(t, jmr, "NotifyFinish", "done@example.com")
+	if err := jr.SetState(&JobState{Step: 3, Success: 2}); err != nil {
+		t.Fatal(err)
+	}
+	jr.Finish()
+
+	tn := testWaitNotice(t, ch)
+	if tn.to != "done@example.com" {
+		t.Errorf("to = %q", tn.to)
+	}
+
+	want := "Job #1 NotifyFinish " + xjm.JobStatusFinished
+	if !strings.HasPrefix(tn.body, "<s>"+want+"</s>") || !strings.Contains(tn.body, " 2/3 ") {
+		t.Errorf("body = %q", tn.body)
+	}
+
+	testWaitJobLog(t, jmr, jr.JobID(), xjm.JobLogLevelInfo, `Sent notification email to "done@example.com"`)
+}
+
+func TestJobNotifyAbort(t *testing.T) {
+	ch := testNotifySender(t)
+	jmr := testJobManager(t)
+
+	jr := testNotifyJobRunner(t, jmr, "NotifyAbort", "fail@example.com")
+	jr.Abort("canceled")
+
+	tn := testWaitNotice(t, ch)
+	if !strings.HasPrefix(tn.body, "<s>Job #1 NotifyAbort "+xjm.JobStatusAborted+"</s>") || !strings.Contains(tn.body, "canceled") {
+		t.Errorf("body = %q", tn.body)
+	}
+
+	testWaitJobLog(t, jmr, jr.JobID(), xjm.JobLogLevelError, `Failed to send notification email to "fail@example.com": smtp failed`)
+
+	// the failed abort does not send the notification again
+	jr.Abort("again")
+
+	select {
+	case tn := <-ch:
+		t.Errorf("unexpected notification %q", tn.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -74,15 +74,32 @@ type JobRunner struct {
 
 	xjc xjm.JobChainer
 
+	started time.Time
+
 	ChainArg
+	NotifyArg
 
 	JobChainContinue func(next *JobRunState) error
+
+	// Claimed the job is already checked out by the dispatcher (see TenantJobDispatcher.StartJobs()),
+	// Checkout() does not check out the job again.
+	Claimed bool
+
+	// JEB job event bus to publish the job events, default is the global JEB.
+	// Set to nil to disable publishing.
+	JEB *JobEventBus
 }
 
 func NewJobRunner(job *xjm.Job, xjc xjm.JobChainer, jmr xjm.JobManager, logger ...log.Logger) *JobRunner {
+	return NewTenantJobRunner("", job, xjc, jmr, logger...)
+}
+
+// NewTenantJobRunner create a JobRunner of the tenant's job, the published events are tagged with the tenant
+func NewTenantJobRunner(tenant string, job *xjm.Job, xjc xjm.JobChainer, jmr xjm.JobManager, logger ...log.Logger) *JobRunner {
 	jr := &JobRunner{
 		xjc:       xjc,
-		JobRunner: xjm.NewJobRunner(job, jmr, logger...),
+		JobRunner: xjm.NewTenantJobRunner(tenant, job, jmr, logger...),
+		JEB:       JEB,
 	}
 
 	return jr
@@ -102,11 +119,19 @@ func (jr *JobRunner) AddFailedItem(id int64, title, reason string) {
 }
 
 func (jr *JobRunner) Checkout() error {
-	if err := jr.JobRunner.Checkout(); err != nil {
+	if !jr.Claimed {
+		if err := jr.JobRunner.Checkout(); err != nil {
+			return err
+		}
+	}
+
+	if err := jr.jobChainCheckout(); err != nil {
 		return err
 	}
 
-	return jr.jobChainCheckout()
+	jr.started = time.Now()
+	jr.publishEvent(JobEventStarted, xjm.JobStatusRunning, "", nil)
+	return nil
 }
 
 func (jr *JobRunner) Start() JobContext {
@@ -129,13 +154,21 @@ func (jr *JobRunner) SetState(state IState) error {
 		return err
 	}
 
-	return jr.jobChainSetState(state)
+	if err := jr.jobChainSetState(state); err != nil {
+		return err
+	}
+
+	js := state.State()
+	jr.publishEvent(JobEventStateChanged, xjm.JobStatusRunning, "", &js)
+	return nil
 }
 
 func (jr *JobRunner) Abort(reason string) {
 	joblog := jr.Log().GetLogger("JOB")
 
+	aborted := true
 	if err := jr.JobRunner.Abort(reason); err != nil {
+		aborted = false
 		if !errors.Is(err, xjm.ErrJobMissing) {
 			joblog.Error(err)
 		}
@@ -146,7 +179,15 @@ func (jr *JobRunner) Abort(reason string) {
 		joblog.Error(err)
 	}
 
+	if aborted {
+		jr.publishEvent(JobEventAborted, xjm.JobStatusAborted, reason, nil)
+	}
+
 	joblog.Warn("ABORTED.")
+
+	if aborted {
+		jr.notify()
+	}
 }
 
 func (jr *JobRunner) Finish() {
@@ -160,12 +201,16 @@ func (jr *JobRunner) Finish() {
 		return
 	}
 
+	jr.publishEvent(JobEventFinished, xjm.JobStatusFinished, "", nil)
+
 	// Continue job chain
 	if err := jr.jobChainContinue(); err != nil {
 		joblog.Error(err)
 	}
 
 	joblog.Info("DONE.")
+
+	jr.notify()
 }
 
 func (jr *JobRunner) Done(err error) {
@@ -206,7 +251,11 @@ func (jr *JobRunner) Done(err error) {
 				joblog.Error(err)
 			}
 
+			jr.publishEvent(JobEventAborted, job.Status, job.Error, nil)
+
 			joblog.Warn("ABORTED.")
+
+			jr.notify()
 			return
 		case xjm.JobStatusCanceled:
 			// NOTE:
@@ -216,6 +265,8 @@ func (jr *JobRunner) Done(err error) {
 				joblog.Error(err)
 			}
 
+			jr.publishEvent(JobEventCanceled, job.Status, job.Error, nil)
+
 			joblog.Warn("CANCELED.")
 			return
 		default:
@@ -278,5 +329,46 @@ func (jr *JobRunner) jobChainContinue() error {
 	if next != nil {
 		return jr.JobChainContinue(next)
 	}
+	return jr.jobChainFinished()
+}
+
+func (jr *JobRunner) jobChainFinished() error {
+	if jr.JEB == nil {
+		return nil
+	}
+
+	jc, err := jr.xjc.GetJobChain(jr.ChainID())
+	if err != nil {
+		return err
+	}
+
+	if jc.IsFinished() {
+		jr.JEB.Publish(&JobEvent{
+			Type:   JobEventChainFinished,
+			Tenant: jr.Tenant(),
+			CID:    jc.ID,
+			Name:   jc.Name,
+			Status: jc.Status,
+		})
+	}
 	return nil
 }
+
+// ---------------------------------------------------------------------
+func (jr *JobRunner) publishEvent(t JobEventType, status, reason string, state *JobState) {
+	if jr.JEB == nil {
+		return
+	}
+
+	jr.JEB.Publish(&JobEvent{
+		Type:   t,
+		Tenant: jr.Tenant(),
+		JID:    jr.JobID(),
+		CID:    jr.ChainID(),
+		Name:   jr.JobName(),
+		Locale: jr.Locale(),
+		Status: status,
+		Error:  reason,
+		State:  state,
+	})
+}
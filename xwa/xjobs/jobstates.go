@@ -159,6 +159,25 @@ func (js *JobStateSix) IsCompleted() bool {
 	return js.IsSuccessLimited()
 }
 
+type JobLastFID struct {
+	LastFID string `json:"last_fid,omitempty"`
+}
+
+// GetLastFID get last file id
+func (jl *JobLastFID) GetLastFID() string {
+	return jl.LastFID
+}
+
+// SetLastFID set last file id
+func (jl *JobLastFID) SetLastFID(id string) {
+	jl.LastFID = id
+}
+
+type JobStateLfx struct {
+	JobStateLx
+	JobLastFID
+}
+
 type JobStateLixs struct {
 	JobStateLix
 	LastIDs []int64 `json:"last_ids,omitempty"`
@@ -0,0 +1,168 @@
+package xjobs
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/askasoft/pangox/xjm"
+	"github.com/askasoft/pangox/xsm"
+)
+
+// TenantJobDispatcher starts the pending jobs of the tenants (schemas) fairly.
+// The running jobs are registered to the Running JobsMap with the tenant as the key,
+// so JobsMap.Stats() groups the running jobs by tenant.
+type TenantJobDispatcher struct {
+	// SM the schema manager to list the tenants
+	SM xsm.SchemaManager
+
+	// JM returns the job manager of the tenant
+	JM func(tenant string) xjm.JobManager
+
+	// Filter filter the tenants (optional)
+	Filter func(tenant string) bool
+
+	// Running the running jobs map
+	Running *JobsMap
+
+	// MaxTotal maximum running jobs of all tenants
+	MaxTotal int
+
+	// MaxTenant maximum running jobs per tenant (0: unlimited)
+	MaxTenant int
+
+	// RID the runner id to checkout the jobs
+	RID int64
+
+	mu   sync.Mutex
+	next int
+}
+
+func NewTenantJobDispatcher(sm xsm.SchemaManager, jm func(tenant string) xjm.JobManager, maxTotal, maxTenant int) *TenantJobDispatcher {
+	return &TenantJobDispatcher{
+		SM:        sm,
+		JM:        jm,
+		Running:   NewJobsMap(),
+		MaxTotal:  maxTotal,
+		MaxTenant: maxTenant,
+	}
+}
+
+// Tenants list the tenants by the schema manager
+func (tjd *TenantJobDispatcher) Tenants() ([]string, error) {
+	ss, err := tjd.SM.ListSchemas()
+	if err != nil || tjd.Filter == nil {
+		return ss, err
+	}
+
+	ts := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if tjd.Filter(s) {
+			ts = append(ts, s)
+		}
+	}
+	return ts, nil
+}
+
+// Iterate call fn with the job manager of each tenant
+func (tjd *TenantJobDispatcher) Iterate(fn func(tenant string, tjm xjm.JobManager) error) error {
+	ts, err := tjd.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, tn := range ts {
+		if err := fn(tn, tjd.JM(tn)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartJobs find the pending jobs of the tenants and start them in round-robin order,
+// the tenant to start first is rotated on each call.
+// The job is checked out by the RID before it is started, so the job which is checked out by the other dispatcher is skipped.
+// run is called in a new goroutine with the tenant and the checked out job (the status is running),
+// the job runner created by run should set JobRunner.Claimed to true to skip the checkout.
+// The job is removed from the Running JobsMap after run returns.
+func (tjd *TenantJobDispatcher) StartJobs(run func(tenant string, tjm xjm.JobManager, job *xjm.Job)) error {
+	tjd.mu.Lock()
+	defer tjd.mu.Unlock()
+
+	free := tjd.MaxTotal - tjd.Running.Total()
+	if free <= 0 {
+		return nil
+	}
+
+	ts, err := tjd.Tenants()
+	if err != nil {
+		return err
+	}
+	if len(ts) == 0 {
+		return nil
+	}
+
+	// rotate tenants
+	tjd.next %= len(ts)
+	ts = append(ts[tjd.next:], ts[:tjd.next]...)
+	tjd.next++
+
+	tjms := make([]xjm.JobManager, len(ts))
+	pjss := make([][]*xjm.Job, len(ts))
+	for i, tn := range ts {
+		limit := free
+		if tjd.MaxTenant > 0 {
+			limit = min(limit, tjd.MaxTenant-tjd.Running.Count(tn))
+		}
+		if limit <= 0 {
+			continue
+		}
+
+		tjm := tjd.JM(tn)
+		pjs, err := tjm.FindJobs("", 0, limit, true, xjm.JobStatusPending)
+		if err != nil {
+			return err
+		}
+
+		tjms[i], pjss[i] = tjm, pjs
+	}
+
+	for r := 0; free > 0; r++ {
+		found := false
+		for i, tn := range ts {
+			if r >= len(pjss[i]) {
+				continue
+			}
+			found = true
+
+			job := pjss[i][r]
+			if err := tjms[i].CheckoutJob(job.ID, tjd.RID); err != nil {
+				if errors.Is(err, xjm.ErrJobCheckout) {
+					// checked out by the other dispatcher
+					continue
+				}
+				return err
+			}
+			job.RID, job.Status = tjd.RID, xjm.JobStatusRunning
+
+			tjd.start(tn, tjms[i], job, run)
+
+			if free--; free <= 0 {
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return nil
+}
+
+func (tjd *TenantJobDispatcher) start(tenant string, tjm xjm.JobManager, job *xjm.Job, run func(string, xjm.JobManager, *xjm.Job)) {
+	tjd.Running.AddJob(tenant, job)
+
+	go func() {
+		defer tjd.Running.DelJob(tenant, job)
+
+		run(tenant, tjm, job)
+	}()
+}
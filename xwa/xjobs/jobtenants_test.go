@@ -0,0 +1,147 @@
+package xjobs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pangox/xjm"
+	"github.com/askasoft/pangox/xsm"
+)
+
+type testTenantSM struct {
+	xsm.SchemaManager
+	tenants []string
+}
+
+func (tsm *testTenantSM) ListSchemas() ([]string, error) {
+	return tsm.tenants, nil
+}
+
+type testTenantJM struct {
+	xjm.JobManager
+	jobs []*xjm.Job
+
+	mu      sync.Mutex
+	claimed map[int64]int64
+}
+
+func (tjm *testTenantJM) FindJobs(name string, start, limit int, asc bool, status ...string) ([]*xjm.Job, error) {
+	return tjm.jobs[:min(limit, len(tjm.jobs))], nil
+}
+
+func (tjm *testTenantJM) CheckoutJob(jid, rid int64) error {
+	tjm.mu.Lock()
+	defer tjm.mu.Unlock()
+
+	if tjm.claimed == nil {
+		tjm.claimed = map[int64]int64{}
+	}
+	if _, ok := tjm.claimed[jid]; ok {
+		return xjm.ErrJobCheckout
+	}
+	tjm.claimed[jid] = rid
+	return nil
+}
+
+func TestTenantJobDispatcherStartJobs(t *testing.T) {
+	jms := map[string]*testTenantJM{
+		"a": {jobs: []*xjm.Job{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}},
+		"b": {jobs: []*xjm.Job{{ID: 5}}},
+		"c": {jobs: []*xjm.Job{{ID: 6}, {ID: 7}}},
+	}
+
+	tjd := NewTenantJobDispatcher(
+		&testTenantSM{tenants: []string{"a", "b", "c"}},
+		func(tenant string) xjm.JobManager { return jms[tenant] },
+		5, 2,
+	)
+	tjd.RID = 9
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		cnt = map[string]int{}
+	)
+
+	wg.Add(5)
+	block := make(chan struct{})
+	err := tjd.StartJobs(func(tenant string, tjm xjm.JobManager, job *xjm.Job) {
+		mu.Lock()
+		cnt[tenant]++
+		mu.Unlock()
+		wg.Done()
+		<-block
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if cnt["a"] != 2 || cnt["b"] != 1 || cnt["c"] != 2 {
+		t.Errorf("started = %v, want a:2 b:1 c:2", cnt)
+	}
+	if n := tjd.Running.Total(); n != 5 {
+		t.Errorf("running = %d, want 5", n)
+	}
+	if n := tjd.Running.Count("a"); n != 2 {
+		t.Errorf("running[a] = %d, want 2", n)
+	}
+	if rid, ok := jms["c"].claimed[7]; !ok || rid != 9 {
+		t.Errorf("claimed[c][7] = %d, %v, want 9", rid, ok)
+	}
+
+	close(block)
+}
+
+func TestTenantJobDispatcherClaimed(t *testing.T) {
+	tjm := &testTenantJM{
+		jobs:    []*xjm.Job{{ID: 1}, {ID: 2}},
+		claimed: map[int64]int64{1: 8}, // checked out by the other dispatcher
+	}
+
+	tjd := NewTenantJobDispatcher(
+		&testTenantSM{tenants: []string{"a"}},
+		func(tenant string) xjm.JobManager { return tjm },
+		5, 0,
+	)
+	tjd.RID = 9
+
+	started := make(chan *xjm.Job, 2)
+	err := tjd.StartJobs(func(tenant string, tjm xjm.JobManager, job *xjm.Job) {
+		started <- job
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := <-started
+	if job.ID != 2 || job.RID != 9 || job.Status != xjm.JobStatusRunning {
+		t.Errorf("started = %v, want #2 running by 9", job)
+	}
+
+	select {
+	case job = <-started:
+		t.Errorf("the claimed job %v is started", job)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestTenantJobRunnerClaimed(t *testing.T) {
+	tjm := &testTenantJM{claimed: map[int64]int64{1: 9}}
+	job := &xjm.Job{ID: 1, RID: 9, Status: xjm.JobStatusRunning}
+
+	// the checkout of the running job fails
+	jr := NewTenantJobRunner("a", job, nil, tjm)
+	jr.JEB = nil
+	if err := jr.Checkout(); !errors.Is(err, xjm.ErrJobCheckout) {
+		t.Errorf("Checkout() = %v, want %v", err, xjm.ErrJobCheckout)
+	}
+
+	// the job claimed by the dispatcher is not checked out again
+	jr.Claimed = true
+	if err := jr.Checkout(); err != nil {
+		t.Errorf("Checkout() = %v", err)
+	}
+}
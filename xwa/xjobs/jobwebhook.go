@@ -0,0 +1,198 @@
+package xjobs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xwa"
+)
+
+const (
+	JobWebhookHeaderEvent     = "X-Job-Event"
+	JobWebhookHeaderTimestamp = "X-Job-Timestamp"
+	JobWebhookHeaderSignature = "X-Job-Signature"
+)
+
+// JobWebhook posts the job events as JSON to a outgoing webhook url.
+// The request body is signed by HMAC-SHA256 with the Secret,
+// the signature "sha256=<hex>" is set to the X-Job-Signature header.
+// The events are queued by Handle() and delivered by a fixed number of worker goroutines,
+// the event is dropped (and logged) if the queue is full.
+type JobWebhook struct {
+	URL        string
+	Secret     string        // signing secret (default: xwa.Secret)
+	Timeout    time.Duration // request timeout (default: 30s)
+	MaxRetries int           // maximum retry count (default: 3)
+	RetryDelay time.Duration // first retry delay, doubled for each retry (default: 1s)
+	Workers    int           // delivery worker count (default: 1)
+	QueueSize  int           // event queue size (default: 1000)
+	Client     *http.Client
+	Logger     log.Logger
+
+	once  sync.Once
+	stop  sync.Once
+	queue chan *JobEvent
+	done  chan struct{}
+	httpc *http.Client
+}
+
+func NewJobWebhook(url string) *JobWebhook {
+	return &JobWebhook{
+		URL:        url,
+		Secret:     xwa.Secret,
+		Timeout:    time.Second * 30,
+		MaxRetries: 3,
+		RetryDelay: time.Second,
+		Workers:    1,
+		QueueSize:  1000,
+	}
+}
+
+// JobWebhookSign returns the hex encoded HMAC-SHA256 of "timestamp.body"
+func JobWebhookSign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// JobWebhookVerify verify the signature of the webhook request body
+func JobWebhookVerify(secret, timestamp, signature string, body []byte) bool {
+	sig := "sha256=" + JobWebhookSign(secret, timestamp, body)
+	return hmac.Equal([]byte(sig), []byte(signature))
+}
+
+func (jwh *JobWebhook) init() {
+	jwh.once.Do(func() {
+		jwh.queue = make(chan *JobEvent, max(jwh.QueueSize, 1))
+		jwh.done = make(chan struct{})
+		jwh.httpc = jwh.Client
+		if jwh.httpc == nil {
+			jwh.httpc = &http.Client{Timeout: jwh.Timeout}
+		}
+
+		for range max(jwh.Workers, 1) {
+			go jwh.work()
+		}
+	})
+}
+
+func (jwh *JobWebhook) work() {
+	for {
+		select {
+		case <-jwh.done:
+			return
+		case je := <-jwh.queue:
+			if err := jwh.Deliver(je); err != nil {
+				jwh.logger().Errorf("Failed to deliver job event %s to %q: %v", je, jwh.URL, err)
+			}
+		}
+	}
+}
+
+// Handle implements JobEventHandler, queue the event to deliver asynchronously.
+func (jwh *JobWebhook) Handle(je *JobEvent) {
+	jwh.init()
+
+	evt := *je
+	select {
+	case jwh.queue <- &evt:
+	default:
+		jwh.logger().Errorf("Failed to queue job event %s to %q: queue is full", &evt, jwh.URL)
+	}
+}
+
+// Close stop the delivery workers, the queued events are discarded.
+// It is safe to call Close() more than once.
+func (jwh *JobWebhook) Close() {
+	jwh.init()
+	jwh.stop.Do(func() {
+		close(jwh.done)
+	})
+}
+
+// Deliver post the event to the webhook url, retry with exponential backoff on failure.
+// The retry is stopped by Close().
+func (jwh *JobWebhook) Deliver(je *JobEvent) error {
+	jwh.init()
+
+	body, err := json.Marshal(je)
+	if err != nil {
+		return err
+	}
+
+	delay := jwh.RetryDelay
+	for i := 0; ; i++ {
+		retry, err := jwh.post(string(je.Type), body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || i >= jwh.MaxRetries {
+			return err
+		}
+
+		jwh.logger().Warnf("Retry #%d job event %s to %q after %v: %v", i+1, je, jwh.URL, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-jwh.done:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+func (jwh *JobWebhook) post(event string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, jwh.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set(JobWebhookHeaderEvent, event)
+	req.Header.Set(JobWebhookHeaderTimestamp, ts)
+	req.Header.Set(JobWebhookHeaderSignature, "sha256="+JobWebhookSign(jwh.Secret, ts, body))
+
+	res, err := jwh.client().Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("jobwebhook: POST %q - %s", jwh.URL, res.Status)
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500, err
+}
+
+func (jwh *JobWebhook) client() *http.Client {
+	jwh.init()
+	return jwh.httpc
+}
+
+func (jwh *JobWebhook) logger() log.Logger {
+	if jwh.Logger != nil {
+		return jwh.Logger
+	}
+	return log.GetLogger("JOB")
+}
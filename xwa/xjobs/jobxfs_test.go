@@ -0,0 +1,192 @@
+package xjobs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/askasoft/pango/sqx/sqlx"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xfs/dirxfs"
+	"github.com/askasoft/pangox/xfs/memxfs"
+	"github.com/askasoft/pangox/xjm"
+	"github.com/askasoft/pangox/xjm/sqlxjm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	testJobTableDDL = `CREATE TABLE jobs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	cid        INTEGER NOT NULL,
+	rid        INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	uid        INTEGER NOT NULL DEFAULT 0,
+	cip        TEXT NOT NULL DEFAULT '',
+	status     TEXT NOT NULL,
+	locale     TEXT NOT NULL,
+	param      TEXT NOT NULL,
+	state      TEXT NOT NULL,
+	result     TEXT NOT NULL,
+	error      TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+)`
+
+	testJobLogTableDDL = `CREATE TABLE job_logs (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	jid     INTEGER NOT NULL,
+	time    TIMESTAMP NOT NULL,
+	level   TEXT NOT NULL,
+	message TEXT NOT NULL
+)`
+)
+
+func testJobManager(t *testing.T) xjm.JobManager {
+	sdb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sdb.Close() })
+
+	// serialize the writes of the job and the job logs to avoid "database is locked"
+	sdb.SetMaxOpenConns(1)
+
+	db := sqlx.NewDB(sdb, "sqlite3", nil)
+	for _, ddl := range []string{testJobTableDDL, testJobLogTableDDL} {
+		if _, err := db.Exec(ddl); err != nil {
+			t.Fatalf("%v\n%s", err, ddl)
+		}
+	}
+	return sqlxjm.JM(db, "jobs", "job_logs")
+}
+
+// testCheckoutJob append a job with the param and state, and checkout it
+func testCheckoutJob(t *testing.T, jmr xjm.JobManager, name string, param, state any) *xjm.Job {
+	jid, err := jmr.AppendJob(0, name, "en", xjm.MustEncode(param))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jmr.CheckoutJob(jid, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if state != nil {
+		if err := jmr.SetJobState(jid, 1, xjm.MustEncode(state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := jmr.GetJob(jid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestXfsReencryptJob(t *testing.T) {
+	dfs := dirxfs.FS(t.TempDir())
+
+	k1, k2 := xfs.SecretKey("k1", "secret1"), xfs.SecretKey("k2", "secret2")
+
+	cfs, err := xfs.NewCryptFS(dfs, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if _, err := cfs.SaveFile(fmt.Sprintf("/r/%d.txt", i), "a.txt", time.Now(), []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if cfs, err = xfs.NewCryptFS(dfs, k2, k1); err != nil {
+		t.Fatal(err)
+	}
+
+	jmr := testJobManager(t)
+
+	// resume after "/r/1.txt"
+	state := &JobStateLfx{}
+	state.Step, state.Total, state.LastFID = 2, 5, "/r/1.txt"
+
+	job := testCheckoutJob(t, jmr, "XfsReencrypt", &XfsReencryptArg{Prefix: "/r/", Batch: 2}, state)
+
+	xrj := NewXfsReencryptJob(job, nil, jmr, cfs)
+	if err := xrj.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if xrj.Step != 5 || xrj.Success != 3 || xrj.Failure != 0 || xrj.LastFID != "/r/4.txt" {
+		t.Errorf("state = %+v", xrj.JobStateLfx)
+	}
+
+	// the skipped files are still encrypted by the old key
+	c2, _ := xfs.NewCryptFS(dfs, k2)
+	for i := range 5 {
+		_, err := c2.ReadFile(fmt.Sprintf("/r/%d.txt", i))
+		if (i < 2) != (err != nil) {
+			t.Errorf("ReadFile(%d) = %v", i, err)
+		}
+	}
+}
+
+func TestXfsMigrateJob(t *testing.T) {
+	src, dst := memxfs.FS(), memxfs.FS()
+	for i := range 5 {
+		id := fmt.Sprintf("/m/%d.txt", i)
+		if _, err := src.SaveFile(id, "a.txt", time.Now(), []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jmr := testJobManager(t)
+
+	// limited
+	arg := &XfsMigrateArg{Prefix: "/m/", Batch: 2, Limit: 3, Delete: true}
+	job := testCheckoutJob(t, jmr, "XfsMigrate", arg, nil)
+
+	xmj := NewXfsMigrateJob(job, nil, jmr, src, dst)
+	if err := xmj.Run(); !errors.Is(err, xjm.ErrJobComplete) {
+		t.Fatalf("Run() = %v, want %v", err, xjm.ErrJobComplete)
+	}
+
+	// the checkpoint is saved to the job state
+	if job, err := jmr.GetJob(job.ID); err != nil {
+		t.Fatal(err)
+	} else {
+		state := &JobStateLfx{}
+		xjm.MustDecode(job.State, state)
+		if state.Step != 3 || state.Limit != 3 || state.Total != 5 || state.Success != 3 || state.LastFID != "/m/2.txt" {
+			t.Errorf("state = %+v", state)
+		}
+	}
+
+	// resume the aborted job from the checkpoint
+	state := &JobStateLfx{}
+	state.Step, state.Total, state.LastFID = 3, 5, "/m/2.txt"
+
+	arg.Limit = 0
+	job = testCheckoutJob(t, jmr, "XfsMigrate", arg, state)
+
+	xmj = NewXfsMigrateJob(job, nil, jmr, src, dst)
+	if err := xmj.Run(); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	if xmj.Step != 5 || xmj.Success != 2 || xmj.LastFID != "/m/4.txt" {
+		t.Errorf("state = %+v", xmj.JobStateLfx)
+	}
+
+	for i := range 5 {
+		id := fmt.Sprintf("/m/%d.txt", i)
+		if bs, err := dst.ReadFile(id); err != nil || string(bs) != id {
+			t.Errorf("dst.ReadFile(%q) = %q, %v", id, bs, err)
+		}
+		if _, err := src.FindFile(id); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("src.FindFile(%q) = %v", id, err)
+		}
+	}
+}
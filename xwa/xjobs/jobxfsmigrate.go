@@ -0,0 +1,87 @@
+package xjobs
+
+import (
+	"fmt"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xjm"
+)
+
+// XfsMigrateArg the job parameter of the XfsMigrateJob
+type XfsMigrateArg struct {
+	Prefix string `json:"prefix,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Batch  int    `json:"batch,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+// XfsMigrateJob migrate the files from the source XFS to the destination XFS.
+// The source and destination must be the raw XFS which are not wrapped by the encoding wrappers, see xfs.Migrator.
+// The last migrated file id is saved to the job state, so the aborted job can be resumed.
+type XfsMigrateJob struct {
+	*JobRunner
+
+	JobStateLfx
+
+	Arg      XfsMigrateArg
+	Migrator *xfs.Migrator
+}
+
+func NewXfsMigrateJob(job *xjm.Job, xjc xjm.JobChainer, jmr xjm.JobManager, src, dst xfs.XFS, logger ...log.Logger) *XfsMigrateJob {
+	xmj := &XfsMigrateJob{
+		JobRunner: NewJobRunner(job, xjc, jmr, logger...),
+		Migrator:  xfs.NewMigrator(src, dst),
+	}
+
+	xjm.MustDecode(job.Param, &xmj.Arg)
+	xjm.MustDecode(job.State, &xmj.JobStateLfx)
+
+	xmj.Migrator.Prefix = xmj.Arg.Prefix
+	xmj.Migrator.Tag = xmj.Arg.Tag
+	xmj.Migrator.Delete = xmj.Arg.Delete
+	if xmj.Arg.Batch > 0 {
+		xmj.Migrator.Batch = xmj.Arg.Batch
+	}
+
+	return xmj
+}
+
+func (xmj *XfsMigrateJob) Run() error {
+	if err := InitState(xmj, xmj.Arg.Limit); err != nil {
+		return err
+	}
+
+	return StreamRun(xmj)
+}
+
+func (xmj *XfsMigrateJob) CountTargets() (int, error) {
+	return xmj.Migrator.CountFiles(xmj.LastFID)
+}
+
+func (xmj *XfsMigrateJob) SaveState() error {
+	return xmj.SetState(&xmj.JobStateLfx)
+}
+
+func (xmj *XfsMigrateJob) FindTargets() ([]*xfs.File, error) {
+	return xmj.Migrator.FindFiles(xmj.LastFID)
+}
+
+func (xmj *XfsMigrateJob) StreamHandle(ctx JobContext, f *xfs.File) error {
+	logger := xmj.Log()
+
+	xmj.Step++
+	xmj.LastFID = f.ID
+
+	if err := xmj.Migrator.MigrateFile(f); err != nil {
+		logger.Warnf("%s Failed to migrate file %q: %v", xmj.Progress(), f.ID, err)
+		_ = xmj.AddResult(fmt.Sprintf("%q\t%q\n", f.ID, err.Error()))
+		xmj.IncFailure()
+	} else {
+		logger.Infof("%s Migrated file %q (%d)", xmj.Progress(), f.ID, f.Size)
+		xmj.IncSuccess()
+	}
+
+	return xmj.SaveState()
+}
@@ -0,0 +1,94 @@
+package xjobs
+
+import (
+	"fmt"
+
+	"github.com/askasoft/pango/log"
+	"github.com/askasoft/pangox/xfs"
+	"github.com/askasoft/pangox/xjm"
+)
+
+// XfsReencryptArg the job parameter of the XfsReencryptJob
+type XfsReencryptArg struct {
+	Prefix string `json:"prefix,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Batch  int    `json:"batch,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// XfsReencryptJob re-encrypt the files of the CryptFS with the current key (see xfs.CryptFS.Reencrypt()).
+// The last processed file id is saved to the job state, so the aborted job can be resumed.
+type XfsReencryptJob struct {
+	*JobRunner
+
+	JobStateLfx
+
+	Arg     XfsReencryptArg
+	CryptFS *xfs.CryptFS
+}
+
+func NewXfsReencryptJob(job *xjm.Job, xjc xjm.JobChainer, jmr xjm.JobManager, cfs *xfs.CryptFS, logger ...log.Logger) *XfsReencryptJob {
+	xrj := &XfsReencryptJob{
+		JobRunner: NewJobRunner(job, xjc, jmr, logger...),
+		CryptFS:   cfs,
+	}
+
+	xjm.MustDecode(job.Param, &xrj.Arg)
+	xjm.MustDecode(job.State, &xrj.JobStateLfx)
+
+	return xrj
+}
+
+func (xrj *XfsReencryptJob) Run() error {
+	if err := InitState(xrj, xrj.Arg.Limit); err != nil {
+		return err
+	}
+
+	return StreamRun(xrj)
+}
+
+func (xrj *XfsReencryptJob) query() *xfs.FileQuery {
+	fq := &xfs.FileQuery{LastID: xrj.LastFID, Prefix: xrj.Arg.Prefix, Tag: xrj.Arg.Tag}
+	fq.Order = "id"
+	return fq
+}
+
+func (xrj *XfsReencryptJob) CountTargets() (int, error) {
+	return xrj.CryptFS.CountFiles(xrj.query())
+}
+
+func (xrj *XfsReencryptJob) SaveState() error {
+	return xrj.SetState(&xrj.JobStateLfx)
+}
+
+func (xrj *XfsReencryptJob) FindTargets() ([]*xfs.File, error) {
+	fq := xrj.query()
+	fq.Limit = xrj.Arg.Batch
+	if fq.Limit <= 0 {
+		fq.Limit = 1000
+	}
+	return xrj.CryptFS.FindFiles(fq)
+}
+
+func (xrj *XfsReencryptJob) StreamHandle(ctx JobContext, f *xfs.File) error {
+	logger := xrj.Log()
+
+	xrj.Step++
+	xrj.LastFID = f.ID
+
+	ok, err := xrj.CryptFS.Reencrypt(f.ID)
+	switch {
+	case err != nil:
+		logger.Warnf("%s Failed to re-encrypt file %q: %v", xrj.Progress(), f.ID, err)
+		_ = xrj.AddResult(fmt.Sprintf("%q\t%q\n", f.ID, err.Error()))
+		xrj.IncFailure()
+	case ok:
+		logger.Infof("%s Re-encrypted file %q (%d)", xrj.Progress(), f.ID, f.Size)
+		xrj.IncSuccess()
+	default:
+		logger.Debugf("%s Skip file %q encrypted by the current key", xrj.Progress(), f.ID)
+		xrj.IncSkipped()
+	}
+
+	return xrj.SaveState()
+}
@@ -1,11 +1,13 @@
 package xmwas
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/askasoft/pango/num"
 	"github.com/askasoft/pango/tbs"
 	"github.com/askasoft/pango/xin"
+	"github.com/askasoft/pangox/xfs"
 )
 
 func InvalidToken(c *xin.Context) {
@@ -29,3 +31,27 @@ func BodyTooLarge(c *xin.Context) {
 	}
 	c.Abort()
 }
+
+// QuotaExceeded responds 413 with the localized quota exceeded message if err is a xfs.ErrQuotaExceeded error.
+// Returns false if err is not a xfs.ErrQuotaExceeded error.
+func QuotaExceeded(c *xin.Context, err error) bool {
+	if !errors.Is(err, xfs.ErrQuotaExceeded) {
+		return false
+	}
+
+	var limit int64
+	var qee *xfs.QuotaExceededError
+	if errors.As(err, &qee) {
+		limit = qee.Quota.Limit
+	}
+
+	msg := tbs.Format(c.Locale, "error.request.quota", num.HumanSize(float64(limit)))
+
+	if xin.IsAjax(c) {
+		c.JSON(http.StatusRequestEntityTooLarge, xin.H{"error": msg})
+	} else {
+		c.String(http.StatusRequestEntityTooLarge, msg)
+	}
+	c.Abort()
+	return true
+}